@@ -103,8 +103,14 @@ value is the current HAMT.
 package hamt
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
 	"github.com/lleo/go-hamt-functional/hamt32"
 	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
 )
 
 // NewHamt32 returns an empty hamt32.Hamt value. Given that Hamt
@@ -118,3 +124,166 @@ func NewHamt32() hamt32.Hamt {
 func NewHamt64() hamt64.Hamt {
 	return hamt64.Hamt{}
 }
+
+// NewHamt32Sized returns an empty hamt32.Hamt value that is expected to hold
+// about n key/val pairs; see hamt32.NewSized.
+func NewHamt32Sized(n uint) hamt32.Hamt {
+	return hamt32.NewSized(n)
+}
+
+// NewHamt64Sized returns an empty hamt64.Hamt value that is expected to hold
+// about n key/val pairs; see hamt64.NewSized.
+func NewHamt64Sized(n uint) hamt64.Hamt {
+	return hamt64.NewSized(n)
+}
+
+// BuildFromSorted32 returns a hamt32.Hamt of the key/val pairs of sorted,
+// which must be in hash path order; see hamt32.BuildFromSorted.
+func BuildFromSorted32(sorted []key.KeyVal) hamt32.Hamt {
+	return hamt32.BuildFromSorted(sorted)
+}
+
+// BuildFromSorted64 returns a hamt64.Hamt of the key/val pairs of sorted,
+// which must be in hash path order; see hamt64.BuildFromSorted.
+func BuildFromSorted64(sorted []key.KeyVal) hamt64.Hamt {
+	return hamt64.BuildFromSorted(sorted)
+}
+
+// ShareRoot32 returns whether a and b are the same version of a hamt32.Hamt;
+// see hamt32.ShareRoot.
+func ShareRoot32(a, b hamt32.Hamt) bool {
+	return hamt32.ShareRoot(a, b)
+}
+
+// ShareRoot64 returns whether a and b are the same version of a hamt64.Hamt;
+// see hamt64.ShareRoot.
+func ShareRoot64(a, b hamt64.Hamt) bool {
+	return hamt64.ShareRoot(a, b)
+}
+
+// ShareAnyNode32 returns whether a and b share any table or leaf; see
+// hamt32.ShareAnyNode.
+func ShareAnyNode32(a, b hamt32.Hamt) bool {
+	return hamt32.ShareAnyNode(a, b)
+}
+
+// ShareAnyNode64 returns whether a and b share any table or leaf; see
+// hamt64.ShareAnyNode.
+func ShareAnyNode64(a, b hamt64.Hamt) bool {
+	return hamt64.ShareAnyNode(a, b)
+}
+
+// Modify32 atomically replaces the hamt32.Hamt p points to with fn applied to
+// it. If another goroutine replaced the Hamt while fn was running, fn is
+// applied again to the newer Hamt; so fn may be called more than once and
+// should not have side effects. A nil p.Load() is treated as an empty Hamt.
+func Modify32(p *atomic.Pointer[hamt32.Hamt], fn func(hamt32.Hamt) hamt32.Hamt) {
+	for {
+		var old = p.Load()
+
+		var h hamt32.Hamt
+		if old != nil {
+			h = *old
+		}
+
+		var nh = fn(h)
+		if p.CompareAndSwap(old, &nh) {
+			return
+		}
+	}
+}
+
+// Modify64 atomically replaces the hamt64.Hamt p points to with fn applied to
+// it. If another goroutine replaced the Hamt while fn was running, fn is
+// applied again to the newer Hamt; so fn may be called more than once and
+// should not have side effects. A nil p.Load() is treated as an empty Hamt.
+func Modify64(p *atomic.Pointer[hamt64.Hamt], fn func(hamt64.Hamt) hamt64.Hamt) {
+	for {
+		var old = p.Load()
+
+		var h hamt64.Hamt
+		if old != nil {
+			h = *old
+		}
+
+		var nh = fn(h)
+		if p.CompareAndSwap(old, &nh) {
+			return
+		}
+	}
+}
+
+// GetLayered32 looks up k in each of the layers, from left to right, and
+// returns the value from the first layer containing k. This lets layers of
+// hamt32.Hamt values, like overrides over defaults, be searched without
+// building a combined Hamt.
+func GetLayered32(k key.Key, layers ...hamt32.Hamt) (interface{}, bool) {
+	for _, h := range layers {
+		if val, found := h.Get(k); found {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// GetLayered64 looks up k in each of the layers, from left to right, and
+// returns the value from the first layer containing k. This lets layers of
+// hamt64.Hamt values, like overrides over defaults, be searched without
+// building a combined Hamt.
+func GetLayered64(k key.Key, layers ...hamt64.Hamt) (interface{}, bool) {
+	for _, h := range layers {
+		if val, found := h.Get(k); found {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// DiffString32 returns the changes from a to b, one line per key, sorted by
+// key: "+ key: val" for a key added in b, "- key: val" for a key removed from
+// a, and "~ key: old -> new" for a key whose val changed. Values are compared
+// with ==, so they must be comparable. It is built on b.Diff(a); so subtrees
+// a and b share are not walked. It is meant for debugging and test failure
+// messages; an empty string means a and b hold the same key/val pairs.
+func DiffString32(a, b hamt32.Hamt) string {
+	var added, removed, changed = b.Diff(a)
+	return diffString(added, removed, changed, a.Get)
+}
+
+// DiffString64 returns the changes from a to b, one line per key, sorted by
+// key; see DiffString32.
+func DiffString64(a, b hamt64.Hamt) string {
+	var added, removed, changed = b.Diff(a)
+	return diffString(added, removed, changed, a.Get)
+}
+
+// diffString() is DiffString32 and DiffString64, given the Diff of b from a
+// and the Get of a, for the old vals of the changed key/val pairs.
+func diffString(added, removed, changed []key.KeyVal, aGet func(key.Key) (interface{}, bool)) string {
+	type diffLine struct {
+		key, line string
+	}
+	var lines = make([]diffLine, 0, len(added)+len(removed)+len(changed))
+
+	for _, kv := range added {
+		var k = kv.Key.String()
+		lines = append(lines, diffLine{k, fmt.Sprintf("+ %s: %v", k, kv.Val)})
+	}
+	for _, kv := range removed {
+		var k = kv.Key.String()
+		lines = append(lines, diffLine{k, fmt.Sprintf("- %s: %v", k, kv.Val)})
+	}
+	for _, kv := range changed {
+		var k = kv.Key.String()
+		var old, _ = aGet(kv.Key)
+		lines = append(lines, diffLine{k, fmt.Sprintf("~ %s: %v -> %v", k, old, kv.Val)})
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+
+	var strs = make([]string, len(lines))
+	for i, l := range lines {
+		strs[i] = l.line
+	}
+	return strings.Join(strs, "\n")
+}
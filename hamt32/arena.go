@@ -0,0 +1,61 @@
+package hamt32
+
+import "sync"
+
+// UseArena variable controls whether compressedTable node slices are carved
+// out of large, contiguous []nodeI slabs instead of being allocated one by
+// one. This trades a little retained memory (a slab is only freed when every
+// table carved from it is garbage) for far fewer small allocations and less
+// GC pressure when bulk building a Hamt. This variable should not be changed
+// during the lifetime of any Hamt structure.
+// Default: false
+var UseArena = false
+
+// ArenaSlabSize variable is the number of nodeI entries in each arena slab.
+// Requests for more entries than this bypass the arena.
+// Default: 4096
+var ArenaSlabSize = 4096
+
+// nodeArena hands out non-overlapping sub-slices of its current slab. A slab
+// entry is never handed out twice, so the nodes of a frozen table are never
+// reused; that keeps the immutability contract intact. When the slab runs
+// out a new one is allocated and the old one is left to the GC.
+type nodeArena struct {
+	sync.Mutex
+	slab []nodeI
+}
+
+var arena nodeArena
+
+func (a *nodeArena) alloc(n int) []nodeI {
+	if n > ArenaSlabSize {
+		return allocCompressedNodes(n)
+	}
+
+	a.Lock()
+	if len(a.slab) < n {
+		a.slab = make([]nodeI, ArenaSlabSize)
+	}
+	// The 3-index slice caps the capacity at n, so an append() to the
+	// returned slice can never write into a neighbouring table's nodes.
+	var nodes = a.slab[:n:n]
+	a.slab = a.slab[n:]
+	a.Unlock()
+
+	return nodes
+}
+
+// makeNodes() is the allocator for all compressedTable node slices.
+func makeNodes(n int) []nodeI {
+	if UseArena {
+		return arena.alloc(n)
+	}
+	return allocCompressedNodes(n)
+}
+
+// allocCompressedNodes() allocates the node slice of one compressedTable; so
+// they have their own allocation site in a memory profile, apart from the
+// arena slabs.
+func allocCompressedNodes(n int) []nodeI {
+	return make([]nodeI, n)
+}
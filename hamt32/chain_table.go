@@ -0,0 +1,142 @@
+package hamt32
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// chainTable is a path-compressed run of single entry tables, where each
+// table's only entry is the next table of the run; as createCompressedTable()
+// builds for keys that share a long hash path prefix. A chainTable stands in
+// for the first table of the run, at depth. It holds the index of the only
+// entry of every table of the run, in idxs, and the table the run leads to,
+// in child, at depth+len(idxs). chainTables are only created by Hamt.Compact().
+//
+// As a tableI a chainTable behaves like the first table of the run; its only
+// entry is the rest of the run. So code walking the Trie one table at a time
+// still works; Hamt.Get() skips the whole run at once.
+type chainTable struct {
+	hashPath key.HashVal30 // depth*Nbits of hash to get to this location in the Trie
+	depth    uint
+	idxs     []uint
+	child    tableI
+}
+
+// Hash30() is required for nodeI
+func (t chainTable) Hash30() key.HashVal30 {
+	return t.hashPath
+}
+
+// String() is required for nodeI
+func (t chainTable) String() string {
+	return fmt.Sprintf("chainTable{hashPath:%s, depth=%d, idxs=%v}",
+		t.hashPath.HashPathString(t.depth), t.depth, t.idxs)
+}
+
+// LongString() is required for tableI
+func (t chainTable) LongString(indent string, recurse bool) string {
+	var strs = make([]string, 3)
+
+	strs[0] = indent + fmt.Sprintf("chainTable{hashPath=%s, t.depth=%d, idxs=%v,", t.hashPath.HashPathString(t.depth), t.depth, t.idxs)
+
+	if recurse {
+		strs[1] = indent + fmt.Sprintf(halfIndent+"t.child:\n%s", t.child.LongString(indent+fullIndent, recurse))
+	} else {
+		strs[1] = indent + fmt.Sprintf(halfIndent+"t.child: %s", t.child.String())
+	}
+
+	strs[2] = indent + "}"
+
+	return strings.Join(strs, "\n")
+}
+
+func (t chainTable) nentries() uint {
+	return 1
+}
+
+// matches() returns whether the hash path of h30 follows the whole run.
+func (t chainTable) matches(h30 key.HashVal30) bool {
+	for i, idx := range t.idxs {
+		if h30.Index(t.depth+uint(i)) != idx {
+			return false
+		}
+	}
+	return true
+}
+
+// next() returns the only entry of the first table of the run; either the
+// rest of the run or, for a run of one table, the child.
+func (t chainTable) next() nodeI {
+	if len(t.idxs) == 1 {
+		return t.child
+	}
+
+	var nt = new(chainTable)
+	nt.hashPath = t.hashPath | key.HashVal30(t.idxs[0])<<(t.depth*Nbits)
+	nt.depth = t.depth + 1
+	nt.idxs = t.idxs[1:]
+	nt.child = t.child
+	return nt
+}
+
+func (t chainTable) entries() []tableEntry {
+	return []tableEntry{{t.idxs[0], t.next()}}
+}
+
+func (t chainTable) entriesInto(buf []tableEntry) []tableEntry {
+	return append(buf, tableEntry{t.idxs[0], t.next()})
+}
+
+func (t chainTable) get(idx uint) nodeI {
+	if idx != t.idxs[0] {
+		return nil
+	}
+	return t.next()
+}
+
+// first() returns the first table of the run as a regular table; a fullTable
+// if full, otherwise a compressedTable. Its only entry is the rest of the run.
+func (t chainTable) first(full bool) tableI {
+	var ents = []tableEntry{{t.idxs[0], t.next()}}
+	if full {
+		return upgradeToFullTable(t.hashPath, t.depth, ents)
+	}
+	return downgradeToCompressedTable(t.hashPath, t.depth, ents)
+}
+
+// insert() ends the run at its first table; which becomes a regular table of
+// two entries, the rest of the run and the new entry. Hamt.insert() does not
+// call it; it inserts into t.first() itself, so the options of the Hamt apply.
+func (t chainTable) insert(idx uint, entry nodeI) tableI {
+	return t.first(FullTableInit).insert(idx, entry)
+}
+
+// replace() keeps the run compressed when entry is the new rest of the run.
+func (t chainTable) replace(idx uint, entry nodeI) tableI {
+	switch e := entry.(type) {
+	case *chainTable:
+		var nt = new(chainTable)
+		nt.hashPath = t.hashPath
+		nt.depth = t.depth
+		nt.idxs = make([]uint, 1+len(e.idxs))
+		nt.idxs[0] = idx
+		copy(nt.idxs[1:], e.idxs)
+		nt.child = e.child
+		return nt
+	case tableI:
+		var nt = new(chainTable)
+		nt.hashPath = t.hashPath
+		nt.depth = t.depth
+		nt.idxs = []uint{idx}
+		nt.child = e
+		return nt
+	}
+	return createSingleEntryTable(t.hashPath, t.depth, idx, entry)
+}
+
+// remove() removes the only entry; hence the table is empty.
+func (t chainTable) remove(idx uint) tableI {
+	return nil
+}
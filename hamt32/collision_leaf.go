@@ -2,22 +2,73 @@ package hamt32
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
 )
 
+// collisionLeaf holds the key/val pairs whose keys all have the same
+// Hash30(). Searching it compares the Hash60() of each key, cached in
+// discs, before calling Equals(); Hash60() is unrelated to Hash30(), so
+// Equals() is only called, in practice, for the key being searched for. The
+// key/val pairs are kept sorted by their discs, so a search is a binary search
+// of discs; see CollisionLinearSearch. discs is built with kvs and, like kvs,
+// never modified after.
 type collisionLeaf struct {
-	kvs []key.KeyVal
+	kvs   []key.KeyVal
+	discs []key.HashVal60 // discs[i] == kvs[i].Key.Hash60()
 }
 
 func newCollisionLeaf(kvs []key.KeyVal) *collisionLeaf {
 	leaf := new(collisionLeaf)
-	leaf.kvs = append(leaf.kvs, kvs...)
+	leaf.kvs = make([]key.KeyVal, len(kvs))
+	copy(leaf.kvs, kvs)
+
+	leaf.discs = make([]key.HashVal60, len(kvs))
+	for i := 0; i < len(kvs); i++ {
+		leaf.discs[i] = kvs[i].Key.Hash60()
+	}
+	sort.Stable(byDisc{leaf})
 
 	return leaf
 }
 
+// byDisc sorts the key/val pairs, and discs, of a collisionLeaf by disc.
+type byDisc struct {
+	l *collisionLeaf
+}
+
+func (s byDisc) Len() int           { return len(s.l.kvs) }
+func (s byDisc) Less(i, j int) bool { return s.l.discs[i] < s.l.discs[j] }
+func (s byDisc) Swap(i, j int) {
+	s.l.kvs[i], s.l.kvs[j] = s.l.kvs[j], s.l.kvs[i]
+	s.l.discs[i], s.l.discs[j] = s.l.discs[j], s.l.discs[i]
+}
+
+// index() returns the index of key in l.kvs, or -1 if it is not found.
+func (l collisionLeaf) index(key key.Key) int {
+	var disc = key.Hash60()
+
+	if CollisionLinearSearch {
+		for i := 0; i < len(l.kvs); i++ {
+			if l.discs[i] == disc && l.kvs[i].Key.Equals(key) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var i = sort.Search(len(l.discs), func(i int) bool { return l.discs[i] >= disc })
+	for ; i < len(l.discs) && l.discs[i] == disc; i++ {
+		if l.kvs[i].Key.Equals(key) {
+			return i
+		}
+	}
+	return -1
+}
+
 func (l collisionLeaf) Hash30() key.HashVal30 {
 	// valid because ALL l.kvs[*].Key.Hash30() MUST be the same key.HashVal30
 	return l.kvs[0].Key.Hash30()
@@ -34,8 +85,16 @@ func (l collisionLeaf) String() string {
 }
 
 func (l collisionLeaf) get(key key.Key) (interface{}, bool) {
+	if i := l.index(key); i >= 0 {
+		return l.kvs[i].Val, true
+	}
+	return nil, false
+}
+
+// getStr() is get() for a stringkey.StringKey given only its string.
+func (l collisionLeaf) getStr(s string) (interface{}, bool) {
 	for i := 0; i < len(l.kvs); i++ {
-		if l.kvs[i].Key.Equals(key) {
+		if sk, ok := l.kvs[i].Key.(*stringkey.StringKey); ok && sk.Str() == s {
 			return l.kvs[i].Val, true
 		}
 	}
@@ -45,8 +104,13 @@ func (l collisionLeaf) get(key key.Key) (interface{}, bool) {
 func (l collisionLeaf) copy() *collisionLeaf {
 	var nl = new(collisionLeaf)
 
-	// keep key.KeyVal containers, only this splice is new
-	nl.kvs = append(nl.kvs, l.kvs...)
+	// keep key.KeyVal containers, only this splice is new; exactly sized,
+	// append() would leave unused capacity for the lifetime of the leaf.
+	nl.kvs = make([]key.KeyVal, len(l.kvs))
+	copy(nl.kvs, l.kvs)
+
+	// same keys, so the same discs; which are never modified.
+	nl.discs = l.discs
 
 	return nl
 }
@@ -54,22 +118,39 @@ func (l collisionLeaf) copy() *collisionLeaf {
 // put insertes a new key,val pair into the leaf node, and returns a new leaf
 // and a bool representing if the new leaf is bigger (ie accumulated key/val pair).
 func (l collisionLeaf) put(key_ key.Key, val interface{}) (leafI, bool) {
-	var nl = l.copy()
-
 	// check if key_ is exact match of current key
 	// if exact match create new key.KeyVal container and update Val
 	// and return new leaf & bool
-	for i := 0; i < len(l.kvs); i++ {
-		if nl.kvs[i].Key.Equals(key_) { // Key.Equal() checks equal-by-value
+	if i := l.index(key_); i >= 0 { // Key.Equal() checks equal-by-value
+		var nl = l.copy()
 
-			// new key.KeyVal container, and keep the old l.kvs[i].Key object.
-			nl.kvs[i] = key.KeyVal{l.kvs[i].Key, val}
+		// new key.KeyVal container, and keep the old l.kvs[i].Key object.
+		nl.kvs[i] = key.KeyVal{l.kvs[i].Key, val}
 
-			return nl, false // key,val was not added, merely replaced Val
-		}
+		return nl, false // key,val was not added, merely replaced Val
+	}
+
+	// insert key_,val after any key/val pairs with the same disc.
+	var disc = key_.Hash60()
+	var i = sort.Search(len(l.discs), func(i int) bool { return l.discs[i] > disc })
+
+	var nl = new(collisionLeaf)
+	nl.kvs = make([]key.KeyVal, len(l.kvs)+1)
+	copy(nl.kvs, l.kvs[:i])
+	nl.kvs[i] = key.KeyVal{key_, val}
+	copy(nl.kvs[i+1:], l.kvs[i:])
+
+	nl.discs = make([]key.HashVal60, len(l.discs)+1)
+	copy(nl.discs, l.discs[:i])
+	nl.discs[i] = disc
+	copy(nl.discs[i+1:], l.discs[i:])
+
+	// the keys of the nested Hamt of an overflowLeaf never overflow again.
+	if _, isRehashed := key_.(*rehashKey); !isRehashed &&
+		CollisionOverflowThreshold > 0 && uint(len(nl.kvs)) > CollisionOverflowThreshold {
+		return newOverflowLeaf(nl.kvs), true // key_,val was added
 	}
 
-	nl.kvs = append(nl.kvs, key.KeyVal{key_, val})
 	return nl, true // key_,val was added
 }
 
@@ -82,27 +163,30 @@ func (l collisionLeaf) del(key_ key.Key) (leafI, interface{}, bool) {
 		// exhaustive search
 		// if key_ found new leaf will be a flatLeaf.
 		if l.kvs[0].Key.Equals(key_) {
-			return newFlatLeaf(l.kvs[1].Key, l.kvs[1].Val), l.kvs[0].Val, true
+			return createLeaf(l.kvs[1].Key, l.kvs[1].Val), l.kvs[0].Val, true
 		}
 		if l.kvs[1].Key.Equals(key_) {
-			return newFlatLeaf(l.kvs[0].Key, l.kvs[0].Val), l.kvs[1].Val, true
+			return createLeaf(l.kvs[0].Key, l.kvs[0].Val), l.kvs[1].Val, true
 		}
 
 		// key_ not found, hence no deletion occured
 		return nil, nil, false
 	}
 
-	var nl = l.copy()
+	if i := l.index(key_); i >= 0 {
+		var retVal = l.kvs[i].Val
 
-	for i := 0; i < len(l.kvs); i++ {
-		if l.kvs[i].Key.Equals(key_) {
-			var retVal = l.kvs[i].Val
+		// removing the i'th element into an exactly sized slice
+		var nl = new(collisionLeaf)
+		nl.kvs = make([]key.KeyVal, len(l.kvs)-1)
+		copy(nl.kvs, l.kvs[:i])
+		copy(nl.kvs[i:], l.kvs[i+1:])
 
-			// removing the i'th element of a slice; wiki/SliceTricks "Delete"
-			nl.kvs = append(nl.kvs[:i], nl.kvs[i+1:]...)
+		nl.discs = make([]key.HashVal60, len(l.discs)-1)
+		copy(nl.discs, l.discs[:i])
+		copy(nl.discs[i:], l.discs[i+1:])
 
-			return nl, retVal, true
-		}
+		return nl, retVal, true
 	}
 
 	return nil, nil, false
@@ -0,0 +1,12 @@
+package hamt32
+
+// DesyncNodeMap returns h with its root compressedTable replaced by a copy
+// holding one more node than its nodeMap has bits set; a corruption for
+// Validate to find. h must have a compressedTable root.
+func DesyncNodeMap(h Hamt) Hamt {
+	var ct = h.root.(*compressedTable)
+	var nt = ct.copyExceptNodes()
+	nt.nodes = append(ct.nodes[:len(ct.nodes):len(ct.nodes)], ct.nodes[0])
+	h.root = nt
+	return h
+}
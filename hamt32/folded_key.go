@@ -0,0 +1,103 @@
+package hamt32
+
+import (
+	"math/bits"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Fold selects how a Hamt created by NewFolded turns a key into the 30 bit
+// hash that is its path in the Trie. The key type is not changed; the Hamt
+// stores each key wrapped with its folded hash. Keys Put by PutRaw always use
+// the hash they were given.
+type Fold uint8
+
+const (
+	// FoldXor uses k.Hash30(); the key's own XOR fold of its 32 bit hash
+	// into 30 bits. It is the Fold of every Hamt not created by NewFolded.
+	FoldXor Fold = iota
+
+	// FoldNone uses the lowest 30 bits of k.Hash60(), without folding the
+	// higher bits into them.
+	FoldNone
+
+	// FoldMix uses the highest 30 bits of k.Hash60() times the 64 bit golden
+	// ratio constant, in reverse order. Every bit of k.Hash60() is mixed into
+	// them, and the best mixed ones index the tables nearest the root.
+	FoldMix
+)
+
+// goldenRatio64 is 2^64 divided by the golden ratio.
+const goldenRatio64 = 0x9e3779b97f4a7c15
+
+// hash30() returns the hash path of k for the Fold f.
+func (f Fold) hash30(k key.Key) key.HashVal30 {
+	if _, isRaw := k.(*rawKey); isRaw {
+		return k.Hash30()
+	}
+	switch f {
+	case FoldNone:
+		return key.HashVal30(uint64(k.Hash60()) & (1<<30 - 1))
+	case FoldMix:
+		return key.HashVal30(bits.Reverse64(uint64(k.Hash60())*goldenRatio64) & (1<<30 - 1))
+	}
+	return k.Hash30()
+}
+
+// NewFolded returns an empty Hamt that uses fold for the hash path of its
+// keys. Every Hamt derived from the returned Hamt uses the same fold. The keys
+// it returns, eg. from KeyVals(), are wrapped; UnfoldKey returns the key that
+// was Put.
+func NewFolded(fold Fold) Hamt {
+	return Hamt{fold: fold}
+}
+
+// foldedKey is a key.Key stored by a Hamt whose Fold is not FoldXor; its
+// Hash30() is the folded hash of the key it wraps.
+type foldedKey struct {
+	key.Key
+	hash30 key.HashVal30
+}
+
+func (k *foldedKey) Hash30() key.HashVal30 {
+	return k.hash30
+}
+
+func (k *foldedKey) Equals(k1 key.Key) bool {
+	return k.Key.Equals(UnfoldKey(k1))
+}
+
+// UnfoldKey returns the key that was Put for a key returned by a Hamt created
+// by NewFolded; any other key is returned as is.
+func UnfoldKey(k key.Key) key.Key {
+	if fk, isFolded := k.(*foldedKey); isFolded {
+		return fk.Key
+	}
+	return k
+}
+
+// PathHash returns the hash path of k in h; the h30 that GetH, PutH and DelH
+// must be given for k. It is k.Hash30() unless h was created by NewFolded.
+func (h Hamt) PathHash(k key.Key) key.HashVal30 {
+	return h.hash30(k)
+}
+
+// hash30() returns the hash path of k in h.
+func (h Hamt) hash30(k key.Key) key.HashVal30 {
+	if h.fold == FoldXor {
+		return k.Hash30()
+	}
+	return h.fold.hash30(UnfoldKey(k))
+}
+
+// foldKey() returns k as h stores it, given h30, its hash path in h.
+func (h Hamt) foldKey(k key.Key, h30 key.HashVal30) key.Key {
+	if h.fold == FoldXor {
+		return k
+	}
+	k = UnfoldKey(k)
+	if _, isRaw := k.(*rawKey); isRaw {
+		return k
+	}
+	return &foldedKey{k, h30}
+}
@@ -2,7 +2,6 @@ package hamt32
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/lleo/go-hamt-key"
@@ -15,10 +14,16 @@ type fullTable struct {
 	nodes    [TableCapacity]nodeI
 }
 
+// allocFullTable() is the allocator for all fullTables; so they have their
+// own allocation site in a memory profile.
+func allocFullTable() *fullTable {
+	return new(fullTable)
+}
+
 func createRootFullTable(leaf leafI) tableI {
 	var idx = leaf.Hash30().Index(0)
 
-	var ft = new(fullTable)
+	var ft = allocFullTable()
 	//ft.hashPath = 0
 	//ft.depth = 0
 	ft.numEnts = 1
@@ -27,8 +32,8 @@ func createRootFullTable(leaf leafI) tableI {
 	return ft
 }
 
-func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
-	var retTable = new(fullTable)
+func createFullTable(depth uint, leaf1 leafI, leaf2 leafI) tableI {
+	var retTable = allocFullTable()
 	retTable.hashPath = leaf1.Hash30() & key.HashPathMask30(depth-1)
 	retTable.depth = depth
 
@@ -52,7 +57,7 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		//hashPath = hashPath.BuildHashPath(idx1, d)
 		hashPath = leaf1.Hash30() & key.HashPathMask30(d)
 
-		var newTable = new(fullTable)
+		var newTable = allocFullTable()
 		newTable.hashPath = hashPath
 		newTable.depth = d + 1
 
@@ -81,17 +86,18 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		// leaf1.Hash30() == leaf2.Hash30() all the way to MaxDepth;
 		// because Hamt.createTable() is called only once, and after a
 		// leaf1.Hash30() == leaf2.Hash30() check. It is here for completeness.
-		log.Printf("full_table.go:createFullTable: SHOULD NOT BE CALLED")
+		logger.Printf("full_table.go:createFullTable: SHOULD NOT BE CALLED")
 
 		// Check if the path of leaf1 is not equal to the one leaf2 just traversed.
 		if leaf1.Hash30() != leaf2.Hash30() {
-			log.Printf("MaxDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
-			log.Panicf("createFullTable: %s,0x%06x != %s,0x%06x",
+			logger.Printf("MaxDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
+			logger.Panicf("createFullTable: %s,0x%06x != %s,0x%06x",
 				leaf1.Hash30(), leaf1.Hash30(), leaf2.Hash30(), leaf2.Hash30())
 		}
 
 		// Just for completeness; leaf1.Hash30() == leaf2.hash30()
-		var newLeaf, _ = leaf1.put(leaf2.key, leaf2.val)
+		var kv2 = leaf2.keyVals()[0]
+		var newLeaf, _ = leaf1.put(kv2.Key, kv2.Val)
 		curTable.nodes[idx1] = newLeaf
 	}
 
@@ -99,7 +105,7 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 }
 
 func upgradeToFullTable(hashPath key.HashVal30, depth uint, tabEnts []tableEntry) tableI {
-	var ft = new(fullTable)
+	var ft = allocFullTable()
 	ft.hashPath = hashPath
 	ft.depth = depth
 	ft.numEnts = uint(len(tabEnts))
@@ -118,13 +124,15 @@ func (t fullTable) Hash30() key.HashVal30 {
 
 // copy() is required for nodeI
 func (t fullTable) copy() *fullTable {
-	var nt = new(fullTable)
+	var nt = allocFullTable()
 	nt.hashPath = t.hashPath
 	nt.depth = t.depth
 	nt.numEnts = t.numEnts
 	//for i := 0; i < len(t.nodes); i++ {
 	//	nt.nodes[i] = t.nodes[i]
 	//}
+	// nodes is an array, not a slice; so this copies every entry, and nt
+	// never shares its nodes with t.
 	nt.nodes = t.nodes
 
 	return nt
@@ -138,16 +146,17 @@ func (t fullTable) nentries() uint {
 // This function MUST return the slice of tableEntry structs from lowest
 // tableEntry.idx to highest tableEntry.idx .
 func (t fullTable) entries() []tableEntry {
-	var n = t.nentries()
-	var ents = make([]tableEntry, n)
-	for i, j := uint(0), 0; i < TableCapacity; i++ {
+	return t.entriesInto(make([]tableEntry, 0, t.nentries()))
+}
+
+func (t fullTable) entriesInto(buf []tableEntry) []tableEntry {
+	for i := uint(0); i < TableCapacity; i++ {
 		if t.nodes[i] != nil {
 			//The difference with compressedTable is t.nodes[i] vs. t.nodes[j]
-			ents[j] = tableEntry{i, t.nodes[i]}
-			j++
+			buf = append(buf, tableEntry{i, t.nodes[i]})
 		}
 	}
-	return ents
+	return buf
 }
 
 // get() is required for tableI
@@ -170,21 +179,33 @@ func (t fullTable) replace(idx uint, entry nodeI) tableI {
 	return nt
 }
 
-//func (t fullTable) remove(idx uint) nodeI {
-func (t fullTable) remove(idx uint) tableI {
+// drop() returns a copy of t without the entry at idx; it is never
+// downgraded, even when empty.
+func (t fullTable) drop(idx uint) *fullTable {
 	// t.nodes[idx] != nil
 	var nt = t.copy()
 	nt.nodes[idx] = nil
 	nt.numEnts--
+	return nt
+}
 
-	if GradeTables && nt.numEnts < DowngradeThreshold {
-		return downgradeToCompressedTable(nt.hashPath, nt.depth, nt.entries())
-	}
+//func (t fullTable) remove(idx uint) nodeI {
+func (t fullTable) remove(idx uint) tableI {
+	var nt = t.drop(idx)
 
+	// Check for an empty table before downgrading, otherwise an empty
+	// compressedTable would be left in the Trie (or as the Hamt root).
 	if nt.numEnts == 0 {
 		return nil
 	}
 
+	if GradeTables && nt.numEnts < DowngradeThreshold {
+		var bufp = getTableEntryBuf()
+		var ct = downgradeToCompressedTable(nt.hashPath, nt.depth, nt.entriesInto(*bufp))
+		putTableEntryBuf(bufp)
+		return ct
+	}
+
 	return nt
 }
 
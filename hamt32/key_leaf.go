@@ -0,0 +1,66 @@
+package hamt32
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// keyLeaf is a flatLeaf without the val field; its val is always nil. Hamts
+// used as sets, with nil vals, save an interface{} (two words) per leaf.
+type keyLeaf struct {
+	key key.Key
+}
+
+func newKeyLeaf(key key.Key) *keyLeaf {
+	var kl = new(keyLeaf)
+	kl.key = key
+	return kl
+}
+
+// Hash30() is required for nodeI
+func (l keyLeaf) Hash30() key.HashVal30 {
+	return l.key.Hash30()
+}
+
+func (l keyLeaf) String() string {
+	return fmt.Sprintf("keyLeaf{key:key.Key(\"%s\")}", l.key)
+}
+
+func (l keyLeaf) get(key key.Key) (interface{}, bool) {
+	if l.key.Equals(key) {
+		return nil, true
+	}
+	return nil, false
+}
+
+func (l keyLeaf) getStr(s string) (interface{}, bool) {
+	if sk, ok := l.key.(*stringkey.StringKey); ok && sk.Str() == s {
+		return nil, true
+	}
+	return nil, false
+}
+
+// put inserts a new key/val pair. Returns new leaf node and a bool indicating if
+// the key/val pair was added?(true), or was a previous key/val pair updated?(false).
+func (l keyLeaf) put(k key.Key, v interface{}) (leafI, bool) {
+	if l.key.Equals(k) {
+		return createLeaf(k, v), false // did NOT add k/v pair
+	}
+
+	var nl = newCollisionLeaf([]key.KeyVal{key.KeyVal{Key: l.key}, key.KeyVal{Key: k, Val: v}})
+
+	return nl, true // added k,v pair
+}
+
+func (l keyLeaf) del(key key.Key) (leafI, interface{}, bool) {
+	if l.key.Equals(key) {
+		return nil, nil, true //deleted entry
+	}
+	return nil, nil, false //didn't delete
+}
+
+func (l keyLeaf) keyVals() []key.KeyVal {
+	return []key.KeyVal{key.KeyVal{Key: l.key}}
+}
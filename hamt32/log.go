@@ -0,0 +1,21 @@
+package hamt32
+
+import (
+	"io"
+	"log"
+)
+
+// logger receives all of the package's diagnostics, rather than the standard
+// logger, so the package does not write into a host application's logs.
+// By default it discards its output.
+var logger = log.New(io.Discard, "", 0)
+
+// SetLogger sets the *log.Logger the package writes its diagnostics to. A nil
+// l restores the default, which discards all output. Note, logger.Panicf()
+// still panics with a discarding logger.
+func SetLogger(l *log.Logger) {
+	if l == nil {
+		l = log.New(io.Discard, "", 0)
+	}
+	logger = l
+}
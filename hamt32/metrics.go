@@ -0,0 +1,77 @@
+package hamt32
+
+import "sync/atomic"
+
+// Metrics is a count of the operations on a Hamt created by WithMetrics, and
+// on every Hamt derived from it; they all share one count.
+type Metrics struct {
+	Gets      uint64 // calls of Get, GetH, GetStr, and the methods using them
+	GetHits   uint64 // Gets that found the key
+	GetMisses uint64 // Gets that did not find the key
+	Puts      uint64 // calls of Put, PutH, PutMerge, and the methods using them
+	Dels      uint64 // calls of Del, DelH, and the methods using them
+
+	// NodesCopied is the number of tables copied by Puts and Dels; one for
+	// each table on the path from the root to the changed leaf.
+	NodesCopied uint64
+}
+
+// WithMetrics returns h with a new, zeroed, Metrics; see Metrics. Every Hamt
+// derived from the returned Hamt adds to the same Metrics, which are updated
+// atomically; so Hamts shared between goroutines may count into them.
+// Counting costs an atomic add per count; a Hamt without metrics pays nothing.
+func (h Hamt) WithMetrics() Hamt {
+	h.metrics = new(Metrics)
+	return h
+}
+
+// Metrics returns a snapshot of the Metrics of h; the zero Metrics if h was
+// not derived from a Hamt created by WithMetrics.
+func (h Hamt) Metrics() Metrics {
+	if h.metrics == nil {
+		return Metrics{}
+	}
+	return Metrics{
+		Gets:        atomic.LoadUint64(&h.metrics.Gets),
+		GetHits:     atomic.LoadUint64(&h.metrics.GetHits),
+		GetMisses:   atomic.LoadUint64(&h.metrics.GetMisses),
+		Puts:        atomic.LoadUint64(&h.metrics.Puts),
+		Dels:        atomic.LoadUint64(&h.metrics.Dels),
+		NodesCopied: atomic.LoadUint64(&h.metrics.NodesCopied),
+	}
+}
+
+// countGet() counts a Get that found, or did not find, its key; if m is not
+// nil.
+func (m *Metrics) countGet(found bool) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.Gets, 1)
+	if found {
+		atomic.AddUint64(&m.GetHits, 1)
+	} else {
+		atomic.AddUint64(&m.GetMisses, 1)
+	}
+}
+
+// countPut() counts a Put; if m is not nil.
+func (m *Metrics) countPut() {
+	if m != nil {
+		atomic.AddUint64(&m.Puts, 1)
+	}
+}
+
+// countDel() counts a Del; if m is not nil.
+func (m *Metrics) countDel() {
+	if m != nil {
+		atomic.AddUint64(&m.Dels, 1)
+	}
+}
+
+// countCopy() counts a copied table; if m is not nil.
+func (m *Metrics) countCopy() {
+	if m != nil {
+		atomic.AddUint64(&m.NodesCopied, 1)
+	}
+}
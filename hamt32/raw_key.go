@@ -0,0 +1,62 @@
+package hamt32
+
+import (
+	"bytes"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// rawKey is the key.Key created by Hamt.PutRaw() from the caller's key bytes
+// and precomputed hash. A rawKey only Equals() another rawKey with the same
+// bytes. Its Hash60(), which the caller does not give, is hashed from the key
+// bytes; so colliding rawKeys are told apart by it like other keys are.
+type rawKey struct {
+	bytes  []byte
+	hash30 key.HashVal30
+	hash60 key.HashVal60
+}
+
+func newRawKey(keyBytes []byte, h30 uint32) *rawKey {
+	var k = new(rawKey)
+	// copy keyBytes, the caller may reuse it; Hamts are immutable.
+	k.bytes = append([]byte(nil), keyBytes...)
+	k.hash30 = key.HashVal30(h30)
+	k.hash60 = hashBytes60(k.bytes)
+	return k
+}
+
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+	mask60      uint64 = 1<<60 - 1
+)
+
+// hashBytes60() is a 60 bit FNV-1 hash of b, folded like hashString30() folds
+// its 32 bit hash.
+func hashBytes60(b []byte) key.HashVal60 {
+	var h64 = fnvOffset64
+	for i := 0; i < len(b); i++ {
+		h64 *= fnvPrime64
+		h64 ^= uint64(b[i])
+	}
+	return key.HashVal60((h64 >> 60) ^ (h64 & mask60))
+}
+
+func (k *rawKey) Equals(k1 key.Key) bool {
+	var rk, ok = k1.(*rawKey)
+	return ok && k.hash30 == rk.hash30 && bytes.Equal(k.bytes, rk.bytes)
+}
+
+func (k *rawKey) Hash30() key.HashVal30 {
+	return k.hash30
+}
+
+// Hash60() is hashed from the key bytes; only the 30 bit hash was given to
+// Hamt.PutRaw().
+func (k *rawKey) Hash60() key.HashVal60 {
+	return k.hash60
+}
+
+func (k *rawKey) String() string {
+	return string(k.bytes)
+}
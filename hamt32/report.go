@@ -0,0 +1,125 @@
+package hamt32
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// report accumulates the diagnostics of Hamt.Report() over one walk.
+type report struct {
+	fullTables, compTables, chainTables uint
+	linearTables                        uint
+	sparseFulls, denseComps             uint
+	flatLeafs, collLeafs, overLeafs     uint
+	entriesByDepth                      [MaxDepth + 1]uint
+	leafDepthSum                        uint
+	maxCollision                        uint
+	bytes                               uintptr
+}
+
+// Report returns a multi-line overview of the Hamt for tuning: the number of
+// entries; the number of tables and leafs of each type; a histogram of the
+// number of entries at each depth; the biggest collision leaf; the average
+// depth of a leaf; and an estimate of the bytes used by the tables and leafs,
+// not counting the keys and values they hold. It is built in one walk of the
+// Hamt.
+func (h Hamt) Report() string {
+	var r report
+	if h.root != nil {
+		r.table(h.root, 0)
+	}
+
+	var numLeafs = r.flatLeafs + r.collLeafs + r.overLeafs
+	var avgDepth float64
+	if numLeafs > 0 {
+		avgDepth = float64(r.leafDepthSum) / float64(numLeafs)
+	}
+
+	var hist = make([]string, len(r.entriesByDepth))
+	for depth, n := range r.entriesByDepth {
+		hist[depth] = fmt.Sprintf("%d:%d", depth, n)
+	}
+
+	var strs = []string{
+		fmt.Sprintf("entries: %d", h.nentries),
+		fmt.Sprintf("tables: %d (full: %d, compressed: %d, chain: %d, linear: %d)",
+			r.fullTables+r.compTables+r.chainTables+r.linearTables,
+			r.fullTables, r.compTables, r.chainTables, r.linearTables),
+		fmt.Sprintf("leafs: %d (flat: %d, collision: %d, overflow: %d)",
+			numLeafs, r.flatLeafs, r.collLeafs, r.overLeafs),
+		fmt.Sprintf("entries by depth: %s", strings.Join(hist, " ")),
+		fmt.Sprintf("max collision size: %d", r.maxCollision),
+		fmt.Sprintf("average leaf depth: %.2f", avgDepth),
+		fmt.Sprintf("estimated bytes: %d", r.bytes),
+	}
+
+	return strings.Join(strs, "\n")
+}
+
+func (r *report) table(t tableI, depth uint) {
+	switch x := t.(type) {
+	case *fullTable:
+		r.fullTables++
+		if x.nentries() < DowngradeThreshold {
+			r.sparseFulls++
+		}
+		r.bytes += unsafe.Sizeof(*x)
+	case *compressedTable:
+		r.compTables++
+		if x.nentries() > UpgradeThreshold {
+			r.denseComps++
+		}
+		r.bytes += unsafe.Sizeof(*x) + uintptr(cap(x.nodes))*unsafe.Sizeof(nodeI(nil))
+	case *linearTable:
+		r.linearTables++
+		r.bytes += unsafe.Sizeof(*x) + uintptr(cap(x.leafs))*unsafe.Sizeof(leafI(nil))
+		for _, l := range x.leafs {
+			r.leaf(l, depth)
+		}
+		return
+	case *chainTable:
+		r.chainTables++
+		r.bytes += unsafe.Sizeof(*x) + uintptr(cap(x.idxs))*unsafe.Sizeof(uint(0))
+		r.table(x.child, depth+uint(len(x.idxs)))
+		return
+	}
+
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			r.leaf(n, depth)
+		case tableI:
+			r.table(n, depth+1)
+		}
+	}
+}
+
+func (r *report) leaf(l leafI, depth uint) {
+	var size = uint(len(l.keyVals()))
+
+	switch x := l.(type) {
+	case flatLeaf, *flatLeaf:
+		r.flatLeafs++
+		r.bytes += unsafe.Sizeof(flatLeaf{})
+	case keyLeaf, *keyLeaf:
+		r.flatLeafs++
+		r.bytes += unsafe.Sizeof(keyLeaf{})
+	case *collisionLeaf:
+		r.collLeafs++
+		r.bytes += unsafe.Sizeof(*x) +
+			uintptr(cap(x.kvs))*unsafe.Sizeof(key.KeyVal{}) +
+			uintptr(cap(x.discs))*unsafe.Sizeof(key.HashVal60(0))
+	case overflowLeaf, *overflowLeaf:
+		r.overLeafs++
+		r.bytes += unsafe.Sizeof(overflowLeaf{}) + uintptr(size)*unsafe.Sizeof(key.KeyVal{})
+	}
+
+	r.entriesByDepth[depth] += size
+	r.leafDepthSum += depth
+	if size > r.maxCollision {
+		r.maxCollision = size
+	}
+}
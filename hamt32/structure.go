@@ -0,0 +1,330 @@
+package hamt32
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// The Kind of a structNode.
+const (
+	structFullTable = iota
+	structCompressedTable
+	structFlatLeaf
+	structKeyLeaf
+	structCollisionLeaf
+	structOverflowLeaf
+	structChainTable
+)
+
+// structHamt is the gob encoded form of a Hamt written by EncodeStructure.
+type structHamt struct {
+	Nentries   uint
+	SizeHint   uint
+	Hysteresis uint
+	Strict     bool
+	Fold       Fold
+	Root       *structNode
+}
+
+// structNode is the gob encoded form of one table or leaf of a Hamt.
+type structNode struct {
+	Kind     int
+	HashPath uint32 // hashPath of a table
+	Depth    uint
+	Idxs     []uint // the table index of each of Nodes, or a chainTable's idxs
+	Nodes    []structNode
+	Keys     []structKey
+	Vals     []interface{}
+}
+
+// structKey is the gob encoded form of a key; either a *stringkey.StringKey
+// or a key stored by PutRaw.
+type structKey struct {
+	Str   string
+	Raw   []byte
+	Hash  uint32
+	IsRaw bool
+}
+
+// EncodeStructure writes h to w as a gob with its exact structure; that is
+// the type, hashPath, depth, and entries of every table, and the type and
+// key/val pairs of every leaf. DecodeStructure reads it back as a Hamt with
+// the same structure; whereas Putting the same key/val pairs into a new Hamt
+// may create different tables. Keys must be *stringkey.StringKey or stored by
+// PutRaw. Vals must be encodable by encoding/gob; vals of non-basic types must
+// be registered with gob.Register(). The nested Hamt of an overflowLeaf is
+// written as its key/val pairs, and rebuilt from them.
+// The key/val pairs of a collision leaf are written sorted by the bytes of
+// their keys, not in the order they were Put in; so Hamts of the same
+// structure always encode to the same bytes.
+func (h Hamt) EncodeStructure(w io.Writer) error {
+	var sh = structHamt{Nentries: h.nentries, SizeHint: h.sizeHint, Hysteresis: h.hysteresis, Strict: h.strict, Fold: h.fold}
+
+	if h.root != nil {
+		var root, err = encodeNode(h.root)
+		if err != nil {
+			return err
+		}
+		sh.Root = &root
+	}
+
+	return gob.NewEncoder(w).Encode(sh)
+}
+
+func encodeNode(n nodeI) (structNode, error) {
+	var sn structNode
+
+	switch n := n.(type) {
+	case *fullTable:
+		sn.Kind = structFullTable
+		sn.HashPath = uint32(n.hashPath)
+		sn.Depth = n.depth
+		return sn, encodeEntries(&sn, n.entries())
+	case *compressedTable:
+		sn.Kind = structCompressedTable
+		sn.HashPath = uint32(n.hashPath)
+		sn.Depth = n.depth
+		return sn, encodeEntries(&sn, n.entries())
+	case *chainTable:
+		sn.Kind = structChainTable
+		sn.HashPath = uint32(n.hashPath)
+		sn.Depth = n.depth
+		sn.Idxs = n.idxs
+		var child, err = encodeNode(n.child)
+		sn.Nodes = []structNode{child}
+		return sn, err
+	case *linearTable:
+		return encodeNode(n.trie())
+	case *flatLeaf:
+		sn.Kind = structFlatLeaf
+		return sn, encodeKeyVals(&sn, n.keyVals(), true)
+	case *keyLeaf:
+		sn.Kind = structKeyLeaf
+		return sn, encodeKeyVals(&sn, n.keyVals(), false)
+	case *collisionLeaf:
+		sn.Kind = structCollisionLeaf
+		var err = encodeKeyVals(&sn, n.kvs, true)
+		sort.Sort(byKeyBytes{&sn})
+		return sn, err
+	case *overflowLeaf:
+		sn.Kind = structOverflowLeaf
+		var err = encodeKeyVals(&sn, n.keyVals(), true)
+		sort.Sort(byKeyBytes{&sn})
+		return sn, err
+	}
+
+	return sn, fmt.Errorf("hamt32: EncodeStructure: unknown node type %T", n)
+}
+
+func encodeEntries(sn *structNode, ents []tableEntry) error {
+	sn.Idxs = make([]uint, len(ents))
+	sn.Nodes = make([]structNode, len(ents))
+	for i, ent := range ents {
+		var err error
+		sn.Idxs[i] = ent.idx
+		sn.Nodes[i], err = encodeNode(ent.node)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeKeyVals(sn *structNode, kvs []key.KeyVal, withVals bool) error {
+	sn.Keys = make([]structKey, len(kvs))
+	if withVals {
+		sn.Vals = make([]interface{}, len(kvs))
+	}
+	for i, kv := range kvs {
+		switch k := UnfoldKey(kv.Key).(type) {
+		case *stringkey.StringKey:
+			sn.Keys[i] = structKey{Str: k.Str()}
+		case *rawKey:
+			sn.Keys[i] = structKey{Raw: k.bytes, Hash: uint32(k.hash30), IsRaw: true}
+		default:
+			return fmt.Errorf("hamt32: EncodeStructure: unsupported key type %T", k)
+		}
+		if withVals {
+			sn.Vals[i] = kv.Val
+		}
+	}
+	return nil
+}
+
+// bytes() returns the bytes of the key sk encodes.
+func (sk structKey) bytes() []byte {
+	if sk.IsRaw {
+		return sk.Raw
+	}
+	return []byte(sk.Str)
+}
+
+// byKeyBytes sorts the keys, and vals, of a structNode of a leaf by the bytes
+// of the keys; a *stringkey.StringKey before a PutRaw key of the same bytes.
+// The key/val pairs of a collision leaf are kept in an order that depends on
+// the order they were Put in; sorting them makes Hamts of the same key/val
+// pairs encode to the same bytes.
+type byKeyBytes struct {
+	sn *structNode
+}
+
+func (s byKeyBytes) Len() int { return len(s.sn.Keys) }
+func (s byKeyBytes) Less(i, j int) bool {
+	var a, b = s.sn.Keys[i], s.sn.Keys[j]
+	if c := bytes.Compare(a.bytes(), b.bytes()); c != 0 {
+		return c < 0
+	}
+	return !a.IsRaw && b.IsRaw
+}
+func (s byKeyBytes) Swap(i, j int) {
+	s.sn.Keys[i], s.sn.Keys[j] = s.sn.Keys[j], s.sn.Keys[i]
+	if len(s.sn.Vals) > 0 {
+		s.sn.Vals[i], s.sn.Vals[j] = s.sn.Vals[j], s.sn.Vals[i]
+	}
+}
+
+// DecodeStructure reads a Hamt, written by EncodeStructure, from r.
+func DecodeStructure(r io.Reader) (Hamt, error) {
+	var sh structHamt
+	if err := gob.NewDecoder(r).Decode(&sh); err != nil {
+		return Hamt{}, err
+	}
+
+	var h = Hamt{nentries: sh.Nentries, sizeHint: sh.SizeHint, hysteresis: sh.Hysteresis, strict: sh.Strict, fold: sh.Fold}
+
+	if sh.Root != nil {
+		var root, err = h.decodeNode(*sh.Root)
+		if err != nil {
+			return Hamt{}, err
+		}
+		var isTable bool
+		if h.root, isTable = root.(tableI); !isTable {
+			return Hamt{}, fmt.Errorf("hamt32: DecodeStructure: root is not a table")
+		}
+	}
+
+	return h, nil
+}
+
+// decodeNode() decodes sn, folding its keys as h does.
+func (h Hamt) decodeNode(sn structNode) (nodeI, error) {
+	switch sn.Kind {
+	case structFullTable, structCompressedTable:
+		if len(sn.Idxs) != len(sn.Nodes) {
+			return nil, fmt.Errorf("hamt32: DecodeStructure: %d idxs for %d table entries", len(sn.Idxs), len(sn.Nodes))
+		}
+		var ents = make([]tableEntry, len(sn.Nodes))
+		for i := range sn.Nodes {
+			if sn.Idxs[i] >= TableCapacity {
+				return nil, fmt.Errorf("hamt32: DecodeStructure: table idx %d out of range", sn.Idxs[i])
+			}
+			if i > 0 && sn.Idxs[i] <= sn.Idxs[i-1] {
+				return nil, fmt.Errorf("hamt32: DecodeStructure: table idx %d after %d; idxs must be ascending", sn.Idxs[i], sn.Idxs[i-1])
+			}
+			var node, err = h.decodeNode(sn.Nodes[i])
+			if err != nil {
+				return nil, err
+			}
+			ents[i] = tableEntry{sn.Idxs[i], node}
+		}
+		if sn.Kind == structFullTable {
+			return upgradeToFullTable(key.HashVal30(sn.HashPath), sn.Depth, ents), nil
+		}
+		return downgradeToCompressedTable(key.HashVal30(sn.HashPath), sn.Depth, ents), nil
+	case structChainTable:
+		if len(sn.Idxs) == 0 || len(sn.Nodes) != 1 {
+			return nil, fmt.Errorf("hamt32: DecodeStructure: chainTable of %d idxs and %d children", len(sn.Idxs), len(sn.Nodes))
+		}
+		var child, err = h.decodeNode(sn.Nodes[0])
+		if err != nil {
+			return nil, err
+		}
+		var childTable, isTable = child.(tableI)
+		if !isTable {
+			return nil, fmt.Errorf("hamt32: DecodeStructure: chainTable child is not a table")
+		}
+		return &chainTable{key.HashVal30(sn.HashPath), sn.Depth, sn.Idxs, childTable}, nil
+	}
+
+	var kvs = make([]key.KeyVal, len(sn.Keys))
+	for i, sk := range sn.Keys {
+		if sk.IsRaw {
+			kvs[i].Key = newRawKey(sk.Raw, sk.Hash)
+		} else {
+			var k = stringkey.New(sk.Str)
+			kvs[i].Key = h.foldKey(k, h.hash30(k))
+		}
+		if i < len(sn.Vals) {
+			kvs[i].Val = sn.Vals[i]
+		}
+	}
+
+	switch {
+	case sn.Kind == structFlatLeaf && len(kvs) == 1:
+		return newFlatLeaf(kvs[0].Key, kvs[0].Val), nil
+	case sn.Kind == structKeyLeaf && len(kvs) == 1:
+		return newKeyLeaf(kvs[0].Key), nil
+	case sn.Kind == structCollisionLeaf && len(kvs) > 1:
+		return newCollisionLeaf(kvs), nil
+	case sn.Kind == structOverflowLeaf && len(kvs) > 1:
+		return newOverflowLeaf(kvs), nil
+	}
+
+	return nil, fmt.Errorf("hamt32: DecodeStructure: bad leaf of kind %d with %d keys", sn.Kind, len(kvs))
+}
+
+// ErrChecksum is returned by Hamt.ReadFrom when the checksum written by
+// Hamt.WriteTo does not match the data read.
+var ErrChecksum = errors.New("hamt32: checksum mismatch")
+
+// WriteTo writes h to w as EncodeStructure does, followed by the big-endian
+// CRC-32 (IEEE) of that encoding; so Hamt.ReadFrom can detect corruption. It
+// implements io.WriterTo.
+func (h Hamt) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	if err := h.EncodeStructure(&buf); err != nil {
+		return 0, err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(sum[:])
+
+	return buf.WriteTo(w)
+}
+
+// ReadFrom reads a Hamt written by Hamt.WriteTo from r, until EOF, into h.
+// It returns ErrChecksum if the data read does not match its checksum; h is
+// not changed on any error. It implements io.ReaderFrom.
+func (h *Hamt) ReadFrom(r io.Reader) (int64, error) {
+	var data, err = io.ReadAll(r)
+	var n = int64(len(data))
+	if err != nil {
+		return n, err
+	}
+
+	if len(data) < 4 {
+		return n, ErrChecksum
+	}
+	var payload, sum = data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+		return n, ErrChecksum
+	}
+
+	var nh Hamt
+	if nh, err = DecodeStructure(bytes.NewReader(payload)); err != nil {
+		return n, err
+	}
+	*h = nh
+
+	return n, nil
+}
@@ -1,12 +1,18 @@
 package hamt_test
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-key"
 	"github.com/lleo/go-hamt-key/stringkey"
 )
 
@@ -150,6 +156,3855 @@ func TestDeleteAll32(t *testing.T) {
 	RunTime[name] = time.Since(StartTime[name])
 }
 
+func TestWalkWithContext32(t *testing.T) {
+	var name = "TestWalkWithContext32:" + CFG
+
+	if TestHamt32.IsEmpty() || TestHamt32.Nentries() != uint(len(KVS)) {
+		TestHamt32 = createHamt32(name, KVS, TYP)
+	}
+
+	StartTime[name] = time.Now()
+
+	var rootEnts, rootSiblings uint
+	TestHamt32.WalkWithContext(func(depth, siblings uint, isLeaf bool) bool {
+		if siblings == 0 || siblings > hamt32.TableCapacity {
+			t.Fatalf("depth=%d; siblings,%d not in [1..%d]", depth, siblings, hamt32.TableCapacity)
+		}
+		if depth == 0 {
+			rootEnts++
+			rootSiblings = siblings
+		}
+		return true
+	})
+
+	// With len(KVS) entries every root table slot is populated.
+	if rootEnts != hamt32.TableCapacity {
+		t.Fatalf("rootEnts,%d != hamt32.TableCapacity,%d", rootEnts, hamt32.TableCapacity)
+	}
+	if rootSiblings != rootEnts {
+		t.Fatalf("rootSiblings,%d != rootEnts,%d", rootSiblings, rootEnts)
+	}
+
+	var visited int
+	TestHamt32.WalkWithContext(func(depth, siblings uint, isLeaf bool) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("walk did not stop; visited,%d != 10", visited)
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestGetStr32(t *testing.T) {
+	var name = "TestGetStr32:" + CFG
+
+	if TestHamt32.IsEmpty() || TestHamt32.Nentries() != uint(len(KVS)) {
+		TestHamt32 = createHamt32(name, KVS, TYP)
+	}
+
+	StartTime[name] = time.Now()
+
+	for _, kv := range KVS {
+		var s = kv.Key.(*stringkey.StringKey).Str()
+
+		var val, found = TestHamt32.GetStr(s)
+		var val0, found0 = TestHamt32.Get(kv.Key)
+		if found != found0 || val != val0 {
+			t.Fatalf("GetStr(%q) => (%v, %t) != Get() => (%v, %t)", s, val, found, val0, found0)
+		}
+		if !found {
+			t.Fatalf("Failed to TestHamt32.GetStr(%q)", s)
+		}
+	}
+
+	var _, found = TestHamt32.GetStr("0 not a key")
+	if found {
+		t.Fatal("TestHamt32.GetStr(\"0 not a key\") found")
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestDeleteToEmpty32(t *testing.T) {
+	var name = "TestDeleteToEmpty32:" + CFG
+	StartTime[name] = time.Now()
+
+	var kvs = buildKeyVals(name, 10*1024, "aaa", 0)
+
+	for _, typ := range []int{hybrid, componly, fullonly} {
+		var h = createHamt32(name, kvs, typ)
+
+		for _, kv := range kvs {
+			var deleted bool
+			h, _, deleted = h.Del(kv.Key)
+			if !deleted {
+				t.Fatalf("%s: failed to h.Del(%s)", cfgStr[typ], kv.Key)
+			}
+		}
+
+		// IsEmpty() checks root == nil, so this also asserts no empty root table.
+		if !h.IsEmpty() {
+			t.Fatalf("%s: !h.IsEmpty() after deleting all entries; h=%s", cfgStr[typ], h)
+		}
+		if h.Nentries() != 0 {
+			t.Fatalf("%s: h.Nentries(),%d != 0", cfgStr[typ], h.Nentries())
+		}
+
+		var added bool
+		h, added = h.Put(kvs[0].Key, kvs[0].Val)
+		if !added {
+			t.Fatalf("%s: failed to h.Put(%s) into emptied Hamt", cfgStr[typ], kvs[0].Key)
+		}
+		var val, found = h.Get(kvs[0].Key)
+		if !found || val != kvs[0].Val {
+			t.Fatalf("%s: h.Get(%s) => (%v, %t)", cfgStr[typ], kvs[0].Key, val, found)
+		}
+	}
+
+	setLibrary(TYP)
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestGetKind32(t *testing.T) {
+	var k0 = stringkey.New("aaa")
+	var k1 = stringkey.New("ewwd")  // H30=/00/28/10/00/26/13
+	var k2 = stringkey.New("fwdyy") // H30=/00/28/10/00/26/13
+
+	var h hamt32.Hamt
+	h, _ = h.Put(k0, 0)
+	h, _ = h.Put(k1, 1)
+	h, _ = h.Put(k2, 2)
+
+	var val, kind, found = h.GetKind(k0)
+	if !found || val != 0 || kind != "flat" {
+		t.Fatalf("h.GetKind(%s) => (%v, %q, %t)", k0, val, kind, found)
+	}
+
+	val, kind, found = h.GetKind(k2)
+	if !found || val != 2 || kind != "collision" {
+		t.Fatalf("h.GetKind(%s) => (%v, %q, %t)", k2, val, kind, found)
+	}
+
+	val, kind, found = h.GetKind(stringkey.New("zzz"))
+	if found || val != nil || kind != "" {
+		t.Fatalf("h.GetKind(\"zzz\") => (%v, %q, %t)", val, kind, found)
+	}
+}
+
+// rootTableType32() returns "fullTable" or "compressedTable" by picking apart
+// h.LongString(""); table types are not exported.
+func rootTableType32(h hamt32.Hamt) string {
+	var lines = strings.SplitN(h.LongString(""), "\n", 3)
+	if len(lines) < 2 {
+		return ""
+	}
+	return strings.SplitN(strings.TrimSpace(lines[1]), "{", 2)[0]
+}
+
+func TestGradeHysteresis32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	// One key per root table index, so every Put() adds a root table entry.
+	var keys []key.Key
+	var seen = make(map[uint]bool)
+	for s := "aaa"; len(keys) < int(hamt32.TableCapacity); s = Inc(s) {
+		var k = stringkey.New(s)
+		var idx = k.Hash30().Index(0)
+		if !seen[idx] {
+			seen[idx] = true
+			keys = append(keys, k)
+		}
+	}
+
+	var checkRoot = func(h hamt32.Hamt, wantFull bool) {
+		t.Helper()
+		var full, compressed = h.TableComposition()
+		if wantFull && (full != 1 || compressed != 0) {
+			t.Fatalf("Nentries()=%d; root is not a fullTable; full=%d, compressed=%d", h.Nentries(), full, compressed)
+		}
+		if !wantFull && (full != 0 || compressed != 1) {
+			t.Fatalf("Nentries()=%d; root is not a compressedTable; full=%d, compressed=%d", h.Nentries(), full, compressed)
+		}
+	}
+
+	var grades int
+	var plain hamt32.Hamt
+	var h = hamt32.Hamt{}.WithGradeHysteresis(2).OnGrade(
+		func(depth uint, from, to string) { grades++ })
+
+	var n = hamt32.UpgradeThreshold - 1
+	for i := uint(0); i < n; i++ {
+		plain, _ = plain.Put(keys[i], i)
+		h, _ = h.Put(keys[i], i)
+	}
+	checkRoot(h, false)
+
+	// Without GradeHysteresis the root is upgraded at UpgradeThreshold.
+	var plainUp, _ = plain.Put(keys[n], n)
+	checkRoot(plainUp, true)
+
+	// Churn across UpgradeThreshold; GradeHysteresis keeps it compressed.
+	for i := 0; i < 4; i++ {
+		h, _ = h.Put(keys[n], n)
+		checkRoot(h, false)
+		h, _, _ = h.Del(keys[n])
+		checkRoot(h, false)
+	}
+	if grades != 0 {
+		t.Fatalf("grades,%d != 0 after churning below the upgrade point", grades)
+	}
+
+	var upAt = hamt32.UpgradeThreshold + 2
+	for i := n; i < upAt; i++ {
+		h, _ = h.Put(keys[i], i)
+	}
+	checkRoot(h, true)
+	if grades != 1 {
+		t.Fatalf("grades,%d != 1 after growing to the upgrade point", grades)
+	}
+
+	// Churn across the upgrade point; once full it stays full.
+	for i := 0; i < 4; i++ {
+		h, _, _ = h.Del(keys[upAt-1])
+		checkRoot(h, true)
+		h, _ = h.Put(keys[upAt-1], upAt-1)
+		checkRoot(h, true)
+	}
+	if grades != 1 {
+		t.Fatalf("grades,%d != 1 after churning above the upgrade point", grades)
+	}
+
+	// Every Hamt derived from h keeps its GradeHysteresis.
+	var cleared = h.Clear()
+	for i := uint(0); i <= n; i++ {
+		cleared, _ = cleared.Put(keys[i], i)
+	}
+	checkRoot(cleared, false)
+}
+
+// collidingKey is a stringkey with a constant Hash30(), so every collidingKey
+// ends up in the same collision (or overflow) leaf at MaxDepth.
+type collidingKey struct {
+	*stringkey.StringKey
+}
+
+func (k collidingKey) Hash30() key.HashVal30 {
+	return key.HashVal30(0x2aaaaaaa)
+}
+
+func (k collidingKey) Equals(k1 key.Key) bool {
+	var ck, ok = k1.(collidingKey)
+	return ok && ck.Str() == k.Str()
+}
+
+func buildCollidingKeys(num int) []key.Key {
+	var keys = make([]key.Key, num)
+	var s = "aaa"
+	for i := 0; i < num; i++ {
+		keys[i] = collidingKey{stringkey.New(s)}
+		s = Inc(s)
+	}
+	return keys
+}
+
+func TestCollisionOverflow32(t *testing.T) {
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = 8
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	var keys = buildCollidingKeys(4096)
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		var added bool
+		h, added = h.Put(k, i)
+		if !added {
+			t.Fatalf("failed to h.Put(%s, %d)", k, i)
+		}
+	}
+
+	if h.Nentries() != uint(len(keys)) {
+		t.Fatalf("h.Nentries(),%d != len(keys),%d", h.Nentries(), len(keys))
+	}
+
+	for i, k := range keys {
+		var val, kind, found = h.GetKind(k)
+		if !found || val != i || kind != "overflow" {
+			t.Fatalf("h.GetKind(%s) => (%v, %q, %t)", k, val, kind, found)
+		}
+	}
+
+	var h1, _ = h.Put(keys[0], -1)
+	if val, _ := h1.Get(keys[0]); val != -1 {
+		t.Fatalf("h1.Get(%s),%v != -1 after update", keys[0], val)
+	}
+	if val, _ := h.Get(keys[0]); val != 0 {
+		t.Fatalf("h.Get(%s),%v != 0; original Hamt was modified", keys[0], val)
+	}
+
+	for i, k := range keys {
+		var val interface{}
+		var deleted bool
+		h, val, deleted = h.Del(k)
+		if !deleted || val != i {
+			t.Fatalf("h.Del(%s) => (%v, %t)", k, val, deleted)
+		}
+
+		var remaining = len(keys) - i - 1
+		if remaining == 0 {
+			break
+		}
+
+		var expected = "overflow"
+		if remaining == 1 {
+			expected = "flat"
+		} else if uint(remaining) <= hamt32.CollisionOverflowThreshold {
+			expected = "collision"
+		}
+		var _, kind, _ = h.GetKind(keys[len(keys)-1])
+		if kind != expected {
+			t.Fatalf("remaining=%d; kind,%q != %q", remaining, kind, expected)
+		}
+	}
+
+	if !h.IsEmpty() {
+		t.Fatalf("!h.IsEmpty() after deleting all keys; h=%s", h)
+	}
+}
+
+func TestEntriesByDepth32(t *testing.T) {
+	var name = "TestEntriesByDepth32:" + CFG
+
+	if TestHamt32.IsEmpty() || TestHamt32.Nentries() != uint(len(KVS)) {
+		TestHamt32 = createHamt32(name, KVS, TYP)
+	}
+
+	StartTime[name] = time.Now()
+
+	var byDepth = TestHamt32.EntriesByDepth()
+	if uint(len(byDepth)) != hamt32.MaxDepth+1 {
+		t.Fatalf("len(byDepth),%d != hamt32.MaxDepth+1,%d", len(byDepth), hamt32.MaxDepth+1)
+	}
+
+	var vals = make(map[string]interface{}, len(KVS))
+	for _, kvs := range byDepth {
+		for _, kv := range kvs {
+			var s = kv.Key.String()
+			if _, exists := vals[s]; exists {
+				t.Fatalf("key %s returned more than once", s)
+			}
+			vals[s] = kv.Val
+		}
+	}
+
+	if len(vals) != len(KVS) {
+		t.Fatalf("len(vals),%d != len(KVS),%d", len(vals), len(KVS))
+	}
+	for _, kv := range KVS {
+		var val, exists = vals[kv.Key.String()]
+		if !exists || val != kv.Val {
+			t.Fatalf("entry for %s => (%v, %t); expected %v", kv.Key, val, exists, kv.Val)
+		}
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestLongStringN32(t *testing.T) {
+	var kvs = buildKeyVals("TestLongStringN32", 4*1024, "aaa", 0)
+	var h = createHamt32("TestLongStringN32", kvs, TYP)
+	defer setLibrary(TYP)
+
+	for _, maxNodes := range []int{0, 1, 10, 100} {
+		var str = h.LongStringN("", maxNodes)
+		var numNodes = strings.Count(str, "\n  [")
+		if numNodes > maxNodes {
+			t.Fatalf("maxNodes=%d; rendered %d nodes", maxNodes, numNodes)
+		}
+		if !strings.Contains(str, "truncated") {
+			t.Fatalf("maxNodes=%d; no truncation notice in:\n%s", maxNodes, str)
+		}
+	}
+
+	var small, _ = hamt32.Hamt{}.Put(stringkey.New("aaa"), 1)
+	var str = small.LongStringN("", 100)
+	if strings.Count(str, "\n  [") != 2 || strings.Contains(str, "truncated") {
+		t.Fatalf("expected root table and one leaf, untruncated:\n%s", str)
+	}
+}
+
+func TestPutFullHashCollision32(t *testing.T) {
+	var saveLinearThreshold = hamt32.LinearThreshold
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	// with a linear root, and with a Trie whose collision leaf is at MaxDepth
+	for _, linearThreshold := range []uint{8, 0} {
+		hamt32.LinearThreshold = linearThreshold
+
+		var kvs = KVS[:64]
+		if linearThreshold > 0 {
+			kvs = KVS[:4]
+		}
+
+		var keys = buildCollidingKeys(3)
+		var h hamt32.Hamt
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		h, _ = h.Put(keys[0], 0)
+		h, _ = h.Put(keys[1], 1)
+
+		var added bool
+		if h, added = h.Put(keys[2], 2); !added {
+			t.Fatalf("LinearThreshold=%d: h.Put(%s) of a third colliding key not added",
+				linearThreshold, keys[2])
+		}
+		if h.Nentries() != uint(len(kvs)+3) {
+			t.Fatalf("LinearThreshold=%d: h.Nentries(),%d != %d", linearThreshold, h.Nentries(), len(kvs)+3)
+		}
+		for i, k := range keys {
+			var val, kind, found = h.GetKind(k)
+			if !found || val != i || kind != "collision" {
+				t.Fatalf("LinearThreshold=%d: h.GetKind(%s) => %v, %q, %t",
+					linearThreshold, k, val, kind, found)
+			}
+		}
+		if err := h.Validate(); err != nil {
+			t.Fatalf("LinearThreshold=%d: h.Validate() => %s", linearThreshold, err)
+		}
+	}
+}
+
+func TestCollisionLeafShrink32(t *testing.T) {
+	var saveLinearThreshold = hamt32.LinearThreshold
+	hamt32.LinearThreshold = 0
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	// the estimated bytes of Report() count the capacity of a collision leaf;
+	// so a leaf shrunk by Del has the bytes of one built from its keys.
+	var estimatedBytes = func(h hamt32.Hamt) string {
+		var lines = strings.Split(h.Report(), "\n")
+		return lines[len(lines)-1]
+	}
+
+	var keys = buildCollidingKeys(64)
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	for i, k := range keys[:len(keys)-1] {
+		h, _, _ = h.Del(k)
+
+		var rest = keys[i+1:]
+		var expected = "collision"
+		if len(rest) == 1 {
+			expected = "flat"
+		}
+		if _, kind, _ := h.GetKind(rest[0]); kind != expected {
+			t.Fatalf("%d keys left; kind,%q != %q", len(rest), kind, expected)
+		}
+
+		var fresh hamt32.Hamt
+		for j, k := range rest {
+			fresh, _ = fresh.Put(k, i+1+j)
+		}
+		if got, want := estimatedBytes(h), estimatedBytes(fresh); got != want {
+			t.Fatalf("%d keys left; %s after Del; %s when built", len(rest), got, want)
+		}
+	}
+}
+
+func TestMaxCollisionSize32(t *testing.T) {
+	var h hamt32.Hamt
+	if h.MaxCollisionSize() != 0 {
+		t.Fatalf("empty h.MaxCollisionSize(),%d != 0", h.MaxCollisionSize())
+	}
+
+	for i, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, i)
+	}
+	if h.MaxCollisionSize() != 1 {
+		t.Fatalf("h.MaxCollisionSize(),%d != 1 without collisions", h.MaxCollisionSize())
+	}
+
+	for i, k := range buildCollidingKeys(7) {
+		h, _ = h.Put(k, i)
+	}
+	if h.MaxCollisionSize() != 7 {
+		t.Fatalf("h.MaxCollisionSize(),%d != 7", h.MaxCollisionSize())
+	}
+}
+
+func TestFindDuplicates32(t *testing.T) {
+	var name = "TestFindDuplicates32:" + CFG
+
+	if TestHamt32.IsEmpty() || TestHamt32.Nentries() != uint(len(KVS)) {
+		TestHamt32 = createHamt32(name, KVS, TYP)
+	}
+
+	StartTime[name] = time.Now()
+
+	if dups := TestHamt32.FindDuplicates(); len(dups) != 0 {
+		t.Fatalf("TestHamt32.FindDuplicates() => %d keys; e.g. %s", len(dups), dups[0])
+	}
+
+	if dups := (hamt32.Hamt{}).FindDuplicates(); len(dups) != 0 {
+		t.Fatalf("empty Hamt FindDuplicates() => %d keys", len(dups))
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestPutRaw32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h0, h1 hamt32.Hamt
+	for _, kv := range kvs {
+		var s = kv.Key.(*stringkey.StringKey).Str()
+
+		h0, _ = h0.Put(kv.Key, kv.Val)
+
+		var added bool
+		h1, added = h1.PutRaw([]byte(s), uint32(kv.Key.Hash30()), kv.Val)
+		if !added {
+			t.Fatalf("failed to h1.PutRaw(%q)", s)
+		}
+	}
+
+	// Same hashes, so the same shape of Trie.
+	type nodeCtx struct {
+		depth, siblings uint
+		isLeaf          bool
+	}
+	var shape0, shape1 []nodeCtx
+	h0.WalkWithContext(func(depth, siblings uint, isLeaf bool) bool {
+		shape0 = append(shape0, nodeCtx{depth, siblings, isLeaf})
+		return true
+	})
+	h1.WalkWithContext(func(depth, siblings uint, isLeaf bool) bool {
+		shape1 = append(shape1, nodeCtx{depth, siblings, isLeaf})
+		return true
+	})
+	if len(shape0) != len(shape1) {
+		t.Fatalf("len(shape0),%d != len(shape1),%d", len(shape0), len(shape1))
+	}
+	for i := range shape0 {
+		if shape0[i] != shape1[i] {
+			t.Fatalf("node %d: Put() placed %v; PutRaw() placed %v", i, shape0[i], shape1[i])
+		}
+	}
+
+	var keyBytes = []byte("aaa")
+	var h2, added = h1.PutRaw(keyBytes, uint32(stringkey.New("aaa").Hash30()), -1)
+	if added {
+		t.Fatal("h1.PutRaw(\"aaa\") added a second \"aaa\" key")
+	}
+	if h2.Nentries() != h1.Nentries() {
+		t.Fatalf("h2.Nentries(),%d != h1.Nentries(),%d", h2.Nentries(), h1.Nentries())
+	}
+	keyBytes[0] = 'b' // must not modify the stored key
+	if h3, added := h2.PutRaw([]byte("aaa"), uint32(stringkey.New("aaa").Hash30()), -2); added || h3.Nentries() != h2.Nentries() {
+		t.Fatal("stored key bytes were modified via the caller's slice")
+	}
+}
+
+func TestCheckNentries32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h hamt32.Hamt
+	if reported, actual, ok := hamt32.CheckNentries(h); !ok || reported != 0 {
+		t.Fatalf("empty: reported=%d; actual=%d", reported, actual)
+	}
+
+	for i, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+		if i%3 == 0 {
+			h, _, _ = h.Del(kvs[i/2].Key)
+		}
+		if i%5 == 0 {
+			h, _ = h.Put(kvs[i/3].Key, -1) // update or re-add
+		}
+		if i%7 == 0 {
+			h, _, _ = h.Del(stringkey.New("0 not a key"))
+		}
+	}
+
+	var reported, actual, ok = hamt32.CheckNentries(h)
+	if !ok {
+		t.Fatalf("h.Nentries(),%d != actual,%d", reported, actual)
+	}
+	if reported == 0 {
+		t.Fatal("all entries were deleted")
+	}
+}
+
+func TestAnyAll32(t *testing.T) {
+	var isInt = func(k key.Key, v interface{}) bool {
+		var _, ok = v.(int)
+		return ok
+	}
+
+	var empty hamt32.Hamt
+	if empty.Any(isInt) {
+		t.Fatal("empty.Any() => true")
+	}
+	if !empty.All(isInt) {
+		t.Fatal("empty.All() => false")
+	}
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	if !h.All(isInt) {
+		t.Fatal("h.All(isInt) => false")
+	}
+	if h.Any(func(k key.Key, v interface{}) bool { return v.(int) < 0 }) {
+		t.Fatal("h.Any(v < 0) => true")
+	}
+
+	var calls int
+	if !h.Any(func(k key.Key, v interface{}) bool { calls++; return true }) {
+		t.Fatal("h.Any(true) => false")
+	}
+	if calls != 1 {
+		t.Fatalf("h.Any() did not stop at first true; calls,%d != 1", calls)
+	}
+
+	calls = 0
+	if h.All(func(k key.Key, v interface{}) bool { calls++; return false }) {
+		t.Fatal("h.All(false) => true")
+	}
+	if calls != 1 {
+		t.Fatalf("h.All() did not stop at first false; calls,%d != 1", calls)
+	}
+}
+
+func TestSubtrie32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	if sub, ok := h.Subtrie(nil); !ok || sub != h {
+		t.Fatal("h.Subtrie(nil) != h")
+	}
+	if _, ok := (hamt32.Hamt{}).Subtrie([]uint{0}); ok {
+		t.Fatal("empty Hamt Subtrie([0]) => ok")
+	}
+
+	var subs []hamt32.Hamt
+	var total uint
+	for idx := uint(0); idx < hamt32.TableCapacity; idx++ {
+		var sub, ok = h.Subtrie([]uint{idx})
+		if !ok {
+			continue
+		}
+		if reported, actual, ok := hamt32.CheckNentries(sub); !ok {
+			t.Fatalf("Subtrie([%d]): Nentries(),%d != actual,%d", idx, reported, actual)
+		}
+		subs = append(subs, sub)
+		total += sub.Nentries()
+	}
+
+	if total != h.Nentries() {
+		t.Fatalf("sum of subtrie Nentries(),%d != h.Nentries(),%d", total, h.Nentries())
+	}
+
+	// a subtrie keeps the options of h; eg. a strict Hamt stays strict
+	var strict, _ = hamt32.NewStrict().Put(kvs[0].Key, kvs[0].Val)
+	var sub, _ = strict.Subtrie([]uint{hamt32.DecodeHashPath30(uint32(kvs[0].Key.Hash30()))[0]})
+	if _, added := sub.Put(kvs[1].Key, (*int)(nil)); added {
+		t.Fatal("Subtrie() of a strict Hamt stored a typed nil value")
+	}
+
+	for _, kv := range kvs {
+		var numFound int
+		for _, sub := range subs {
+			if val, found := sub.Get(kv.Key); found {
+				if val != kv.Val {
+					t.Fatalf("sub.Get(%s),%v != %v", kv.Key, val, kv.Val)
+				}
+				numFound++
+			}
+		}
+		if numFound != 1 {
+			t.Fatalf("%s found in %d subtries", kv.Key, numFound)
+		}
+	}
+}
+
+func TestNewSized32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var h = hamt32.NewSized(64 * 1024)
+	if !h.IsEmpty() {
+		t.Fatal("!hamt32.NewSized(64K).IsEmpty()")
+	}
+
+	// the root is a fullTable from the first Put on; checked at a few
+	// sizes, as rootTableType32() renders the whole Trie
+	var kvs = KVS[:10*1024]
+	var checkAt = map[uint]bool{1: true, 2: true, 32: true, 1024: true, uint(len(kvs)): true}
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+		if !checkAt[h.Nentries()] {
+			continue
+		}
+		if typ := rootTableType32(h); typ != "fullTable" {
+			t.Fatalf("Nentries()=%d; root %s != fullTable", h.Nentries(), typ)
+		}
+	}
+
+	for _, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %t", kv.Key, val, found)
+		}
+	}
+
+	var saveLinearThreshold = hamt32.LinearThreshold
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	hamt32.LinearThreshold = 8
+	var small, _ = hamt32.NewSized(8).Put(kvs[0].Key, kvs[0].Val)
+	if typ := rootTableType32(small); typ != "linearTable" {
+		t.Fatalf("NewSized(8) root %s != linearTable", typ)
+	}
+
+	hamt32.LinearThreshold = 0
+
+	small, _ = hamt32.NewSized(8).Put(kvs[0].Key, kvs[0].Val)
+	if typ := rootTableType32(small); typ != "compressedTable" {
+		t.Fatalf("NewSized(8) root %s != compressedTable", typ)
+	}
+}
+
+func TestWithSizeHint32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var saveLinearThreshold = hamt32.LinearThreshold
+	hamt32.LinearThreshold = 8
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	var kvs = KVS[:10*1024]
+
+	var h = hamt32.NewStrict()
+	for _, kv := range kvs[:4] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if typ := rootTableType32(h); typ != "linearTable" {
+		t.Fatalf("root %s != linearTable", typ)
+	}
+
+	h = h.WithSizeHint(64 * 1024)
+	h, _ = h.Put(kvs[4].Key, kvs[4].Val)
+	if typ := rootTableType32(h); typ != "fullTable" {
+		t.Fatalf("Nentries()=%d; root %s != fullTable", h.Nentries(), typ)
+	}
+	for _, kv := range kvs[5:] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if typ := rootTableType32(h); typ != "fullTable" {
+		t.Fatalf("Nentries()=%d; root %s != fullTable", h.Nentries(), typ)
+	}
+
+	var plain = hamt32.NewStrict()
+	for _, kv := range kvs {
+		plain, _ = plain.Put(kv.Key, kv.Val)
+	}
+	if !h.Equals(plain) {
+		t.Fatal("hinted Hamt does not Equal the Hamt built without a hint")
+	}
+
+	var typedNil *int
+	if _, added := h.Put(KVS[len(kvs)].Key, typedNil); added {
+		t.Fatal("WithSizeHint() dropped the strict option")
+	}
+
+	var built = hamt32.BuildWithProgress(kvs, 0, nil)
+	if typ := rootTableType32(built); typ != "fullTable" {
+		t.Fatalf("BuildWithProgress(10K) root %s != fullTable", typ)
+	}
+	if !built.Equals(plain) {
+		t.Fatal("BuildWithProgress() does not Equal the Hamt built by Put")
+	}
+
+	hamt32.LinearThreshold = 0
+
+	var unhinted, _ = built.Clear().Put(kvs[0].Key, kvs[0].Val)
+	if typ := rootTableType32(unhinted); typ != "compressedTable" {
+		t.Fatalf("BuildWithProgress() kept its size hint; root %s != compressedTable", typ)
+	}
+}
+
+func TestFullTableCopy32(t *testing.T) {
+	setLibrary(fullonly)
+	defer setLibrary(TYP)
+
+	var kvs = KVS[:4096]
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if typ := rootTableType32(h); typ != "fullTable" {
+		t.Fatalf("root %s != fullTable", typ)
+	}
+
+	// each of these copies the fullTables on the path to the key; inserting
+	// into, replacing, and removing a slot of the copies.
+	var inserted, _ = h.Put(KVS[4096].Key, KVS[4096].Val)
+	var replaced, _ = h.Put(kvs[0].Key, -1)
+	var removed, _, _ = h.Del(kvs[1].Key)
+
+	if h.Nentries() != uint(len(kvs)) {
+		t.Fatalf("h.Nentries(),%d != %d", h.Nentries(), len(kvs))
+	}
+	for _, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %t; original Hamt was modified", kv.Key, val, found)
+		}
+	}
+	if _, found := h.Get(KVS[4096].Key); found {
+		t.Fatalf("h.Get(%s) found; original Hamt was modified", KVS[4096].Key)
+	}
+
+	if val, found := inserted.Get(KVS[4096].Key); !found || val != KVS[4096].Val {
+		t.Fatalf("inserted.Get(%s) => %v, %t", KVS[4096].Key, val, found)
+	}
+	if val, _ := replaced.Get(kvs[0].Key); val != -1 {
+		t.Fatalf("replaced.Get(%s),%v != -1", kvs[0].Key, val)
+	}
+	if _, found := removed.Get(kvs[1].Key); found {
+		t.Fatalf("removed.Get(%s) found", kvs[1].Key)
+	}
+}
+
+func TestEmptyAfterDelete32(t *testing.T) {
+	defer setLibrary(TYP)
+
+	var kvs = append([]key.KeyVal(nil), KVS[:2048]...)
+	for i, k := range buildCollidingKeys(3) {
+		kvs = append(kvs, key.KeyVal{Key: k, Val: -i})
+	}
+
+	for _, typ := range []int{fullonly, componly, hybrid} {
+		var h = createHamt32("TestEmptyAfterDelete32", kvs, typ)
+		for i, kv := range kvs {
+			var deleted bool
+			if h, _, deleted = h.Del(kv.Key); !deleted {
+				t.Fatalf("%s: h.Del(%s) not deleted", cfgStr[typ], kv.Key)
+			}
+			if h.IsEmpty() != (h.Nentries() == 0) || h.Nentries() != uint(len(kvs)-i-1) {
+				t.Fatalf("%s: after %d deletes IsEmpty()=%t, Nentries()=%d",
+					cfgStr[typ], i+1, h.IsEmpty(), h.Nentries())
+			}
+		}
+		if !h.IsEmpty() || h.Nentries() != 0 {
+			t.Fatalf("%s: after deleting all keys IsEmpty()=%t, Nentries()=%d",
+				cfgStr[typ], h.IsEmpty(), h.Nentries())
+		}
+	}
+}
+
+func TestGetRef32(t *testing.T) {
+	type counter struct{ n int }
+
+	var kvs = KVS[:1024]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, &counter{})
+	}
+
+	// h2 shares all of h's leaves except the one for the added key.
+	var h2, _ = h.Put(KVS[len(kvs)].Key, &counter{})
+
+	for i, kv := range kvs {
+		var val, found = h.GetRef(kv.Key)
+		if !found {
+			t.Fatalf("h.GetRef(%s) not found", kv.Key)
+		}
+		val.(*counter).n = i
+	}
+
+	for i, kv := range kvs {
+		var val, _ = h2.Get(kv.Key)
+		if n := val.(*counter).n; n != i {
+			t.Fatalf("h2.Get(%s).n,%d != %d", kv.Key, n, i)
+		}
+	}
+
+	if h.Nentries() != uint(len(kvs)) || h2.Nentries() != uint(len(kvs)+1) {
+		t.Fatalf("h.Nentries(),%d or h2.Nentries(),%d changed", h.Nentries(), h2.Nentries())
+	}
+}
+
+func TestDelPrefix32(t *testing.T) {
+	var h hamt32.Hamt
+	var s = "aaa"
+	for i := 0; i < 1024; i++ {
+		h, _ = h.Put(stringkey.New("foo:"+s), i)
+		h, _ = h.Put(stringkey.New("bar:"+s), i)
+		s = Inc(s)
+	}
+
+	var nh, n = h.DelPrefix("baz:")
+	if n != 0 || nh.Nentries() != h.Nentries() {
+		t.Fatalf("DelPrefix(\"baz:\") removed %d; Nentries(),%d", n, nh.Nentries())
+	}
+
+	nh, n = h.DelPrefix("foo:")
+	if n != 1024 || nh.Nentries() != 1024 {
+		t.Fatalf("DelPrefix(\"foo:\") removed %d; Nentries(),%d", n, nh.Nentries())
+	}
+
+	s = "aaa"
+	for i := 0; i < 1024; i++ {
+		if _, found := nh.Get(stringkey.New("foo:" + s)); found {
+			t.Fatalf("foo:%s found after DelPrefix(\"foo:\")", s)
+		}
+		if _, found := nh.Get(stringkey.New("bar:" + s)); !found {
+			t.Fatalf("bar:%s not found after DelPrefix(\"foo:\")", s)
+		}
+		if _, found := h.Get(stringkey.New("foo:" + s)); !found {
+			t.Fatalf("foo:%s not found in original Hamt", s)
+		}
+		s = Inc(s)
+	}
+
+	nh, n = h.DelPrefix("")
+	if n != h.Nentries() || !nh.IsEmpty() {
+		t.Fatalf("DelPrefix(\"\") removed %d; Nentries(),%d", n, nh.Nentries())
+	}
+}
+
+func TestPutTypedNil32(t *testing.T) {
+	var typedNil *int
+	var k0, k1 = KVS[0].Key, KVS[1].Key
+
+	var h, added = hamt32.Hamt{}.Put(k0, typedNil)
+	if !added {
+		t.Fatal("default Hamt: Put(k, typed nil) => added=false")
+	}
+	if val, found := h.Get(k0); !found || val == nil {
+		t.Fatalf("default Hamt: Get(k) => %v, %t; want typed nil, true", val, found)
+	}
+
+	var sh = hamt32.NewStrict()
+	sh, added = sh.Put(k0, typedNil)
+	if added || !sh.IsEmpty() {
+		t.Fatal("strict Hamt: Put(k, typed nil) => added=true")
+	}
+
+	sh, _ = sh.Put(k0, nil)
+	sh, _ = sh.Put(k1, 1)
+	if sh.Nentries() != 2 {
+		t.Fatalf("strict Hamt: Nentries(),%d != 2", sh.Nentries())
+	}
+
+	var nh hamt32.Hamt
+	nh, added = sh.Put(k1, typedNil)
+	if added || nh != sh {
+		t.Fatal("strict Hamt: Put(existing k, typed nil) changed the Hamt")
+	}
+	if val, _ := nh.Get(k1); val != 1 {
+		t.Fatalf("strict Hamt: Get(k1),%v != 1", val)
+	}
+}
+
+func TestMinMaxValue32(t *testing.T) {
+	var less = func(a, b interface{}) bool { return a.(int) < b.(int) }
+
+	if _, _, ok := (hamt32.Hamt{}).MaxValue(less); ok {
+		t.Fatal("empty Hamt MaxValue() => ok")
+	}
+	if _, _, ok := (hamt32.Hamt{}).MinValue(less); ok {
+		t.Fatal("empty Hamt MinValue() => ok")
+	}
+
+	var kvs = KVS[:10*1024]
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	// KVS values are their index in KVS.
+	var last = kvs[len(kvs)-1]
+	if k, v, ok := h.MaxValue(less); !ok || !k.Equals(last.Key) || v != last.Val {
+		t.Fatalf("MaxValue() => %s, %v, %t; want %s, %v", k, v, ok, last.Key, last.Val)
+	}
+	if k, v, ok := h.MinValue(less); !ok || !k.Equals(kvs[0].Key) || v != kvs[0].Val {
+		t.Fatalf("MinValue() => %s, %v, %t; want %s, %v", k, v, ok, kvs[0].Key, kvs[0].Val)
+	}
+}
+
+type lwwVal32 struct {
+	ts  int64
+	val int
+}
+
+func TestMergeLWW32(t *testing.T) {
+	var timestampOf = func(v interface{}) int64 { return v.(lwwVal32).ts }
+	var rnd = rand.New(rand.NewSource(32))
+
+	// 6 replicas of overlapping keys; every write has its own timestamp.
+	var replicas = make([]hamt32.Hamt, 6)
+	var latest = make(map[int]lwwVal32)
+	for ts, i := range rnd.Perm(6 * 512) {
+		var r, j = ts % len(replicas), i % 1024
+		var v = lwwVal32{int64(ts), i}
+		replicas[r], _ = replicas[r].Put(KVS[j].Key, v)
+		if old, found := latest[j]; !found || old.ts < v.ts {
+			latest[j] = v
+		}
+	}
+
+	var want hamt32.Hamt
+	for j, v := range latest {
+		want, _ = want.Put(KVS[j].Key, v)
+	}
+
+	for trial := 0; trial < 20; trial++ {
+		// merge the replicas in a random order, grouped at random.
+		var hs = make([]hamt32.Hamt, len(replicas))
+		for i, r := range rnd.Perm(len(replicas)) {
+			hs[i] = replicas[r]
+		}
+		for len(hs) > 1 {
+			var i = rnd.Intn(len(hs) - 1)
+			var a, b = hs[i], hs[i+1]
+			if rnd.Intn(2) == 0 {
+				a, b = b, a
+			}
+			hs[i] = a.MergeLWW(b, timestampOf)
+			hs = append(hs[:i+1], hs[i+2:]...)
+		}
+		if !hs[0].Equals(want) {
+			t.Fatalf("trial %d: merged Hamt of %d key/val pairs != latest writes of %d",
+				trial, hs[0].Nentries(), want.Nentries())
+		}
+	}
+
+	// on a tie the val of h is kept
+	var k = KVS[0].Key
+	var h1, _ = hamt32.Hamt{}.Put(k, lwwVal32{1, 1})
+	var h2, _ = hamt32.Hamt{}.Put(k, lwwVal32{1, 2})
+	h2, _ = h2.Put(KVS[1].Key, lwwVal32{0, 0})
+	if val, _ := h1.MergeLWW(h2, timestampOf).Get(k); val.(lwwVal32).val != 1 {
+		t.Fatalf("h1.MergeLWW(h2) tie kept %v; want the val of h1", val)
+	}
+	if val, _ := h2.MergeLWW(h1, timestampOf).Get(k); val.(lwwVal32).val != 2 {
+		t.Fatalf("h2.MergeLWW(h1) tie kept %v; want the val of h2", val)
+	}
+}
+
+func TestMergeSumInts32(t *testing.T) {
+	// a has KVS[0:2048], b has KVS[1024:4096]; both count 1 per key.
+	var a, b hamt32.Hamt
+	for _, kv := range KVS[:2048] {
+		a, _ = a.Put(kv.Key, 1)
+	}
+	for _, kv := range KVS[1024:4096] {
+		b, _ = b.Put(kv.Key, 1)
+	}
+
+	for _, m := range []hamt32.Hamt{a.MergeSumInts(b), b.MergeSumInts(a)} {
+		if m.Nentries() != 4096 {
+			t.Fatalf("m.Nentries(),%d != 4096", m.Nentries())
+		}
+		for i, kv := range KVS[:4096] {
+			var want = 1
+			if i >= 1024 && i < 2048 {
+				want = 2
+			}
+			if val, found := m.Get(kv.Key); !found || val != want {
+				t.Fatalf("m.Get(%s) => %v, %t; want %d", kv.Key, val, found, want)
+			}
+		}
+	}
+
+	if val, _ := a.Get(KVS[1024].Key); val != 1 {
+		t.Fatalf("a.Get(%s),%v != 1 after MergeSumInts", KVS[1024].Key, val)
+	}
+
+	if m := a.MergeSumInts(hamt32.Hamt{}); m != a {
+		t.Fatal("a.MergeSumInts(empty) != a")
+	}
+
+	// a val that is not an int is kept if its key is in one Hamt only
+	var c, _ = a.Put(KVS[4096].Key, "four thousand ninety six")
+	if val, _ := c.MergeSumInts(b).Get(KVS[4096].Key); val != "four thousand ninety six" {
+		t.Fatalf("c.MergeSumInts(b).Get(%s) => %v", KVS[4096].Key, val)
+	}
+
+	// and panics if its key is in both
+	c, _ = a.Put(KVS[1024].Key, "one thousand twenty four")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("c.MergeSumInts(b) did not panic on a string val in both Hamts")
+		}
+	}()
+	c.MergeSumInts(b)
+}
+
+func TestEncodeStructure32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = 4
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	// NewSized() makes the upper tables fullTables, the lower ones stay
+	// compressedTables.
+	var h = hamt32.NewSized(8 * 1024)
+	for _, kv := range KVS[:8*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	h, _ = h.Put(KVS[8*1024].Key, nil) // keyLeaf
+	for i := 0; i < 3; i++ {           // collisionLeaf
+		h, _ = h.PutRaw([]byte(fmt.Sprintf("collision%d", i)), 0x2aaaaaaa, i)
+	}
+	for i := 0; i < 8; i++ { // overflowLeaf
+		h, _ = h.PutRaw([]byte(fmt.Sprintf("overflow%d", i)), 0x15555555, i)
+	}
+
+	var buf bytes.Buffer
+	if err := h.EncodeStructure(&buf); err != nil {
+		t.Fatalf("h.EncodeStructure() => %s", err)
+	}
+
+	var dh, err = hamt32.DecodeStructure(&buf)
+	if err != nil {
+		t.Fatalf("hamt32.DecodeStructure() => %s", err)
+	}
+
+	if dh.Nentries() != h.Nentries() {
+		t.Fatalf("dh.Nentries(),%d != h.Nentries(),%d", dh.Nentries(), h.Nentries())
+	}
+	if dh.LongString("") != h.LongString("") {
+		t.Fatal("decoded Hamt structure differs from the encoded Hamt")
+	}
+	for _, kv := range KVS[:8*1024] {
+		if val, found := dh.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("dh.Get(%s) => %v, %t", kv.Key, val, found)
+		}
+	}
+
+	var eh, _ = hamt32.Hamt{}.Put(collidingKey{stringkey.New("aaa")}, 1)
+	if err := eh.EncodeStructure(&buf); err == nil {
+		t.Fatal("EncodeStructure() of an unsupported key type => nil error")
+	}
+
+	buf.Reset()
+	if err := (hamt32.Hamt{}).EncodeStructure(&buf); err != nil {
+		t.Fatalf("empty Hamt EncodeStructure() => %s", err)
+	}
+	if dh, err = hamt32.DecodeStructure(&buf); err != nil || !dh.IsEmpty() {
+		t.Fatalf("DecodeStructure() of an empty Hamt => %s, %t", err, dh.IsEmpty())
+	}
+}
+
+func TestEncodeStructureDeterministic32(t *testing.T) {
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = 4
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	var base hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		base, _ = base.Put(kv.Key, kv.Val)
+	}
+
+	// the same colliding keys, Put in ascending and in descending order; four
+	// in a collisionLeaf, and eight in an overflowLeaf
+	var encode = func(order []int) []byte {
+		var h = base
+		for _, i := range order {
+			if i < 4 {
+				h, _ = h.PutRaw([]byte(fmt.Sprintf("collision%d", i)), 0x2aaaaaaa, i)
+			}
+		}
+		for _, i := range order {
+			h, _ = h.PutRaw([]byte(fmt.Sprintf("overflow%d", i)), 0x15555555, i)
+		}
+		if !strings.Contains(h.LongString(""), "collisionLeaf{") {
+			t.Fatal("no collisionLeaf among the colliding keys")
+		}
+		var buf bytes.Buffer
+		if err := h.EncodeStructure(&buf); err != nil {
+			t.Fatalf("h.EncodeStructure() => %s", err)
+		}
+		return buf.Bytes()
+	}
+
+	var asc = encode([]int{0, 1, 2, 3, 4, 5, 6, 7})
+	var desc = encode([]int{7, 6, 5, 4, 3, 2, 1, 0})
+	if !bytes.Equal(asc, desc) {
+		t.Fatal("EncodeStructure() depends on the order colliding keys were Put in")
+	}
+
+	var dh, err = hamt32.DecodeStructure(bytes.NewReader(desc))
+	if err != nil {
+		t.Fatalf("hamt32.DecodeStructure() => %s", err)
+	}
+	var buf bytes.Buffer
+	if err := dh.EncodeStructure(&buf); err != nil || !bytes.Equal(buf.Bytes(), asc) {
+		t.Fatalf("EncodeStructure() of the decoded Hamt differs; err=%v", err)
+	}
+}
+
+func TestContentHash32(t *testing.T) {
+	var valHash = func(v interface{}) uint64 {
+		return uint64(v.(int))
+	}
+
+	// the same key/val pairs, with colliding keys, Put in opposite orders
+	var build = func(kvs []key.KeyVal, order []int) hamt32.Hamt {
+		var h = hamt32.NewFolded(hamt32.FoldMix)
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		for _, i := range order {
+			h, _ = h.PutRaw([]byte(fmt.Sprintf("collision%d", i)), 0x2aaaaaaa, i)
+		}
+		return h
+	}
+	var rev = make([]key.KeyVal, 1024)
+	for i, kv := range KVS[:1024] {
+		rev[len(rev)-1-i] = kv
+	}
+	var a = build(KVS[:1024], []int{0, 1, 2, 3})
+	var b = build(rev, []int{3, 2, 1, 0})
+	if a.ContentHash(valHash) != b.ContentHash(valHash) {
+		t.Fatal("ContentHash() depends on the order key/val pairs were Put in")
+	}
+
+	// not on the tables holding them, either
+	var c hamt32.Hamt
+	for _, kv := range a.KeyVals() {
+		c, _ = c.Put(hamt32.UnfoldKey(kv.Key), kv.Val)
+	}
+	if c.ContentHash(valHash) != a.ContentHash(valHash) {
+		t.Fatal("ContentHash() depends on the fold of the Hamt")
+	}
+
+	// any single change changes it
+	var changed, _ = a.Put(KVS[10].Key, -1)
+	var deleted, _, _ = a.Del(KVS[10].Key)
+	var renamed, _ = deleted.Put(stringkey.New(KVS[10].Key.String()+"x"), KVS[10].Val)
+	var raw, _, _ = a.Del(KVS[10].Key)
+	raw, _ = raw.PutRaw([]byte(KVS[10].Key.String()), 12345, KVS[10].Val)
+	var hashes = map[[32]byte]string{a.ContentHash(valHash): "a"}
+	for name, h := range map[string]hamt32.Hamt{
+		"changed": changed, "deleted": deleted, "renamed": renamed, "raw": raw,
+		"empty": {},
+	} {
+		var sum = h.ContentHash(valHash)
+		if other, dup := hashes[sum]; dup {
+			t.Fatalf("ContentHash() of %s == ContentHash() of %s", name, other)
+		}
+		hashes[sum] = name
+	}
+}
+
+func TestChangedVsMap32(t *testing.T) {
+	var h hamt32.Hamt
+	var baseline = make(map[string]interface{})
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+		baseline[kv.Key.String()] = kv.Val
+	}
+	baseline["only in baseline"] = 1
+
+	if changed := h.ChangedVsMap(baseline); changed == nil || len(changed) != 0 {
+		t.Fatalf("in sync h.ChangedVsMap() => %#v; expected []string{}", changed)
+	}
+
+	// two additions, two modifications, and a raw key that is skipped
+	var nh, _ = h.Put(stringkey.New("zz-added"), 1)
+	nh, _ = nh.Put(stringkey.New("aa-added"), 2)
+	nh, _ = nh.Put(KVS[7].Key, -7)
+	nh, _ = nh.Put(KVS[3].Key, -3)
+	nh, _ = nh.PutRaw([]byte("raw"), 12345, 1)
+	var expected = []string{"aa-added", KVS[3].Key.String(), KVS[7].Key.String(), "zz-added"}
+	sort.Strings(expected)
+
+	var changed = nh.ChangedVsMap(baseline)
+	if strings.Join(changed, ",") != strings.Join(expected, ",") {
+		t.Fatalf("nh.ChangedVsMap() => %v; expected %v", changed, expected)
+	}
+
+	if changed := (hamt32.Hamt{}).ChangedVsMap(baseline); changed == nil || len(changed) != 0 {
+		t.Fatalf("empty Hamt ChangedVsMap() => %#v", changed)
+	}
+}
+
+// hashedKey32 is a stringkey with a chosen Hash30(); for building a Trie of a
+// known shape.
+type hashedKey32 struct {
+	*stringkey.StringKey
+	h30 key.HashVal30
+}
+
+func (k hashedKey32) Hash30() key.HashVal30 {
+	return k.h30
+}
+
+func (k hashedKey32) Equals(k1 key.Key) bool {
+	var hk, ok = k1.(hashedKey32)
+	return ok && hk.h30 == k.h30 && hk.Str() == k.Str()
+}
+
+// chainPath32() returns a hash whose path is rootIdx, then 7 for depths 1 to 4,
+// then leafIdx.
+func chainPath32(rootIdx, leafIdx uint) key.HashVal30 {
+	var h30 = key.HashVal30(rootIdx)
+	for d := uint(1); d <= 4; d++ {
+		h30 |= 7 << (d * hamt32.Nbits)
+	}
+	return h30 | key.HashVal30(leafIdx)<<(5*hamt32.Nbits)
+}
+
+// buildChainKeys32() returns 2*roots keys, in pairs sharing the hash path
+// indexes of depths 0 to 4; so each pair is below a run of single node
+// compressedTables.
+func buildChainKeys32(roots uint) []key.Key {
+	var keys = make([]key.Key, 0, 2*roots)
+	var s = "aaa"
+	for r := uint(0); r < roots; r++ {
+		for l := uint(0); l < 2; l++ {
+			keys = append(keys, hashedKey32{stringkey.New(s), chainPath32(r, l)})
+			s = Inc(s)
+		}
+	}
+	return keys
+}
+
+func TestSingleNodeTableGet32(t *testing.T) {
+	var keys = buildChainKeys32(4)
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	for i, k := range keys {
+		if val, found := h.Get(k); !found || val != i {
+			t.Fatalf("h.Get(%s) => %v, %t; want %d, true", k, val, found, i)
+		}
+	}
+
+	// misses that leave the path of keys[0] at each depth of its run
+	for d := uint(0); d <= 5; d++ {
+		var h30 = chainPath32(0, 0) ^ key.HashVal30(8)<<(d*hamt32.Nbits)
+		var k = hashedKey32{stringkey.New("miss"), h30}
+		if val, found := h.Get(k); found {
+			t.Fatalf("depth %d: h.Get(%s) => %v, true; want not found", d, k, val)
+		}
+	}
+
+	// the same hash path as keys[0], but a different key
+	var other = hashedKey32{stringkey.New("miss"), chainPath32(0, 0)}
+	if val, found := h.Get(other); found {
+		t.Fatalf("h.Get(%s) => %v, true; want not found", other, val)
+	}
+}
+
+func TestLongestPrefixLeaf32(t *testing.T) {
+	if _, _, _, ok := (hamt32.Hamt{}).LongestPrefixLeaf(stringkey.New("aaa")); ok {
+		t.Fatal("empty Hamt LongestPrefixLeaf() => ok")
+	}
+
+	// a and b share the path indexes of depths 0 to 4; c is alone at the root.
+	var a = hashedKey32{stringkey.New("aaa"), chainPath32(1, 2)}
+	var b = hashedKey32{stringkey.New("aab"), chainPath32(1, 3)}
+	var c = hashedKey32{stringkey.New("aac"), chainPath32(4, 0)}
+	var h hamt32.Hamt
+	for i, k := range []key.Key{a, b, c} {
+		h, _ = h.Put(k, i)
+	}
+
+	var probe = func(h30 key.HashVal30) key.Key {
+		return hashedKey32{stringkey.New("zzz"), h30}
+	}
+	var tests = []struct {
+		name  string
+		k     key.Key
+		leaf  key.Key
+		val   interface{}
+		depth uint
+	}{
+		{"a itself", a, a, 0, 5},
+		{"b itself", b, b, 1, 5},
+		{"diverges at depth 5", probe(chainPath32(1, 9)), a, 0, 5},
+		{"diverges at depth 2", probe(chainPath32(1, 2) ^ 1<<(2*hamt32.Nbits)), a, 0, 5},
+		{"shares c's root index", probe(chainPath32(4, 9)), c, 2, 0},
+		{"empty root entry", probe(chainPath32(9, 0)), c, 2, 0},
+	}
+	// the Compact()ed Hamt walks chainTables one table at a time too
+	for _, h := range []hamt32.Hamt{h, h.Compact()} {
+		for _, test := range tests {
+			var k, v, depth, ok = h.LongestPrefixLeaf(test.k)
+			if !ok || !k.Equals(test.leaf) || v != test.val || depth != test.depth {
+				t.Fatalf("%s: LongestPrefixLeaf(%s) => %s, %v, %d, %t; want %s, %v, %d, true",
+					test.name, test.k, k, v, depth, ok, test.leaf, test.val, test.depth)
+			}
+		}
+	}
+}
+
+func TestNeighborsOf32(t *testing.T) {
+	var h hamt32.Hamt
+	if kvs := h.NeighborsOf(KVS[0].Key, 8); len(kvs) != 0 {
+		t.Fatalf("empty Hamt NeighborsOf() => %v", kvs)
+	}
+
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	for _, k := range []key.Key{KVS[0].Key, KVS[100].Key, KVS[8192].Key} {
+		var rootIdx = k.Hash30().Index(0)
+		var kvs = h.NeighborsOf(k, 8)
+		if len(kvs) != 8 {
+			t.Fatalf("len(h.NeighborsOf(%s, 8)),%d != 8", k, len(kvs))
+		}
+		for _, kv := range kvs {
+			if kv.Key.Equals(k) {
+				t.Fatalf("h.NeighborsOf(%s) returned %s itself", k, k)
+			}
+			if idx := kv.Key.Hash30().Index(0); idx != rootIdx {
+				t.Fatalf("neighbor %s of %s has root index %d != %d", kv.Key, k, idx, rootIdx)
+			}
+			if val, found := h.Get(kv.Key); !found || val != kv.Val {
+				t.Fatalf("neighbor %s,%v is not in h", kv.Key, kv.Val)
+			}
+		}
+	}
+
+	// more than the whole Hamt
+	if kvs := h.NeighborsOf(KVS[0].Key, 8192); len(kvs) != 4095 {
+		t.Fatalf("len(h.NeighborsOf(%s, 8192)),%d != 4095", KVS[0].Key, len(kvs))
+	}
+}
+
+func TestCompact32(t *testing.T) {
+	// 8 groups of 3 keys; each group is a run of 4 single entry tables, below
+	// the root, leading to a table of 3 leaves.
+	var keys []key.Key
+	var s = "aaa"
+	for g := uint(0); g < 8; g++ {
+		for i := uint(0); i < 3; i++ {
+			keys = append(keys, hashedKey32{stringkey.New(s), chainPath32(g, i)})
+			s = Inc(s)
+		}
+	}
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	if n := h.NumTables(); n != 1+8*5 {
+		t.Fatalf("h.NumTables(),%d != %d", n, 1+8*5)
+	}
+
+	var ch = h.Compact()
+	if n := ch.NumTables(); n != 1+8*2 {
+		t.Fatalf("ch.NumTables(),%d != %d", n, 1+8*2)
+	}
+	if ch.LongString("") != h.Compact().LongString("") || ch.Compact().NumTables() != ch.NumTables() {
+		t.Fatal("Compact() is not repeatable")
+	}
+
+	for i, k := range keys {
+		if val, found := ch.Get(k); !found || val != i {
+			t.Fatalf("ch.Get(%s) => %v, %t", k, val, found)
+		}
+	}
+	var offPath = hashedKey32{stringkey.New("aaa"), chainPath32(0, 0) ^ 1<<(2*hamt32.Nbits)}
+	if _, found := ch.Get(offPath); found {
+		t.Fatalf("ch.Get(%s) found a key off the compacted path", offPath)
+	}
+
+	// Put a key leaving a run in its middle, then Del everything.
+	var nh, _ = ch.Put(offPath, -1)
+	keys = append(keys, offPath)
+	for _, k := range keys {
+		if _, found := nh.Get(k); !found {
+			t.Fatalf("nh.Get(%s) not found", k)
+		}
+	}
+	for i, k := range keys {
+		var deleted bool
+		nh, _, deleted = nh.Del(k)
+		if !deleted {
+			t.Fatalf("nh.Del(%s) not deleted", k)
+		}
+		if reported, actual, ok := hamt32.CheckNentries(nh); !ok {
+			t.Fatalf("Nentries(),%d != actual,%d", reported, actual)
+		}
+		for _, k1 := range keys[i+1:] {
+			if _, found := nh.Get(k1); !found {
+				t.Fatalf("nh.Get(%s) not found after Del(%s)", k1, k)
+			}
+		}
+	}
+	if !nh.IsEmpty() {
+		t.Fatal("!nh.IsEmpty() after deleting every key")
+	}
+}
+
+// "bgl" and "eqa" share the hash path indexes of depths 0 to 2, H30=/12/04/11,
+// so the Compact()ed Hamt has a chainTable above their table.
+func TestCompactGetStr32(t *testing.T) {
+	var k0 = stringkey.New("bgl") // H30=/12/04/11/19/09
+	var k1 = stringkey.New("eqa") // H30=/12/04/11/06/09
+
+	var h hamt32.Hamt
+	h, _ = h.Put(k0, 0)
+	h, _ = h.Put(k1, 1)
+
+	var ch = h.Compact()
+	if ch.NumTables() >= h.NumTables() {
+		t.Fatalf("ch.NumTables(),%d >= h.NumTables(),%d", ch.NumTables(), h.NumTables())
+	}
+
+	for i, k := range []*stringkey.StringKey{k0, k1} {
+		if val, found := ch.GetStr(k.Str()); !found || val != i {
+			t.Fatalf("ch.GetStr(%q) => (%v, %t)", k.Str(), val, found)
+		}
+		if val, kind, found := ch.GetKind(k); !found || val != i || kind != "flat" {
+			t.Fatalf("ch.GetKind(%s) => (%v, %q, %t)", k, val, kind, found)
+		}
+	}
+
+	var allocs = testing.AllocsPerRun(100, func() {
+		ch.GetStr("eqa")
+	})
+	if allocs != 0 {
+		t.Fatalf("ch.GetStr(\"eqa\") allocates %v times", allocs)
+	}
+}
+
+// A Put that ends a run compacted by Compact() grades the table it ends the
+// run at by the options of the Hamt, like any other insert.
+func TestCompactPutGrades32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var keys = buildChainKeys32(1)
+	var offRun = hashedKey32{stringkey.New("zzz"), chainPath32(0, 0) ^ 1<<hamt32.Nbits}
+
+	for _, hysteresis := range []uint{0, 1} {
+		var grades []string
+		var h = hamt32.Hamt{}.WithGradeHysteresis(hysteresis).OnGrade(
+			func(depth uint, from, to string) {
+				grades = append(grades, fmt.Sprintf("%d:%s->%s", depth, from, to))
+			})
+		for i, k := range keys {
+			h, _ = h.Put(k, i)
+		}
+		var ch = h.Compact()
+
+		var upgradeThreshold = hamt32.UpgradeThreshold
+		hamt32.UpgradeThreshold = 2
+		grades = nil
+		var nh, _ = ch.Put(offRun, -1)
+		hamt32.UpgradeThreshold = upgradeThreshold
+
+		var want = []string{"1:compressed->full"}
+		if hysteresis > 0 {
+			want = nil
+		}
+		if fmt.Sprint(grades) != fmt.Sprint(want) {
+			t.Fatalf("hysteresis=%d: OnGrade calls %v != %v", hysteresis, grades, want)
+		}
+		for _, k := range append(keys, offRun) {
+			if _, found := nh.Get(k); !found {
+				t.Fatalf("hysteresis=%d: nh.Get(%s) not found", hysteresis, k)
+			}
+		}
+	}
+}
+
+func TestHashVariants32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h, hh hamt32.Hamt
+	for _, kv := range kvs {
+		var added, addedH bool
+		h, added = h.Put(kv.Key, kv.Val)
+		hh, addedH = hh.PutH(kv.Key, kv.Key.Hash30(), kv.Val)
+		if added != addedH {
+			t.Fatalf("Put(%s) added=%t; PutH() added=%t", kv.Key, added, addedH)
+		}
+	}
+	if h.LongString("") != hh.LongString("") {
+		t.Fatal("Hamt built by PutH() differs from one built by Put()")
+	}
+
+	for _, kv := range kvs {
+		var h30 = kv.Key.Hash30()
+		if val, found := hh.GetH(kv.Key, h30); !found || val != kv.Val {
+			t.Fatalf("hh.GetH(%s) => %v, %t", kv.Key, val, found)
+		}
+		hh, _ = hh.PutH(kv.Key, h30, -1)
+	}
+	if hh.Nentries() != uint(len(kvs)) {
+		t.Fatalf("hh.Nentries(),%d != %d after replacing every val", hh.Nentries(), len(kvs))
+	}
+
+	for _, kv := range kvs[:len(kvs)/2] {
+		var val, valH interface{}
+		var deleted, deletedH bool
+		h, val, deleted = h.Del(kv.Key)
+		hh, valH, deletedH = hh.DelH(kv.Key, kv.Key.Hash30())
+		if !deleted || !deletedH || val != kv.Val || valH != -1 {
+			t.Fatalf("Del(%s) => %v, %t; DelH() => %v, %t", kv.Key, val, deleted, valH, deletedH)
+		}
+	}
+	if h.Nentries() != hh.Nentries() {
+		t.Fatalf("h.Nentries(),%d != hh.Nentries(),%d", h.Nentries(), hh.Nentries())
+	}
+}
+
+// twinCollidingKey32 is a collidingKey whose Hash60() is constant too; so
+// collisionLeaf searches can not tell them apart without Equals().
+type twinCollidingKey32 struct {
+	collidingKey
+}
+
+func (k twinCollidingKey32) Hash60() key.HashVal60 {
+	return key.HashVal60(0x555555555555555)
+}
+
+func (k twinCollidingKey32) Equals(k1 key.Key) bool {
+	var tk, ok = k1.(twinCollidingKey32)
+	return ok && tk.Str() == k.Str()
+}
+
+func TestCollisionLeafSearch32(t *testing.T) {
+	var keys = buildCollidingKeys(64)
+	for _, k := range buildCollidingKeys(8) {
+		keys = append(keys, twinCollidingKey32{k.(collidingKey)})
+	}
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+	if h.MaxCollisionSize() != uint(len(keys)) {
+		t.Fatalf("h.MaxCollisionSize(),%d != %d", h.MaxCollisionSize(), len(keys))
+	}
+
+	for i, k := range keys {
+		if val, found := h.Get(k); !found || val != i {
+			t.Fatalf("h.Get(%s) => %v, %t; want %d", k, val, found, i)
+		}
+		var added bool
+		if h, added = h.Put(k, -i); added {
+			t.Fatalf("h.Put(%s) of an existing key => added", k)
+		}
+	}
+
+	for i := 0; i < len(keys); i += 2 {
+		var deleted bool
+		if h, _, deleted = h.Del(keys[i]); !deleted {
+			t.Fatalf("h.Del(%s) not deleted", keys[i])
+		}
+	}
+
+	for i, k := range keys {
+		var val, found = h.Get(k)
+		if found != (i%2 == 1) || (found && val != -i) {
+			t.Fatalf("h.Get(%s) => %v, %t after deleting every other key", k, val, found)
+		}
+	}
+}
+
+func TestBatchStream32(t *testing.T) {
+	var kvs = KVS[:10*1024+7]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	for _, batchSize := range []int{0, 1, 100, 1024, 20 * 1024} {
+		var seen = make(map[string]bool)
+		var total, numBatches int
+		for batch := range h.BatchStream(batchSize) {
+			numBatches++
+			if len(batch) == 0 || (batchSize > 0 && len(batch) > batchSize) {
+				t.Fatalf("BatchStream(%d): batch of %d key/val pairs", batchSize, len(batch))
+			}
+			for _, kv := range batch {
+				seen[kv.Key.String()] = true
+			}
+			total += len(batch)
+		}
+		if uint(total) != h.Nentries() || len(seen) != total {
+			t.Fatalf("BatchStream(%d): %d key/val pairs, %d unique; Nentries(),%d", batchSize, total, len(seen), h.Nentries())
+		}
+		if batchSize > 0 && numBatches != (total+batchSize-1)/batchSize {
+			t.Fatalf("BatchStream(%d): %d batches for %d key/val pairs", batchSize, numBatches, total)
+		}
+	}
+
+	for batch := range (hamt32.Hamt{}).BatchStream(10) {
+		t.Fatalf("empty Hamt BatchStream() sent %v", batch)
+	}
+}
+
+func TestValidateNodeMap32(t *testing.T) {
+	setLibrary(componly)
+	defer setLibrary(TYP)
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() => %s", err)
+	}
+
+	// a table with two nodes at one index, or out of order, is not decoded
+	for _, idxs := range [][]uint{{1, 1}, {2, 1}} {
+		var _, err = hamt32.DecodeStructure(bytes.NewReader(encodeWithIdxs(idxs)))
+		if err == nil || !strings.Contains(err.Error(), "ascending") {
+			t.Fatalf("hamt32.DecodeStructure() of idxs %v => %v; want an error", idxs, err)
+		}
+	}
+}
+
+func TestRepair32(t *testing.T) {
+	var idx = stringkey.New("aaa").Hash30().Index(0)
+
+	var h, err = hamt32.DecodeStructure(bytes.NewReader(encodeWithEmptyTables(idx)))
+	if err != nil {
+		t.Fatalf("hamt32.DecodeStructure() => %s", err)
+	}
+	if err = h.Validate(); err == nil {
+		t.Fatal("h.Validate() => nil for a Trie with empty tables")
+	}
+	if h.NumTables() != 4 {
+		t.Fatalf("h.NumTables(),%d != 4", h.NumTables())
+	}
+
+	var rh = h.Repair()
+	if err = rh.Validate(); err != nil {
+		t.Fatalf("rh.Validate() => %s", err)
+	}
+	if rh.NumTables() != 1 || rh.Nentries() != 1 {
+		t.Fatalf("rh.NumTables(),%d != 1 or rh.Nentries(),%d != 1", rh.NumTables(), rh.Nentries())
+	}
+	if val, found := rh.Get(stringkey.New("aaa")); !found || val != 1 {
+		t.Fatalf("rh.Get(\"aaa\") => %v, %t", val, found)
+	}
+
+	// Deleting the only key/val pair leaves nothing but empty tables.
+	var dh, _, _ = h.Del(stringkey.New("aaa"))
+	if dh = dh.Repair(); !dh.IsEmpty() {
+		t.Fatalf("!dh.Repair().IsEmpty(); dh=%s", dh)
+	}
+
+	if vh := TestHamt32.Repair(); vh != TestHamt32 {
+		t.Fatal("Repair() changed a valid Hamt")
+	}
+}
+
+func TestCollisionLeafBinarySearch32(t *testing.T) {
+	var saveLinear = hamt32.CollisionLinearSearch
+	defer func() { hamt32.CollisionLinearSearch = saveLinear }()
+
+	var keys = buildCollidingKeys(1001)
+	var missing = keys[1000]
+	keys = keys[:1000]
+	for _, k := range buildCollidingKeys(4) {
+		keys = append(keys, twinCollidingKey32{k.(collidingKey)})
+	}
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	for _, linear := range []bool{true, false} {
+		hamt32.CollisionLinearSearch = linear
+
+		for i, k := range keys {
+			if val, found := h.Get(k); !found || val != i {
+				t.Fatalf("linear=%t: h.Get(%s) => %v, %t; want %d", linear, k, val, found, i)
+			}
+		}
+		if _, found := h.Get(missing); found {
+			t.Fatalf("linear=%t: h.Get(%s) found a missing key", linear, missing)
+		}
+		if _, _, deleted := h.Del(missing); deleted {
+			t.Fatalf("linear=%t: h.Del(%s) deleted a missing key", linear, missing)
+		}
+
+		// the size 2 collisionLeaf del() shortcut
+		var h2 hamt32.Hamt
+		h2, _ = h2.Put(keys[0], 0)
+		h2, _ = h2.Put(keys[1], 1)
+		if _, _, deleted := h2.Del(missing); deleted {
+			t.Fatalf("linear=%t: h2.Del(%s) deleted a missing key", linear, missing)
+		}
+		var h1, val, deleted = h2.Del(keys[1])
+		if !deleted || val != 1 || h1.Nentries() != 1 {
+			t.Fatalf("linear=%t: h2.Del(%s) => %v, %t", linear, keys[1], val, deleted)
+		}
+		if val, found := h1.Get(keys[0]); !found || val != 0 {
+			t.Fatalf("linear=%t: h1.Get(%s) => %v, %t", linear, keys[0], val, found)
+		}
+	}
+}
+
+func TestClear32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var ch = h.Clear()
+	if !ch.IsEmpty() || ch != (hamt32.Hamt{}) {
+		t.Fatalf("h.Clear() => %s; want hamt32.Hamt{}", ch)
+	}
+	if h.Nentries() != 1024 {
+		t.Fatalf("h.Nentries(),%d != 1024 after h.Clear()", h.Nentries())
+	}
+	if _, found := h.Get(KVS[0].Key); !found {
+		t.Fatalf("h.Get(%s) not found after h.Clear()", KVS[0].Key)
+	}
+
+	var sh, _ = hamt32.NewStrict().Put(KVS[0].Key, 0)
+	var typedNil *int
+	if _, added := sh.Clear().Put(KVS[0].Key, typedNil); added {
+		t.Fatal("Clear() of a strict Hamt is not strict")
+	}
+}
+
+func TestWriteToReadFrom32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var buf bytes.Buffer
+	var n, err = h.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("h.WriteTo() => %d, %v; buf.Len()=%d", n, err, buf.Len())
+	}
+	var data = buf.Bytes()
+
+	var rh hamt32.Hamt
+	if _, err = rh.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("rh.ReadFrom() => %s", err)
+	}
+	if rh.LongString("") != h.LongString("") {
+		t.Fatal("rh read by ReadFrom() differs from h written by WriteTo()")
+	}
+
+	for _, i := range []int{0, len(data) / 2, len(data) - 1} {
+		var corrupt = append([]byte(nil), data...)
+		corrupt[i] ^= 0x20
+
+		var ch hamt32.Hamt
+		if _, err = ch.ReadFrom(bytes.NewReader(corrupt)); err != hamt32.ErrChecksum {
+			t.Fatalf("ReadFrom() of data with byte %d flipped => %v; want ErrChecksum", i, err)
+		}
+		if !ch.IsEmpty() {
+			t.Fatal("ReadFrom() changed the Hamt on error")
+		}
+	}
+}
+
+func TestCountInHashRange32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	const maxHash = uint32(1)<<30 - 1
+	var ranges = [][2]uint32{{0, maxHash}, {0, 0}, {maxHash / 2, maxHash}, {5, 4}}
+	var rnd = rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		var lo, hi = rnd.Uint32() & maxHash, rnd.Uint32() & maxHash
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		ranges = append(ranges, [2]uint32{lo, hi})
+	}
+	var h30 = uint32(kvs[0].Key.Hash30())
+	ranges = append(ranges, [2]uint32{h30, h30})
+
+	for _, r := range ranges {
+		var want uint
+		for _, kv := range kvs {
+			if h30 := uint32(kv.Key.Hash30()); r[0] <= h30 && h30 <= r[1] {
+				want++
+			}
+		}
+		if got := h.CountInHashRange(r[0], r[1]); got != want {
+			t.Fatalf("h.CountInHashRange(%#x, %#x),%d != %d", r[0], r[1], got, want)
+		}
+	}
+}
+
+func TestRangeHashRange32(t *testing.T) {
+	var kvs = KVS[:10*1024]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(3) {
+		h, _ = h.Put(k, i)
+	}
+
+	// adjacent ranges covering every hash; some of them empty
+	const maxHash = uint32(1)<<30 - 1
+	var cuts = []uint32{0, 1, 2, maxHash / 2, maxHash}
+	var rnd = rand.New(rand.NewSource(1))
+	for i := 0; i < 30; i++ {
+		cuts = append(cuts, rnd.Uint32()&maxHash)
+	}
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i] < cuts[j] })
+
+	var seen = make(map[string]int)
+	var lo uint32
+	for _, cut := range cuts {
+		h.RangeHashRange(lo, cut, func(k key.Key, v interface{}) bool {
+			if h30 := uint32(k.Hash30()); h30 < lo || cut < h30 {
+				t.Fatalf("RangeHashRange(%#x, %#x) visited %s with hash %#x", lo, cut, k, h30)
+			}
+			seen[fmt.Sprintf("%T %s", k, k)]++
+			return true
+		})
+		lo = cut + 1
+	}
+	h.RangeHashRange(lo, maxHash, func(k key.Key, v interface{}) bool {
+		seen[fmt.Sprintf("%T %s", k, k)]++
+		return true
+	})
+
+	if uint(len(seen)) != h.Nentries() {
+		t.Fatalf("adjacent ranges visited %d keys; want %d", len(seen), h.Nentries())
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("adjacent ranges visited %s %d times", k, n)
+		}
+	}
+
+	var count int
+	h.RangeHashRange(0, maxHash, func(k key.Key, v interface{}) bool {
+		count++
+		return count < 5
+	})
+	if count != 5 {
+		t.Fatalf("RangeHashRange() called fn %d times after it returned false; want 5", count)
+	}
+
+	h.RangeHashRange(5, 4, func(k key.Key, v interface{}) bool {
+		t.Fatalf("RangeHashRange(5, 4) visited %s", k)
+		return true
+	})
+}
+
+func TestEntriesSortedByValue32(t *testing.T) {
+	var byInt = func(a, b interface{}) bool { return a.(int) < b.(int) }
+
+	if kvs := (hamt32.Hamt{}).EntriesSortedByValue(byInt); len(kvs) != 0 {
+		t.Fatalf("empty Hamt EntriesSortedByValue() => %d key/val pairs", len(kvs))
+	}
+
+	var h hamt32.Hamt
+	for i, kv := range KVS[:10*1024] {
+		h, _ = h.Put(kv.Key, (i*7919)%1000) // ten keys per value
+	}
+
+	var pos = make(map[string]int)
+	for i, kv := range h.KeyVals() {
+		pos[kv.Key.String()] = i
+	}
+
+	var kvs = h.EntriesSortedByValue(byInt)
+	if uint(len(kvs)) != h.Nentries() {
+		t.Fatalf("len(EntriesSortedByValue()),%d != h.Nentries(),%d", len(kvs), h.Nentries())
+	}
+	var seen = make(map[string]bool)
+	for i, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("EntriesSortedByValue()[%d] = %s:%v; h.Get() => %v, %t", i, kv.Key, kv.Val, val, found)
+		}
+		if seen[kv.Key.String()] {
+			t.Fatalf("EntriesSortedByValue() has %s twice", kv.Key)
+		}
+		seen[kv.Key.String()] = true
+		if i == 0 {
+			continue
+		}
+		var prev = kvs[i-1]
+		if byInt(kv.Val, prev.Val) {
+			t.Fatalf("EntriesSortedByValue()[%d],%v < [%d],%v", i, kv.Val, i-1, prev.Val)
+		}
+		if kv.Val == prev.Val && pos[kv.Key.String()] < pos[prev.Key.String()] {
+			t.Fatalf("EntriesSortedByValue() has %s before %s, both %v, out of hash path order", prev.Key, kv.Key, kv.Val)
+		}
+	}
+}
+
+func TestAppendVariants32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var kvs = h.KeyVals()
+
+	var prefix = key.KeyVal{Key: stringkey.New("prefix"), Val: -1}
+	var ents = h.EntriesAppend([]key.KeyVal{prefix})
+	if len(ents) != len(kvs)+1 || ents[0] != prefix {
+		t.Fatalf("h.EntriesAppend() did not keep the buffer's contents")
+	}
+
+	var keys = h.KeysAppend(make([]key.Key, 0, 2048))
+	var vals = h.ValuesAppend(nil)
+	if len(keys) != len(kvs) || len(vals) != len(kvs) {
+		t.Fatalf("len(keys),%d or len(vals),%d != len(kvs),%d",
+			len(keys), len(vals), len(kvs))
+	}
+	for i, kv := range kvs {
+		if ents[i+1] != kv || keys[i] != kv.Key || vals[i] != kv.Val {
+			t.Fatalf("entry %d does not match h.KeyVals()[%d],%s", i, i, kv)
+		}
+	}
+
+	var empty hamt32.Hamt
+	if buf := empty.KeysAppend(nil); buf != nil {
+		t.Fatalf("empty.KeysAppend(nil) => %v; want nil", buf)
+	}
+}
+
+func TestGetOK32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	h, _ = h.Put(KVS[1024].Key, nil)
+
+	for _, kv := range KVS[:1024] {
+		if v := h.GetOK(kv.Key); v != kv.Val {
+			t.Fatalf("h.GetOK(%s),%v != %v", kv.Key, v, kv.Val)
+		}
+	}
+	for _, kv := range KVS[1024:2048] {
+		if v := h.GetOK(kv.Key); v != nil {
+			t.Fatalf("h.GetOK(%s),%v != nil", kv.Key, v)
+		}
+	}
+
+	var empty hamt32.Hamt
+	if v := empty.GetOK(KVS[0].Key); v != nil {
+		t.Fatalf("empty.GetOK(%s),%v != nil", KVS[0].Key, v)
+	}
+}
+
+func TestRangeOrdered32(t *testing.T) {
+	for _, h := range []hamt32.Hamt{{}, hamt32.NewFolded(hamt32.FoldMix)} {
+		for _, kv := range KVS[:16*1024] {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		for i, k := range buildCollidingKeys(3) {
+			h, _ = h.Put(k, -i)
+		}
+
+		var n uint
+		var prev string
+		h.RangeOrdered(func(k key.Key, v interface{}) bool {
+			var path = h.PathHash(k).String()
+			if path < prev {
+				t.Fatalf("RangeOrdered visited %s after %s", path, prev)
+			}
+			prev = path
+			n++
+			return true
+		})
+		if n != h.Nentries() {
+			t.Fatalf("RangeOrdered visited %d key/val pairs; want %d", n, h.Nentries())
+		}
+	}
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:16] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	var n int
+	h.RangeOrdered(func(k key.Key, v interface{}) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("RangeOrdered called fn %d times after it returned false", n)
+	}
+}
+
+func TestRangeDeepest32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:64*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	var pair = buildCollidingKeys(2)
+	for i, k := range pair {
+		h, _ = h.Put(k, -i)
+	}
+
+	var all = make(map[key.Key]interface{})
+	for _, kv := range h.KeyVals() {
+		all[kv.Key] = kv.Val
+	}
+
+	var deep = make(map[key.Key]interface{})
+	h.RangeDeepest(func(k key.Key, v interface{}) bool {
+		if val, found := all[k]; !found || val != v {
+			t.Fatalf("RangeDeepest visited %s,%v not in h.KeyVals()", k, v)
+		}
+		deep[k] = v
+		return true
+	})
+
+	for i, k := range pair {
+		if v, found := deep[k]; !found || v != -i {
+			t.Fatalf("colliding key %s => %v, %t; want %d, true", k, v, found, -i)
+		}
+	}
+	if len(deep) >= len(all) {
+		t.Fatalf("len(deep),%d >= len(all),%d", len(deep), len(all))
+	}
+
+	var n int
+	h.RangeDeepest(func(k key.Key, v interface{}) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("RangeDeepest called fn %d times after it returned false", n)
+	}
+}
+
+func TestPutBounded32(t *testing.T) {
+	const max = 16
+
+	var h hamt32.Hamt
+	var ok bool
+	for i, kv := range KVS[:max] {
+		if h, ok = h.PutBounded(kv.Key, kv.Val, max); !ok {
+			t.Fatalf("h.PutBounded(%s) rejected at Nentries()=%d", kv.Key, i)
+		}
+	}
+	if h.Nentries() != max {
+		t.Fatalf("h.Nentries(),%d != %d", h.Nentries(), max)
+	}
+
+	var nh hamt32.Hamt
+	if nh, ok = h.PutBounded(KVS[max].Key, KVS[max].Val, max); ok {
+		t.Fatalf("h.PutBounded(%s) past the bound was not rejected", KVS[max].Key)
+	}
+	if nh != h {
+		t.Fatal("rejected h.PutBounded() did not return h unchanged")
+	}
+	if _, found := nh.Get(KVS[max].Key); found {
+		t.Fatalf("rejected key %s found", KVS[max].Key)
+	}
+
+	// updating an existing key is exempt from the bound
+	if nh, ok = h.PutBounded(KVS[0].Key, -1, max); !ok {
+		t.Fatalf("h.PutBounded(%s) update at the bound was rejected", KVS[0].Key)
+	}
+	if v, _ := nh.Get(KVS[0].Key); v != -1 || nh.Nentries() != max {
+		t.Fatalf("update => %v, Nentries()=%d; want -1, %d", v, nh.Nentries(), max)
+	}
+	if nh, ok = h.PutBounded(KVS[0].Key, -1, max-1); !ok {
+		t.Fatalf("h.PutBounded(%s) update past the bound was rejected", KVS[0].Key)
+	}
+
+	// the bound only limits adding new keys
+	if nh, ok = h.PutBounded(KVS[max].Key, KVS[max].Val, max+1); !ok || nh.Nentries() != max+1 {
+		t.Fatalf("h.PutBounded(%s) below the bound => %t, Nentries()=%d",
+			KVS[max].Key, ok, nh.Nentries())
+	}
+}
+
+func TestGetOrCompute32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var calls int
+	var compute = func() interface{} {
+		calls++
+		return -1
+	}
+
+	// hit; the Hamt is unchanged and compute is not called
+	var nh, val = h.GetOrCompute(KVS[0].Key, compute)
+	if nh != h || val != KVS[0].Val || calls != 0 {
+		t.Fatalf("hit h.GetOrCompute(%s) => %v, %d calls, changed=%t", KVS[0].Key, val, calls, nh != h)
+	}
+
+	// miss; the computed value is returned and stored
+	nh, val = h.GetOrCompute(KVS[1024].Key, compute)
+	if val != -1 || calls != 1 {
+		t.Fatalf("miss h.GetOrCompute(%s) => %v, %d calls", KVS[1024].Key, val, calls)
+	}
+	if v, found := nh.Get(KVS[1024].Key); !found || v != -1 || nh.Nentries() != 1025 {
+		t.Fatalf("nh.Get(%s) => %v, %t; Nentries()=%d", KVS[1024].Key, v, found, nh.Nentries())
+	}
+	if _, found := h.Get(KVS[1024].Key); found {
+		t.Fatalf("h.Get(%s) found; original Hamt was modified", KVS[1024].Key)
+	}
+
+	// the stored value is a hit afterwards
+	if nh2, val := nh.GetOrCompute(KVS[1024].Key, compute); nh2 != nh || val != -1 || calls != 1 {
+		t.Fatalf("second nh.GetOrCompute(%s) => %v, %d calls", KVS[1024].Key, val, calls)
+	}
+}
+
+func TestMetrics32(t *testing.T) {
+	if m := (hamt32.Hamt{}).Metrics(); m != (hamt32.Metrics{}) {
+		t.Fatalf("Metrics() of a Hamt without metrics => %+v", m)
+	}
+
+	// a at the root; b and c in a table at depth 1, below the index of a
+	var a = hashedKey32{stringkey.New("a"), 0}
+	var b = hashedKey32{stringkey.New("b"), 1 << hamt32.Nbits}
+	var c = hashedKey32{stringkey.New("c"), 2 << hamt32.Nbits}
+	var d = hashedKey32{stringkey.New("d"), 1}
+
+	// sized, so the root is never a linearTable
+	var h = hamt32.NewSized(100).WithMetrics()
+	var h1, _ = h.Put(a, 1)  //empty; nothing copied
+	var h2, _ = h1.Put(b, 2) //root
+	var h3, _ = h2.Put(c, 3) //root and depth 1
+	h3, _ = h3.Put(c, 4)     //root and depth 1
+	h3.Get(a)
+	h3.Get(c)
+	h3.Get(d)
+	h1.Get(b)                //counted by h1 too
+	var h4, _, _ = h3.Del(b) //root and depth 1
+	h4.Del(d)                //not found; nothing copied
+	h4.GetStr("a")           //not a hashedKey32; a miss
+
+	var expected = hamt32.Metrics{
+		Gets:        5,
+		GetHits:     2,
+		GetMisses:   3,
+		Puts:        4,
+		Dels:        2,
+		NodesCopied: 7,
+	}
+	for i, nh := range []hamt32.Hamt{h, h1, h2, h3, h4, h4.Clear()} {
+		if m := nh.Metrics(); m != expected {
+			t.Fatalf("Hamt #%d Metrics() => %+v; expected %+v", i, m, expected)
+		}
+	}
+
+	// a new sink does not share the counts
+	if m := h4.WithMetrics().Metrics(); m != (hamt32.Metrics{}) {
+		t.Fatalf("h4.WithMetrics().Metrics() => %+v", m)
+	}
+}
+
+func TestGetCache32(t *testing.T) {
+	var h = hamt32.NewSized(4096).WithGetCache(16)
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(3) {
+		h, _ = h.Put(k, i)
+	}
+
+	// every version of the Hamt sees its own values; though they share the
+	// cache, and the same keys are read in each, again and again
+	var h1, _ = h.Put(KVS[0].Key, -1)
+	var h2, _, _ = h1.Del(KVS[0].Key)
+	for i := 0; i < 3; i++ {
+		for j, kv := range KVS[:64] {
+			if v, found := h.Get(kv.Key); !found || v != kv.Val {
+				t.Fatalf("h.Get(%s) => %v, %t", kv.Key, v, found)
+			}
+			var val interface{} = kv.Val
+			if j == 0 {
+				val = -1
+			}
+			if v, found := h1.Get(kv.Key); !found || v != val {
+				t.Fatalf("h1.Get(%s) => %v, %t; expected %v", kv.Key, v, found, val)
+			}
+			if v, found := h2.Get(kv.Key); found != (j != 0) || (found && v != kv.Val) {
+				t.Fatalf("h2.Get(%s) => %v, %t", kv.Key, v, found)
+			}
+		}
+	}
+
+	// a cached collision leaf is searched for the key
+	for i, k := range buildCollidingKeys(4) {
+		var v, found = h.Get(k)
+		if found != (i < 3) || (found && v != i) {
+			t.Fatalf("h.Get(%s) => %v, %t", k, v, found)
+		}
+	}
+
+	// so is a leaf cached by GetStr()
+	var s = KVS[1].Key.(*stringkey.StringKey).Str()
+	if v, found := h.GetStr(s); !found || v != KVS[1].Val {
+		t.Fatalf("h.GetStr(%s) => %v, %t", s, v, found)
+	}
+	if v, found := h.GetStr(s); !found || v != KVS[1].Val {
+		t.Fatalf("second h.GetStr(%s) => %v, %t", s, v, found)
+	}
+	if _, found := h.GetStr(s + "x"); found {
+		t.Fatalf("h.GetStr(%sx) found", s)
+	}
+
+	if nh := h.WithGetCache(0); nh.Clear() != (hamt32.NewSized(4096)) {
+		t.Fatal("h.WithGetCache(0) did not remove the cache")
+	}
+}
+
+// A Get of a new version of a Hamt replaces the entries cached by a superseded
+// version; which would otherwise keep its whole Trie.
+func TestGetCacheReleases32(t *testing.T) {
+	var released = make(chan struct{})
+	var val = new([64]byte)
+	runtime.SetFinalizer(val, func(*[64]byte) { close(released) })
+
+	// one slot; so every cached leaf replaces the one before it
+	var h0 = hamt32.Hamt{}.WithGetCache(1)
+	h0, _ = h0.Put(KVS[0].Key, val)
+	h0, _ = h0.Put(KVS[1].Key, KVS[1].Val)
+	h0.Get(KVS[0].Key)
+	h0.Get(KVS[0].Key) // marks the entry used
+
+	var h1, _, _ = h0.Del(KVS[0].Key)
+	h0, val = hamt32.Hamt{}, nil
+	if v, found := h1.Get(KVS[1].Key); !found || v != KVS[1].Val {
+		t.Fatalf("h1.Get(%s) => %v, %t", KVS[1].Key, v, found)
+	}
+
+	var isReleased bool
+	for i := 0; i < 10 && !isReleased; i++ {
+		runtime.GC()
+		select {
+		case <-released:
+			isReleased = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	runtime.KeepAlive(h1) // and so the cache
+	if !isReleased {
+		t.Fatal("the cache keeps the leaf, and Trie, of a superseded Hamt")
+	}
+}
+
+func TestSuggestConfig32(t *testing.T) {
+	var typ = TYP
+	defer setLibrary(typ)
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = 0
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	setLibrary(hybrid)
+	var cur = hamt32.CurrentConfig()
+
+	if cfg := (hamt32.Hamt{}).SuggestConfig(); cfg != cur {
+		t.Fatalf("empty Hamt SuggestConfig() => %+v; expected %+v", cfg, cur)
+	}
+
+	// well distributed
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if cfg := h.SuggestConfig(); cfg != cur {
+		t.Fatalf("well distributed SuggestConfig() => %+v; expected %+v\n%s",
+			cfg, cur, h.SuggestConfigReason())
+	}
+	if reason := h.SuggestConfigReason(); !strings.Contains(reason, "suits") {
+		t.Fatalf("well distributed SuggestConfigReason() => %q", reason)
+	}
+
+	// skewed; a big collision leaf
+	var skewed = h
+	for i, k := range buildCollidingKeys(20) {
+		skewed, _ = skewed.Put(k, i)
+	}
+	var cfg = skewed.SuggestConfig()
+	if !cfg.UseHamt64 || cfg.CollisionOverflowThreshold != 8 {
+		t.Fatalf("skewed SuggestConfig() => %+v", cfg)
+	}
+	if n := len(strings.Split(skewed.SuggestConfigReason(), "\n")); n != 2 {
+		t.Fatalf("skewed SuggestConfigReason() has %d lines; expected 2:\n%s",
+			n, skewed.SuggestConfigReason())
+	}
+
+	// dense compressedTables are not upgraded
+	setLibrary(componly)
+	h = hamt32.Hamt{}
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if cfg := h.SuggestConfig(); !cfg.GradeTables || cfg.FullTableInit {
+		t.Fatalf("componly SuggestConfig() => %+v", cfg)
+	}
+
+	// sparse fullTables are not downgraded
+	setLibrary(fullonly)
+	h = hamt32.Hamt{}
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if cfg := h.SuggestConfig(); !cfg.GradeTables || cfg.FullTableInit {
+		t.Fatalf("fullonly SuggestConfig() => %+v", cfg)
+	}
+
+	// Apply sets what CurrentConfig returns
+	cfg.Apply()
+	cfg.UseHamt64 = false
+	if cur := hamt32.CurrentConfig(); cur != cfg {
+		t.Fatalf("CurrentConfig() after Apply() => %+v; expected %+v", cur, cfg)
+	}
+}
+
+func TestWithConfig32(t *testing.T) {
+	var h = hamt32.Hamt{}.WithMetrics()
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	var before = h.Metrics()
+
+	var cfg = hamt32.CurrentConfig()
+	cfg.FullTableInit = !cfg.FullTableInit
+	var fork = h.WithConfig(cfg)
+
+	// the whole Trie is shared; FirstDiff does not compare a single value
+	var compared int
+	if _, diff := h.FirstDiff(fork, func(a, b interface{}) bool {
+		compared++
+		return a == b
+	}); diff || compared != 0 {
+		t.Fatalf("h.FirstDiff(fork) => %t after comparing %d values; want a shared Trie", diff, compared)
+	}
+	if m := fork.Metrics(); m != (hamt32.Metrics{}) {
+		t.Fatalf("fork.Metrics(),%+v != zero Metrics", m)
+	}
+
+	if fork.SuggestBaseline() != cfg {
+		t.Fatalf("fork.SuggestBaseline(),%+v != %+v", fork.SuggestBaseline(), cfg)
+	}
+	if h.SuggestBaseline() != hamt32.CurrentConfig() {
+		t.Fatalf("h.SuggestBaseline(),%+v != CurrentConfig(),%+v", h.SuggestBaseline(), hamt32.CurrentConfig())
+	}
+	if hamt32.CurrentConfig() == cfg {
+		t.Fatal("WithConfig() set the package variables")
+	}
+
+	// the metrics of h and fork, and of the Hamts derived from them, are
+	// counted apart
+	fork.Get(KVS[0].Key)
+	fork, _ = fork.Put(KVS[1024].Key, KVS[1024].Val)
+	if m := h.Metrics(); m != before {
+		t.Fatalf("h.Metrics(),%+v != %+v after using fork", m, before)
+	}
+	if m := fork.Metrics(); m.Gets != 1 || m.Puts != 1 || m.NodesCopied == 0 {
+		t.Fatalf("fork.Metrics() => %+v", m)
+	}
+	h.Get(KVS[0].Key)
+	if m := fork.Metrics(); m.Gets != 1 {
+		t.Fatalf("fork.Metrics().Gets,%d != 1 after h.Get()", m.Gets)
+	}
+	if c := fork.Clear(); c.SuggestBaseline() != cfg {
+		t.Fatal("fork.Clear() dropped its SuggestBaseline")
+	}
+
+	// a fork of a Hamt without Metrics counts into Metrics of its own; the
+	// Hamt it was forked from still counts nothing
+	var plain hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		plain, _ = plain.Put(kv.Key, kv.Val)
+	}
+	var plainFork = plain.WithConfig(cfg)
+	plainFork.Get(KVS[0].Key)
+	plainFork, _ = plainFork.Put(KVS[1024].Key, KVS[1024].Val)
+	plain.Get(KVS[0].Key)
+	if m := plainFork.Metrics(); m.Gets != 1 || m.Puts != 1 || m.NodesCopied == 0 {
+		t.Fatalf("plainFork.Metrics() => %+v", m)
+	}
+	if m := plain.Metrics(); m != (hamt32.Metrics{}) {
+		t.Fatalf("plain.Metrics(),%+v != zero Metrics", m)
+	}
+
+	// WithSuggestBaseline only sets the baseline; it adds no Metrics
+	var based = plain.WithSuggestBaseline(cfg)
+	based.Get(KVS[0].Key)
+	if based.SuggestBaseline() != cfg {
+		t.Fatalf("based.SuggestBaseline(),%+v != %+v", based.SuggestBaseline(), cfg)
+	}
+	if m := based.Metrics(); m != (hamt32.Metrics{}) {
+		t.Fatalf("based.Metrics(),%+v != zero Metrics", m)
+	}
+}
+
+func TestPutInPlace32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		if !h.PutInPlace(kv.Key, kv.Val) {
+			t.Fatalf("h.PutInPlace(%s) => false", kv.Key)
+		}
+	}
+	if h.Nentries() != 1024 {
+		t.Fatalf("h.Nentries() => %d after 1024 PutInPlace()", h.Nentries())
+	}
+
+	var saved = h
+	if h.PutInPlace(KVS[0].Key, -1) {
+		t.Fatalf("h.PutInPlace(%s) of a stored key => true", KVS[0].Key)
+	}
+	if !h.PutInPlace(KVS[1024].Key, KVS[1024].Val) {
+		t.Fatalf("h.PutInPlace(%s) => false", KVS[1024].Key)
+	}
+
+	// the copy taken before is unaffected
+	if v, _ := saved.Get(KVS[0].Key); v != KVS[0].Val {
+		t.Fatalf("saved.Get(%s) => %v; expected %v", KVS[0].Key, v, KVS[0].Val)
+	}
+	if _, found := saved.Get(KVS[1024].Key); found || saved.Nentries() != 1024 {
+		t.Fatalf("saved.Get(%s) found=%t, saved.Nentries()=%d", KVS[1024].Key, found, saved.Nentries())
+	}
+	if v, _ := h.Get(KVS[0].Key); v != -1 || h.Nentries() != 1025 {
+		t.Fatalf("h.Get(%s) => %v, h.Nentries()=%d", KVS[0].Key, v, h.Nentries())
+	}
+
+	saved = h
+	if val, deleted := h.DelInPlace(KVS[1024].Key); !deleted || val != KVS[1024].Val {
+		t.Fatalf("h.DelInPlace(%s) => %v, %t", KVS[1024].Key, val, deleted)
+	}
+	if _, deleted := h.DelInPlace(KVS[1024].Key); deleted {
+		t.Fatalf("second h.DelInPlace(%s) => true", KVS[1024].Key)
+	}
+	if _, found := saved.Get(KVS[1024].Key); !found || h.Nentries() != 1024 {
+		t.Fatalf("saved.Get(%s) found=%t, h.Nentries()=%d", KVS[1024].Key, found, h.Nentries())
+	}
+}
+
+func TestDelDetailed32(t *testing.T) {
+	var keys = buildCollidingKeys(3)
+	var other = KVS[0]
+
+	var saveLinearThreshold = hamt32.LinearThreshold
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	for _, linear := range []uint{8, 0} {
+		hamt32.LinearThreshold = linear
+
+		var h hamt32.Hamt
+		for i, k := range keys {
+			h, _ = h.Put(k, i)
+		}
+		h, _ = h.Put(other.Key, other.Val)
+
+		var steps = []struct {
+			k         key.Key
+			deleted   bool
+			collapsed bool
+			kind      string
+		}{
+			{keys[0], true, false, "collision"},  // 3 key/val pairs to 2
+			{keys[0], false, false, "collision"}, // already deleted
+			{keys[1], true, true, "flat"},        // 2 key/val pairs to a flatLeaf
+			{keys[2], true, false, ""},           // the root keeps other
+			{other.Key, true, true, ""},          // the root is removed
+		}
+		for i, step := range steps {
+			var val interface{}
+			var deleted, collapsed bool
+			h, val, deleted, collapsed = h.DelDetailed(step.k)
+			if deleted != step.deleted || collapsed != step.collapsed {
+				t.Fatalf("LinearThreshold=%d: step %d: DelDetailed(%s) => %v, %t, %t; want deleted=%t, collapsed=%t",
+					linear, i, step.k, val, deleted, collapsed, step.deleted, step.collapsed)
+			}
+			if step.kind != "" {
+				if _, kind, _ := h.GetKind(keys[2]); kind != step.kind {
+					t.Fatalf("LinearThreshold=%d: step %d: leaf of %s is %q; want %q",
+						linear, i, keys[2], kind, step.kind)
+				}
+			}
+		}
+		if !h.IsEmpty() {
+			t.Fatalf("LinearThreshold=%d: h not empty after deleting every key", linear)
+		}
+	}
+}
+
+// countNestedMap32 returns the number of key/val pairs in m, as returned by
+// ToNestedMap, checking that each is at the index of its hash path.
+func countNestedMap32(t *testing.T, m map[uint]interface{}, depth uint) uint {
+	var n uint
+	for idx, ent := range m {
+		var kvs []key.KeyVal
+		switch x := ent.(type) {
+		case map[uint]interface{}:
+			n += countNestedMap32(t, x, depth+1)
+			continue
+		case key.KeyVal:
+			kvs = []key.KeyVal{x}
+		case []key.KeyVal:
+			kvs = x
+		default:
+			t.Fatalf("ToNestedMap() entry %d at depth %d is a %T", idx, depth, ent)
+		}
+		for _, kv := range kvs {
+			if i := kv.Key.Hash30().Index(depth); i != idx {
+				t.Fatalf("%s at index %d at depth %d; expected %d", kv.Key, idx, depth, i)
+			}
+		}
+		n += uint(len(kvs))
+	}
+	return n
+}
+
+func TestToNestedMap32(t *testing.T) {
+	if m := (hamt32.Hamt{}).ToNestedMap(); len(m) != 0 {
+		t.Fatalf("empty Hamt ToNestedMap() => %v", m)
+	}
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if n := countNestedMap32(t, h.ToNestedMap(), 0); n != h.Nentries() {
+		t.Fatalf("small ToNestedMap() holds %d key/val pairs; expected %d", n, h.Nentries())
+	}
+
+	for _, kv := range KVS[4:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(3) {
+		h, _ = h.Put(k, i)
+	}
+	for _, nh := range []hamt32.Hamt{h, h.Compact()} {
+		if n := countNestedMap32(t, nh.ToNestedMap(), 0); n != nh.Nentries() {
+			t.Fatalf("ToNestedMap() holds %d key/val pairs; expected %d", n, nh.Nentries())
+		}
+	}
+}
+
+func TestCompareAndSet32(t *testing.T) {
+	var eq = func(a, b interface{}) bool { return a == b }
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var expected = []key.KeyVal{KVS[0], KVS[1]}
+	var updates = []key.KeyVal{{Key: KVS[0].Key, Val: -1}, {Key: KVS[2000].Key, Val: -2}}
+
+	// success; all updates are applied
+	var nh, ok = h.CompareAndSet(expected, updates, eq)
+	if !ok {
+		t.Fatal("h.CompareAndSet() with holding expectations => false")
+	}
+	for _, kv := range updates {
+		if val, _ := nh.Get(kv.Key); val != kv.Val {
+			t.Fatalf("nh.Get(%s),%v != %v", kv.Key, val, kv.Val)
+		}
+	}
+	if nh.Nentries() != 1025 {
+		t.Fatalf("nh.Nentries(),%d != 1025", nh.Nentries())
+	}
+	if val, _ := h.Get(KVS[0].Key); val != KVS[0].Val {
+		t.Fatalf("h.Get(%s),%v != %v; original Hamt was modified", KVS[0].Key, val, KVS[0].Val)
+	}
+
+	// expectations violated; by an unequal value, and by an absent key
+	for _, expected := range [][]key.KeyVal{
+		{KVS[0], {Key: KVS[1].Key, Val: -1}},
+		{KVS[0], KVS[3000]},
+	} {
+		if nh, ok = h.CompareAndSet(expected, updates, eq); ok || nh != h {
+			t.Fatalf("h.CompareAndSet(%v) => %t, changed=%t; want false, unchanged", expected, ok, nh != h)
+		}
+	}
+
+	// no expectations always hold
+	if _, ok = h.CompareAndSet(nil, updates, eq); !ok {
+		t.Fatal("h.CompareAndSet(nil) => false")
+	}
+}
+
+func TestFirstDiff32(t *testing.T) {
+	var eq = func(a, b interface{}) bool { return a == b }
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	// identical; both the same version and a separately built one
+	var h2 hamt32.Hamt
+	for i := 4095; i >= 0; i-- {
+		h2, _ = h2.Put(KVS[i].Key, KVS[i].Val)
+	}
+	for _, o := range []hamt32.Hamt{h, h2} {
+		if k, diff := h.FirstDiff(o, eq); diff {
+			t.Fatalf("h.FirstDiff(identical) => %s, true", k)
+		}
+	}
+	if k, diff := (hamt32.Hamt{}).FirstDiff(hamt32.Hamt{}, eq); diff {
+		t.Fatalf("empty.FirstDiff(empty) => %s, true", k)
+	}
+
+	// one value differs
+	var changed, _ = h.Put(KVS[100].Key, -1)
+	for _, pair := range [][2]hamt32.Hamt{{h, changed}, {changed, h}} {
+		if k, diff := pair[0].FirstDiff(pair[1], eq); !diff || !k.Equals(KVS[100].Key) {
+			t.Fatalf("FirstDiff() => %v, %t; want %s, true", k, diff, KVS[100].Key)
+		}
+	}
+	var always = func(a, b interface{}) bool { return true }
+	if k, diff := h.FirstDiff(changed, always); diff {
+		t.Fatalf("h.FirstDiff(changed, always) => %s, true", k)
+	}
+
+	// sizes differ; one key missing
+	var smaller, _, _ = h.Del(KVS[200].Key)
+	for _, pair := range [][2]hamt32.Hamt{{h, smaller}, {smaller, h}} {
+		if k, diff := pair[0].FirstDiff(pair[1], eq); !diff || !k.Equals(KVS[200].Key) {
+			t.Fatalf("FirstDiff() => %v, %t; want %s, true", k, diff, KVS[200].Key)
+		}
+	}
+	if k, diff := h.FirstDiff(hamt32.Hamt{}, eq); !diff || k == nil {
+		t.Fatalf("h.FirstDiff(empty) => %v, %t; want a key, true", k, diff)
+	}
+}
+
+// skewedKey is a stringkey whose Hash60() only varies above its lowest 30
+// bits; the bits FoldNone uses.
+type skewedKey struct {
+	*stringkey.StringKey
+	n uint64
+}
+
+func (k skewedKey) Hash60() key.HashVal60 {
+	return key.HashVal60(k.n << 30)
+}
+
+func (k skewedKey) Equals(k1 key.Key) bool {
+	var sk, ok = k1.(skewedKey)
+	return ok && sk.Str() == k.Str()
+}
+
+func TestFold32(t *testing.T) {
+	var keys = make([]key.Key, 1024)
+	for i := range keys {
+		keys[i] = skewedKey{stringkey.New(KVS[i].Key.String()), uint64(i)}
+	}
+
+	var collisions = make(map[hamt32.Fold]uint)
+	for _, fold := range []hamt32.Fold{hamt32.FoldXor, hamt32.FoldNone, hamt32.FoldMix} {
+		var h = hamt32.NewFolded(fold)
+		for i, k := range keys {
+			h, _ = h.Put(k, i)
+		}
+		for i, k := range keys {
+			if val, found := h.Get(k); !found || val != i {
+				t.Fatalf("fold %d: h.Get(%s) => %v, %t; want %d", fold, k, val, found, i)
+			}
+		}
+		for _, kv := range h.KeyVals() {
+			if _, ok := hamt32.UnfoldKey(kv.Key).(skewedKey); !ok {
+				t.Fatalf("fold %d: UnfoldKey(%s) is a %T", fold, kv.Key, hamt32.UnfoldKey(kv.Key))
+			}
+		}
+		if cleared, _ := h.Clear().Put(keys[0], 0); cleared.PathHash(keys[0]) != h.PathHash(keys[0]) {
+			t.Fatalf("fold %d: h.Clear() lost the fold", fold)
+		}
+		collisions[fold] = h.MaxCollisionSize()
+	}
+
+	if collisions[hamt32.FoldNone] != uint(len(keys)) {
+		t.Fatalf("FoldNone MaxCollisionSize(),%d != %d", collisions[hamt32.FoldNone], len(keys))
+	}
+	if collisions[hamt32.FoldMix] > 2 || collisions[hamt32.FoldXor] > 2 {
+		t.Fatalf("MaxCollisionSize() FoldMix,%d or FoldXor,%d > 2",
+			collisions[hamt32.FoldMix], collisions[hamt32.FoldXor])
+	}
+
+	var h = hamt32.NewFolded(hamt32.FoldMix)
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	var buf bytes.Buffer
+	if err := h.EncodeStructure(&buf); err != nil {
+		t.Fatalf("h.EncodeStructure() => %s", err)
+	}
+	var dh, err = hamt32.DecodeStructure(&buf)
+	if err != nil {
+		t.Fatalf("hamt32.DecodeStructure() => %s", err)
+	}
+	for _, kv := range KVS[:1024] {
+		if val, found := dh.GetStr(kv.Key.String()); !found || val != kv.Val {
+			t.Fatalf("dh.GetStr(%s) => %v, %t", kv.Key, val, found)
+		}
+	}
+	if dh, _, _ = dh.Del(KVS[0].Key); dh.Nentries() != 1023 {
+		t.Fatalf("dh.Del(%s) did not delete", KVS[0].Key)
+	}
+}
+
+func TestReport32(t *testing.T) {
+	var h = hamt32.NewSized(8 * 1024)
+	for _, kv := range KVS[:8*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(3) {
+		h, _ = h.Put(k, i)
+	}
+
+	var report = h.Report()
+
+	var entries, tables, full, comp, chain, linear, leafs, flat, coll, over, maxColl uint
+	var avgDepth float64
+	var bytes uint
+	var byDepth string
+	var lines = strings.Split(report, "\n")
+	var scans = []struct {
+		format string
+		args   []interface{}
+	}{
+		{"entries: %d", []interface{}{&entries}},
+		{"tables: %d (full: %d, compressed: %d, chain: %d, linear: %d)", []interface{}{&tables, &full, &comp, &chain, &linear}},
+		{"leafs: %d (flat: %d, collision: %d, overflow: %d)", []interface{}{&leafs, &flat, &coll, &over}},
+		{"entries by depth: %s", []interface{}{&byDepth}},
+		{"max collision size: %d", []interface{}{&maxColl}},
+		{"average leaf depth: %f", []interface{}{&avgDepth}},
+		{"estimated bytes: %d", []interface{}{&bytes}},
+	}
+	if len(lines) != len(scans) {
+		t.Fatalf("h.Report() has %d lines; want %d:\n%s", len(lines), len(scans), report)
+	}
+	for i, s := range scans {
+		if _, err := fmt.Sscanf(lines[i], s.format, s.args...); err != nil {
+			t.Fatalf("line %q does not match %q: %s", lines[i], s.format, err)
+		}
+	}
+
+	var sum uint
+	for _, field := range strings.Fields(strings.TrimPrefix(lines[3], "entries by depth: ")) {
+		var depth, n uint
+		if _, err := fmt.Sscanf(field, "%d:%d", &depth, &n); err != nil {
+			t.Fatalf("bad depth count %q: %s", field, err)
+		}
+		sum += n
+	}
+
+	switch {
+	case entries != h.Nentries() || sum != entries:
+		t.Fatalf("entries,%d and sum of entries by depth,%d != h.Nentries(),%d", entries, sum, h.Nentries())
+	case tables != h.NumTables() || full+comp+chain+linear != tables:
+		t.Fatalf("tables,%d != h.NumTables(),%d or the sum of table types", tables, h.NumTables())
+	case flat+coll+over != leafs || leafs+2 != entries:
+		t.Fatalf("leafs,%d != the sum of leaf types or entries-2,%d", leafs, entries-2)
+	case maxColl != h.MaxCollisionSize() || maxColl != 3:
+		t.Fatalf("max collision size,%d != h.MaxCollisionSize(),%d", maxColl, h.MaxCollisionSize())
+	case avgDepth <= 0 || avgDepth > float64(hamt32.MaxDepth):
+		t.Fatalf("average leaf depth,%f out of range", avgDepth)
+	case bytes == 0:
+		t.Fatal("estimated bytes == 0")
+	}
+
+	var empty hamt32.Hamt
+	if r := empty.Report(); !strings.HasPrefix(r, "entries: 0\n") {
+		t.Fatalf("empty.Report() =>\n%s", r)
+	}
+
+	// The root of a small Hamt is a linearTable; one table of neither type.
+	var saveLinearThreshold = hamt32.LinearThreshold
+	hamt32.LinearThreshold = 8
+	defer func() { hamt32.LinearThreshold = saveLinearThreshold }()
+
+	var small hamt32.Hamt
+	for _, kv := range KVS[:4] {
+		small, _ = small.Put(kv.Key, kv.Val)
+	}
+	var smallFull, smallComp = small.TableComposition()
+	if small.NumTables() != 1 || smallFull != 0 || smallComp != 0 {
+		t.Fatalf("small.NumTables(),%d != 1 or small.TableComposition() => %d, %d",
+			small.NumTables(), smallFull, smallComp)
+	}
+	if r := small.Report(); !strings.Contains(r, "\ntables: 1 (full: 0, compressed: 0, chain: 0, linear: 1)\n") {
+		t.Fatalf("small.Report() =>\n%s", r)
+	}
+}
+
+func TestEqualsCollisionOrder32(t *testing.T) {
+	var keys = buildCollidingKeys(16)
+	for _, k := range buildCollidingKeys(8) {
+		keys = append(keys, twinCollidingKey32{k.(collidingKey)})
+	}
+
+	var a, b hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		a, _ = a.Put(kv.Key, kv.Val)
+	}
+	b = a
+	for i := range keys {
+		a, _ = a.Put(keys[i], i)
+		var j = len(keys) - 1 - i
+		b, _ = b.Put(keys[j], j)
+	}
+
+	var akvs, bkvs = a.KeyVals(), b.KeyVals()
+	var sameOrder = true
+	for i := range akvs {
+		if akvs[i] != bkvs[i] {
+			sameOrder = false
+		}
+	}
+	if sameOrder {
+		t.Fatal("collision leafs built in reverse order have the same order")
+	}
+
+	if !a.Equals(b) || !b.Equals(a) {
+		t.Fatal("a.Equals(b) is false for the same key/val pairs")
+	}
+
+	var c, _ = b.Put(keys[0], -1)
+	if a.Equals(c) {
+		t.Fatalf("a.Equals(c) is true with a different value for %s", keys[0])
+	}
+	var d, _, _ = b.Del(keys[0])
+	if a.Equals(d) {
+		t.Fatalf("a.Equals(d) is true without %s", keys[0])
+	}
+}
+
+func TestBuildFromSorted32(t *testing.T) {
+	var kvs = KVS[:32*1024]
+	var h = createHamt32("TestBuildFromSorted32", kvs, TYP)
+	var sorted = h.KeyVals()
+
+	var bh = hamt32.BuildFromSorted(sorted)
+	if bh.Nentries() != h.Nentries() || !bh.Equals(h) {
+		t.Fatalf("BuildFromSorted() Nentries()=%d does not equal the Put built Hamt", bh.Nentries())
+	}
+	if bh.LongString("") != h.LongString("") {
+		t.Fatal("BuildFromSorted() structure differs from the Put built Hamt")
+	}
+
+	// collision runs, and a duplicate key whose last val is kept
+	var ch = h
+	for i, k := range buildCollidingKeys(5) {
+		ch, _ = ch.Put(k, i)
+	}
+	sorted = ch.KeyVals()
+	var dup = sorted[len(sorted)/2]
+	var withDup = append(append([]key.KeyVal{}, sorted[:len(sorted)/2+1]...),
+		key.KeyVal{Key: dup.Key, Val: -1})
+	withDup = append(withDup, sorted[len(sorted)/2+1:]...)
+
+	bh = hamt32.BuildFromSorted(withDup)
+	if bh.Nentries() != ch.Nentries() || bh.MaxCollisionSize() != 5 {
+		t.Fatalf("bh.Nentries(),%d != %d or bh.MaxCollisionSize(),%d != 5",
+			bh.Nentries(), ch.Nentries(), bh.MaxCollisionSize())
+	}
+	if val, _ := bh.Get(dup.Key); val != -1 {
+		t.Fatalf("bh.Get(%s),%v != -1; the last val of a duplicate key", dup.Key, val)
+	}
+	ch, _ = ch.Put(dup.Key, -1)
+	if !bh.Equals(ch) {
+		t.Fatal("BuildFromSorted() of collision runs does not equal the Put built Hamt")
+	}
+
+	// not sorted; falls back to Put
+	bh = hamt32.BuildFromSorted(kvs[:1024])
+	for _, kv := range kvs[:1024] {
+		if val, found := bh.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("bh.Get(%s) => %v, %t; want %v, true", kv.Key, val, found, kv.Val)
+		}
+	}
+
+	if !hamt32.BuildFromSorted(nil).IsEmpty() {
+		t.Fatal("BuildFromSorted(nil) is not empty")
+	}
+}
+
+func TestAdaptiveThreshold32(t *testing.T) {
+	setLibrary(componly)
+	defer setLibrary(TYP)
+
+	const threshold = 8
+
+	var plain hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		plain, _ = plain.Put(kv.Key, kv.Val)
+	}
+	var h = hamt32.Hamt{}.WithAdaptiveThreshold(threshold)
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if typ := rootTableType32(h); typ != "compressedTable" {
+		t.Fatalf("root %s != compressedTable before any lookups", typ)
+	}
+
+	var hot = KVS[0]
+	for i := 0; i < threshold; i++ {
+		plain.Get(hot.Key)
+	}
+	var np, _ = plain.Put(hot.Key, hot.Val)
+	if typ := rootTableType32(np); typ != "compressedTable" {
+		t.Fatalf("root %s != compressedTable without an AdaptiveThreshold", typ)
+	}
+
+	for i := 0; i < threshold-1; i++ {
+		h.Get(hot.Key)
+	}
+	var nh, _ = h.Put(hot.Key, hot.Val)
+	if typ := rootTableType32(nh); typ != "compressedTable" {
+		t.Fatalf("root %s != compressedTable below AdaptiveThreshold lookups", typ)
+	}
+
+	h.Get(hot.Key)
+	nh, _ = h.Put(hot.Key, hot.Val)
+	if typ := rootTableType32(nh); typ != "fullTable" {
+		t.Fatalf("root %s != fullTable after AdaptiveThreshold lookups", typ)
+	}
+	if typ := rootTableType32(h); typ != "compressedTable" {
+		t.Fatalf("root of the original Hamt %s != compressedTable", typ)
+	}
+	if !nh.Equals(h) {
+		t.Fatal("promoting tables changed the key/val pairs")
+	}
+}
+
+func TestHasAll32(t *testing.T) {
+	var keys = make([]key.Key, 0, 2048)
+	for i := 0; i < 1024; i++ {
+		keys = append(keys, KVS[i].Key, KVS[4096+i].Key)
+	}
+
+	var empty hamt32.Hamt
+	var has = empty.HasAll(keys)
+	if len(has) != len(keys) {
+		t.Fatalf("len(empty.HasAll(keys)),%d != %d", len(has), len(keys))
+	}
+	for i, found := range has {
+		if found {
+			t.Fatalf("empty.HasAll(keys)[%d] for %s is true", i, keys[i])
+		}
+	}
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	has = h.HasAll(keys)
+	if len(has) != len(keys) {
+		t.Fatalf("len(h.HasAll(keys)),%d != %d", len(has), len(keys))
+	}
+	for i, found := range has {
+		// even keys are present, odd keys absent
+		if found != (i%2 == 0) {
+			t.Fatalf("h.HasAll(keys)[%d] for %s is %t", i, keys[i], found)
+		}
+	}
+
+	if has = h.HasAll(nil); len(has) != 0 {
+		t.Fatalf("h.HasAll(nil) => %v", has)
+	}
+}
+
+func TestProject32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	// every third key of the Hamt, and some keys not in it
+	var keys []key.Key
+	var want = make(map[key.Key]interface{})
+	for i := 0; i < 4096; i += 3 {
+		keys = append(keys, KVS[i].Key)
+		want[KVS[i].Key] = KVS[i].Val
+	}
+	keys = append(keys, KVS[4096].Key, KVS[5000].Key)
+
+	var ph = h.Project(keys)
+	if ph.Nentries() != uint(len(want)) {
+		t.Fatalf("ph.Nentries(),%d != %d", ph.Nentries(), len(want))
+	}
+	for _, kv := range ph.KeyVals() {
+		if val, found := want[kv.Key]; !found || val != kv.Val {
+			t.Fatalf("ph has %s,%v not requested and in h", kv.Key, kv.Val)
+		}
+	}
+	if h.Nentries() != 4096 {
+		t.Fatalf("h.Nentries(),%d != 4096 after h.Project()", h.Nentries())
+	}
+
+	if ph = h.Project(nil); !ph.IsEmpty() {
+		t.Fatalf("h.Project(nil) is not empty; Nentries()=%d", ph.Nentries())
+	}
+	if ph = (hamt32.Hamt{}).Project(keys); !ph.IsEmpty() {
+		t.Fatalf("empty Hamt Project() is not empty; Nentries()=%d", ph.Nentries())
+	}
+}
+
+func TestRekey32(t *testing.T) {
+	var h = hamt32.NewStrict()
+	for i := 0; i < 1000; i++ {
+		h, _ = h.Put(stringkey.New(fmt.Sprintf("old/%d", i)), i)
+		h, _ = h.Put(stringkey.New(fmt.Sprintf("keep/%d", i)), -i)
+		h, _ = h.Put(stringkey.New(fmt.Sprintf("drop/%d", i)), i)
+	}
+
+	// rename the old/ prefix to new/, and drop drop/
+	var rh = h.Rekey(func(k key.Key) (key.Key, bool) {
+		var s = k.(*stringkey.StringKey).Str()
+		switch {
+		case strings.HasPrefix(s, "old/"):
+			return stringkey.New("new/" + s[len("old/"):]), true
+		case strings.HasPrefix(s, "drop/"):
+			return nil, false
+		}
+		return k, true
+	})
+	if rh.Nentries() != 2000 || h.Nentries() != 3000 {
+		t.Fatalf("rh.Nentries()=%d, h.Nentries()=%d; expected 2000, 3000", rh.Nentries(), h.Nentries())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, found := rh.Get(stringkey.New(fmt.Sprintf("new/%d", i))); !found || v != i {
+			t.Fatalf("rh.Get(new/%d) => %v, %t", i, v, found)
+		}
+		if v, found := rh.Get(stringkey.New(fmt.Sprintf("keep/%d", i))); !found || v != -i {
+			t.Fatalf("rh.Get(keep/%d) => %v, %t", i, v, found)
+		}
+	}
+	if rh.Clear() != h.Clear() {
+		t.Fatal("rh does not keep the options of h")
+	}
+
+	// map every key to its first letter; the last, in hash path order, wins
+	var first = func(k key.Key) (key.Key, bool) {
+		return stringkey.New(k.(*stringkey.StringKey).Str()[:1]), true
+	}
+	var want = make(map[string]interface{})
+	for _, kv := range h.KeyVals() {
+		want[kv.Key.(*stringkey.StringKey).Str()[:1]] = kv.Val
+	}
+	rh = h.Rekey(first)
+	if rh.Nentries() != 3 {
+		t.Fatalf("rh.Nentries()=%d; expected 3", rh.Nentries())
+	}
+	for s, val := range want {
+		if v, found := rh.Get(stringkey.New(s)); !found || v != val {
+			t.Fatalf("rh.Get(%s) => %v, %t; expected %v", s, v, found, val)
+		}
+	}
+
+	if rh = (hamt32.Hamt{}).Rekey(first); !rh.IsEmpty() {
+		t.Fatalf("empty Hamt Rekey() => %s", rh)
+	}
+}
+
+func TestFlattenEntries32(t *testing.T) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:10*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(3) {
+		h, _ = h.Put(k, i)
+	}
+
+	var kvs, err = h.FlattenEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint(len(kvs)) != h.Nentries() || uint(cap(kvs)) != h.Nentries() {
+		t.Fatalf("len(kvs),%d cap(kvs),%d != Nentries(),%d", len(kvs), cap(kvs), h.Nentries())
+	}
+	if !hamt32.BuildFromSorted(kvs).Equals(h) {
+		t.Fatal("!BuildFromSorted(h.FlattenEntries()).Equals(h)")
+	}
+
+	if kvs, err := (hamt32.Hamt{}).FlattenEntries(); err != nil || len(kvs) != 0 {
+		t.Fatalf("empty.FlattenEntries() => %d key/val pairs, %v", len(kvs), err)
+	}
+
+	var folded, _ = hamt32.NewFolded(hamt32.FoldMix).Put(KVS[0].Key, KVS[0].Val)
+	if _, err := folded.FlattenEntries(); err == nil {
+		t.Fatal("folded.FlattenEntries() returned no error")
+	}
+}
+
+func TestBuildWithProgress32(t *testing.T) {
+	for _, test := range []struct {
+		num, every int
+		counts     []uint
+	}{
+		{10000, 1000, []uint{1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000}},
+		{2500, 1000, []uint{1000, 2000}},
+		{999, 1000, nil},
+		{100, 0, nil},
+	} {
+		var counts []uint
+		var h = hamt32.BuildWithProgress(KVS[:test.num], uint(test.every), func(count uint) {
+			counts = append(counts, count)
+		})
+		if h.Nentries() != uint(test.num) {
+			t.Fatalf("BuildWithProgress(%d kvs).Nentries(),%d != %d", test.num, h.Nentries(), test.num)
+		}
+		if fmt.Sprint(counts) != fmt.Sprint(test.counts) {
+			t.Fatalf("BuildWithProgress(%d kvs, every %d) called progress with %v; want %v",
+				test.num, test.every, counts, test.counts)
+		}
+	}
+}
+
+func TestDecodeHashPath30(t *testing.T) {
+	// the "ewyx" example of the package documentation
+	var h30 = stringkey.New("ewyx").Hash30()
+	if h30 != 0x11a01c5e {
+		t.Fatalf("stringkey.New(\"ewyx\").Hash30(),%#x != 0x11a01c5e", uint32(h30))
+	}
+
+	var want = [hamt32.MaxDepth + 1]uint{30, 2, 7, 0, 26, 8}
+	if idxs := hamt32.DecodeHashPath30(uint32(h30)); idxs != want {
+		t.Fatalf("DecodeHashPath30(%#x) => %v; want %v", uint32(h30), idxs, want)
+	}
+	if idxs := hamt32.DecodeHashPath30(uint32(h30) | 3<<30); idxs != want {
+		t.Fatalf("DecodeHashPath30() used the bits above 30; => %v; want %v", idxs, want)
+	}
+}
+
+func TestMayContain32(t *testing.T) {
+	if found, known := (hamt32.Hamt{}).MayContain(stringkey.New("a"), 0); found || !known {
+		t.Fatalf("empty Hamt MayContain() => %t, %t", found, known)
+	}
+
+	// a leaf at the root; a table at depth 1 for b and c; and a run of
+	// tables down to depth 5 for x and y
+	var a = hashedKey32{stringkey.New("a"), 0}
+	var b = hashedKey32{stringkey.New("b"), 1 | 1<<hamt32.Nbits}
+	var c = hashedKey32{stringkey.New("c"), 1 | 2<<hamt32.Nbits}
+	var x = hashedKey32{stringkey.New("x"), chainPath32(4, 0)}
+	var y = hashedKey32{stringkey.New("y"), chainPath32(4, 1)}
+
+	var h = hamt32.NewSized(100)
+	for i, k := range []key.Key{a, b, c, x, y} {
+		h, _ = h.Put(k, i)
+	}
+
+	var tests = []struct {
+		k            key.Key
+		maxDepth     uint
+		found, known bool
+	}{
+		{a, 0, true, true},
+		{hashedKey32{stringkey.New("A"), 0}, 0, false, true}, // a leaf without k
+		{hashedKey32{stringkey.New("d"), 2}, 0, false, true}, // an empty entry
+		{b, 0, false, false},
+		{b, 1, true, true},
+		{hashedKey32{stringkey.New("e"), 1 | 3<<hamt32.Nbits}, 0, false, false},
+		{hashedKey32{stringkey.New("e"), 1 | 3<<hamt32.Nbits}, 1, false, true},
+		{x, 4, false, false},
+		{x, 5, true, true},
+		{y, hamt32.MaxDepth, true, true},
+		{hashedKey32{stringkey.New("z"), chainPath32(4, 2)}, 5, false, true},
+	}
+
+	for _, nh := range []hamt32.Hamt{h, h.Compact()} {
+		for _, test := range tests {
+			var found, known = nh.MayContain(test.k, test.maxDepth)
+			if found != test.found || known != test.known {
+				t.Fatalf("MayContain(%s, %d) => %t, %t; expected %t, %t",
+					test.k, test.maxDepth, found, known, test.found, test.known)
+			}
+		}
+	}
+
+	// a linear Hamt is searched whole
+	var lh, _ = hamt32.Hamt{}.Put(b, 1)
+	if found, known := lh.MayContain(b, 0); !found || !known {
+		t.Fatalf("small Hamt MayContain() => %t, %t", found, known)
+	}
+}
+
+func TestVerifyAll32(t *testing.T) {
+	var valEq = func(a, b interface{}) bool { return a == b }
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	if ok, k := h.VerifyAll(KVS[:1024], valEq); !ok {
+		t.Fatalf("h.VerifyAll(KVS[:1024]) failed at %s", k)
+	}
+	if ok, k := h.VerifyAll(nil, valEq); !ok || k != nil {
+		t.Fatalf("h.VerifyAll(nil) => %t, %v; want true, nil", ok, k)
+	}
+
+	// a missing key
+	var kvs = append([]key.KeyVal(nil), KVS[:1024]...)
+	kvs = append(kvs, KVS[1024])
+	if ok, k := h.VerifyAll(kvs, valEq); ok || !k.Equals(KVS[1024].Key) {
+		t.Fatalf("h.VerifyAll() => %t, %v; want false, %s", ok, k, KVS[1024].Key)
+	}
+
+	// a different value
+	kvs = append([]key.KeyVal(nil), KVS[:1024]...)
+	kvs[512].Val = -1
+	if ok, k := h.VerifyAll(kvs, valEq); ok || !k.Equals(KVS[512].Key) {
+		t.Fatalf("h.VerifyAll() => %t, %v; want false, %s", ok, k, KVS[512].Key)
+	}
+}
+
+func TestLinearThreshold32(t *testing.T) {
+	var saveThreshold = hamt32.LinearThreshold
+	defer func() { hamt32.LinearThreshold = saveThreshold }()
+
+	for n := 0; n <= 20; n++ {
+		hamt32.LinearThreshold = 0
+		var trie hamt32.Hamt
+		for _, kv := range KVS[:n] {
+			trie, _ = trie.Put(kv.Key, kv.Val)
+		}
+
+		hamt32.LinearThreshold = 8
+		var h hamt32.Hamt
+		for i, kv := range KVS[:n] {
+			var added bool
+			h, added = h.Put(kv.Key, kv.Val)
+			if !added {
+				t.Fatalf("n=%d: h.Put(%s) not added", n, kv.Key)
+			}
+			var typ = rootTableType32(h)
+			if i < 8 && typ != "linearTable" {
+				t.Fatalf("n=%d: root %s != linearTable with %d entries", n, typ, i+1)
+			}
+			if i >= 8 && typ == "linearTable" {
+				t.Fatalf("n=%d: root is a linearTable with %d entries", n, i+1)
+			}
+		}
+		if h.Nentries() != uint(n) {
+			t.Fatalf("n=%d: h.Nentries(),%d != %d", n, h.Nentries(), n)
+		}
+
+		for _, kv := range KVS[:n] {
+			if val, found := h.Get(kv.Key); !found || val != kv.Val {
+				t.Fatalf("n=%d: h.Get(%s) => %v, %t; want %v, true", n, kv.Key, val, found, kv.Val)
+			}
+			var s = kv.Key.(*stringkey.StringKey).Str()
+			if val, found := h.GetStr(s); !found || val != kv.Val {
+				t.Fatalf("n=%d: h.GetStr(%q) => %v, %t; want %v, true", n, s, val, found, kv.Val)
+			}
+		}
+		if _, found := h.Get(KVS[n].Key); found {
+			t.Fatalf("n=%d: h.Get(%s) found", n, KVS[n].Key)
+		}
+		if !h.Equals(trie) || !trie.Equals(h) {
+			t.Fatalf("n=%d: h and the Trie of the same keys are not Equal", n)
+		}
+
+		// replacing a value does not add an entry
+		if nh, added := h.Put(KVS[0].Key, -1); n > 0 && (added || nh.Nentries() != uint(n)) {
+			t.Fatalf("n=%d: h.Put(%s, -1) added=%t, Nentries()=%d", n, KVS[0].Key, added, nh.Nentries())
+		}
+
+		for i, kv := range KVS[:n] {
+			var v interface{}
+			var deleted bool
+			h, v, deleted = h.Del(kv.Key)
+			if !deleted || v != kv.Val {
+				t.Fatalf("n=%d: h.Del(%s) => %v, %t; want %v, true", n, kv.Key, v, deleted, kv.Val)
+			}
+			if _, found := h.Get(kv.Key); found {
+				t.Fatalf("n=%d: h.Get(%s) found after h.Del()", n, kv.Key)
+			}
+			if h.Nentries() != uint(n-i-1) {
+				t.Fatalf("n=%d: h.Nentries(),%d != %d", n, h.Nentries(), n-i-1)
+			}
+		}
+		if !h.IsEmpty() {
+			t.Fatalf("n=%d: h not empty after deleting every key", n)
+		}
+	}
+}
+
+func TestLinearThresholdCollisions32(t *testing.T) {
+	var saveThreshold = hamt32.LinearThreshold
+	hamt32.LinearThreshold = 8
+	defer func() { hamt32.LinearThreshold = saveThreshold }()
+
+	var keys = buildCollidingKeys(3)
+
+	var h hamt32.Hamt
+	for _, kv := range KVS[:4] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+	if typ := rootTableType32(h); typ != "linearTable" {
+		t.Fatalf("root %s != linearTable", typ)
+	}
+	for i, k := range keys {
+		if val, found := h.Get(k); !found || val != i {
+			t.Fatalf("h.Get(%s) => %v, %t; want %d, true", k, val, found, i)
+		}
+	}
+
+	// grow past LinearThreshold with the collision leaf in place
+	for _, kv := range KVS[4:16] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if typ := rootTableType32(h); typ == "linearTable" {
+		t.Fatalf("root is a linearTable with %d entries", h.Nentries())
+	}
+	for i, k := range keys {
+		if val, found := h.Get(k); !found || val != i {
+			t.Fatalf("h.Get(%s) => %v, %t; want %d, true", k, val, found, i)
+		}
+	}
+	if ok, k := h.VerifyAll(KVS[:16], func(a, b interface{}) bool { return a == b }); !ok {
+		t.Fatalf("h.VerifyAll(KVS[:16]) failed at %s", k)
+	}
+}
+
+func TestSymmetricDifference32(t *testing.T) {
+	var build = func(kvs []key.KeyVal) hamt32.Hamt {
+		var h hamt32.Hamt
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		return h
+	}
+	var check = func(name string, sd hamt32.Hamt, want []key.KeyVal) {
+		if sd.Nentries() != uint(len(want)) {
+			t.Fatalf("%s: Nentries(),%d != %d", name, sd.Nentries(), len(want))
+		}
+		if ok, k := sd.VerifyAll(want, func(a, b interface{}) bool { return a == b }); !ok {
+			t.Fatalf("%s: missing or wrong value for %s", name, k)
+		}
+	}
+
+	// disjoint
+	var a, b = build(KVS[:1000]), build(KVS[1000:2000])
+	check("disjoint", a.SymmetricDifference(b), KVS[:2000])
+	check("disjoint reversed", b.SymmetricDifference(a), KVS[:2000])
+	check("empty other", a.SymmetricDifference(hamt32.Hamt{}), KVS[:1000])
+	check("empty h", hamt32.Hamt{}.SymmetricDifference(b), KVS[1000:2000])
+
+	// overlapping
+	a, b = build(KVS[:1500]), build(KVS[1000:2500])
+	var want = append(append([]key.KeyVal(nil), KVS[:1000]...), KVS[1500:2500]...)
+	check("overlapping", a.SymmetricDifference(b), want)
+	check("overlapping reversed", b.SymmetricDifference(a), want)
+
+	// identical
+	check("identical", a.SymmetricDifference(a), nil)
+	check("equal", a.SymmetricDifference(build(KVS[:1500])), nil)
+
+	// versions sharing most of their subtrees
+	var c = a
+	c, _, _ = c.Del(KVS[0].Key)
+	c, _ = c.Put(KVS[2000].Key, KVS[2000].Val)
+	check("versions", a.SymmetricDifference(c), []key.KeyVal{KVS[0], KVS[2000]})
+
+	// collisions
+	var keys = buildCollidingKeys(3)
+	a, b = build(KVS[:100]), build(KVS[:100])
+	a, _ = a.Put(keys[0], 0)
+	a, _ = a.Put(keys[1], 1)
+	b, _ = b.Put(keys[1], 1)
+	b, _ = b.Put(keys[2], 2)
+	check("collisions", a.SymmetricDifference(b),
+		[]key.KeyVal{{Key: keys[0], Val: 0}, {Key: keys[2], Val: 2}})
+}
+
+func TestRangeFrom32(t *testing.T) {
+	var kvs = KVS[:10*1024+17]
+
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for i, k := range buildCollidingKeys(5) {
+		h, _ = h.Put(k, i)
+	}
+
+	const pageSize = 100
+
+	var seen = make(map[key.Key]int)
+	var order []key.Key
+	var cursor []uint
+	var pages int
+	for {
+		var n int
+		cursor = h.RangeFrom(cursor, func(k key.Key, v interface{}) bool {
+			seen[k]++
+			order = append(order, k)
+			n++
+			return n < pageSize
+		})
+		pages++
+		if cursor == nil {
+			break
+		}
+		if n != pageSize {
+			t.Fatalf("page %d has %d key/val pairs; want %d", pages, n, pageSize)
+		}
+	}
+
+	if len(order) != int(h.Nentries()) {
+		t.Fatalf("visited %d key/val pairs; want %d", len(order), h.Nentries())
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("visited %s %d times", k, n)
+		}
+	}
+	var wantPages = (int(h.Nentries()) + pageSize - 1) / pageSize
+	if pages != wantPages {
+		t.Fatalf("%d pages; want %d", pages, wantPages)
+	}
+
+	// same order as KeyVals()
+	for i, kv := range h.KeyVals() {
+		if !kv.Key.Equals(order[i]) {
+			t.Fatalf("key %d: %s != %s", i, order[i], kv.Key)
+		}
+	}
+
+	// a cursor stays valid for the same Hamt, even once h has changed
+	var cursor2 = h.RangeFrom(nil, func(k key.Key, v interface{}) bool { return false })
+	var h2, _ = h.Put(KVS[len(kvs)].Key, KVS[len(kvs)].Val)
+	var first key.Key
+	h.RangeFrom(cursor2, func(k key.Key, v interface{}) bool {
+		first = k
+		return false
+	})
+	if !first.Equals(order[1]) {
+		t.Fatalf("resumed at %s; want %s", first, order[1])
+	}
+	if h2.Nentries() != h.Nentries()+1 {
+		t.Fatal("h2.Put() changed h")
+	}
+
+	var empty hamt32.Hamt
+	if c := empty.RangeFrom(nil, func(k key.Key, v interface{}) bool { return true }); c != nil {
+		t.Fatalf("empty.RangeFrom() => %v; want nil", c)
+	}
+}
+
+func TestTableComposition32(t *testing.T) {
+	defer setLibrary(TYP)
+
+	var kvs = KVS[:64*1024]
+	for _, typ := range []int{hybrid, fullonly, componly} {
+		setLibrary(typ)
+
+		var h hamt32.Hamt
+		if full, comp := h.TableComposition(); full != 0 || comp != 0 {
+			t.Fatalf("%s: empty TableComposition() => %d, %d", cfgStr[typ], full, comp)
+		}
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+
+		var full, comp = h.TableComposition()
+		if full+comp != h.NumTables() {
+			t.Fatalf("%s: full,%d + compressed,%d != NumTables(),%d", cfgStr[typ], full, comp, h.NumTables())
+		}
+		switch typ {
+		case hybrid:
+			if full == 0 || comp == 0 {
+				t.Fatalf("hybrid: full=%d, compressed=%d; want both", full, comp)
+			}
+		case fullonly:
+			if comp != 0 {
+				t.Fatalf("fullonly: compressed=%d; want 0", comp)
+			}
+		case componly:
+			if full != 0 {
+				t.Fatalf("componly: full=%d; want 0", full)
+			}
+		}
+	}
+}
+
+func TestPutMerge32(t *testing.T) {
+	var sum = func(old, new interface{}) interface{} { return old.(int) + new.(int) }
+
+	var kvs = KVS[:4096]
+
+	// insert
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		var added bool
+		h, added = h.PutMerge(kv.Key, kv.Val, func(old, new interface{}) interface{} {
+			t.Fatalf("merge called for absent key %s", kv.Key)
+			return nil
+		})
+		if !added {
+			t.Fatalf("h.PutMerge(%s) not added", kv.Key)
+		}
+	}
+
+	// merge existing
+	for _, kv := range kvs {
+		var added bool
+		h, added = h.PutMerge(kv.Key, 1, sum)
+		if added {
+			t.Fatalf("h.PutMerge(%s) added an existing key", kv.Key)
+		}
+	}
+	if h.Nentries() != uint(len(kvs)) {
+		t.Fatalf("h.Nentries(),%d != %d", h.Nentries(), len(kvs))
+	}
+	for _, kv := range kvs {
+		if val, _ := h.Get(kv.Key); val != kv.Val.(int)+1 {
+			t.Fatalf("h.Get(%s),%v != %d", kv.Key, val, kv.Val.(int)+1)
+		}
+	}
+
+	// merge within a collision leaf, and within a small (linear) Hamt
+	var keys = buildCollidingKeys(3)
+	var small hamt32.Hamt
+	for i := 0; i < 3; i++ {
+		for _, k := range keys {
+			small, _ = small.PutMerge(k, 1, sum)
+		}
+		h, _ = h.PutMerge(keys[i], 1, sum)
+		h, _ = h.PutMerge(keys[i], 1, sum)
+	}
+	for _, k := range keys {
+		if val, _ := small.Get(k); val != 3 {
+			t.Fatalf("small.Get(%s),%v != 3", k, val)
+		}
+		if val, _ := h.Get(k); val != 2 {
+			t.Fatalf("h.Get(%s),%v != 2", k, val)
+		}
+	}
+}
+
+func TestOnGrade32(t *testing.T) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	type event struct {
+		depth    uint
+		from, to string
+	}
+	var events []event
+	var h = hamt32.Hamt{}.OnGrade(func(depth uint, from, to string) {
+		events = append(events, event{depth, from, to})
+	})
+
+	var kvs = KVS[:32*1024]
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var rootUp bool
+	for _, e := range events {
+		if e.from != "compressed" || e.to != "full" {
+			t.Fatalf("Put: OnGrade(%d, %q, %q); want \"compressed\", \"full\"", e.depth, e.from, e.to)
+		}
+		if e.depth == 0 {
+			rootUp = true
+		}
+	}
+	if !rootUp {
+		t.Fatal("no upgrade of the root table reported")
+	}
+	if rootTableType32(h) != "fullTable" {
+		t.Fatalf("root %s != fullTable", rootTableType32(h))
+	}
+
+	// replacing values changes no table types
+	events = nil
+	for _, kv := range kvs[:1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	if len(events) != 0 {
+		t.Fatalf("replacing values reported %d table type changes", len(events))
+	}
+
+	var rootDown bool
+	for _, kv := range kvs {
+		h, _, _ = h.Del(kv.Key)
+	}
+	for _, e := range events {
+		if e.from != "full" || e.to != "compressed" {
+			t.Fatalf("Del: OnGrade(%d, %q, %q); want \"full\", \"compressed\"", e.depth, e.from, e.to)
+		}
+		if e.depth == 0 {
+			rootDown = true
+		}
+	}
+	if !rootDown {
+		t.Fatal("no downgrade of the root table reported")
+	}
+
+	// a Hamt without the hook, or with it removed, reports nothing
+	events = nil
+	var h2 = h.OnGrade(nil)
+	for _, kv := range kvs[:4096] {
+		h2, _ = h2.Put(kv.Key, kv.Val)
+	}
+	if len(events) != 0 {
+		t.Fatalf("OnGrade(nil) reported %d table type changes", len(events))
+	}
+}
+
+func TestAudit32(t *testing.T) {
+	// diff() is the full, brute force, diff of cur from prev.
+	var diff = func(cur, prev hamt32.Hamt) (added, removed, changed []key.Key) {
+		for _, kv := range cur.KeyVals() {
+			if val, found := prev.Get(kv.Key); !found {
+				added = append(added, kv.Key)
+			} else if val != kv.Val {
+				changed = append(changed, kv.Key)
+			}
+		}
+		for _, kv := range prev.KeyVals() {
+			if _, found := cur.Get(kv.Key); !found {
+				removed = append(removed, kv.Key)
+			}
+		}
+		return
+	}
+	var check = func(name string, cur, prev hamt32.Hamt) {
+		var added, removed, changed = cur.Audit(prev)
+		var dAdded, dRemoved, dChanged = diff(cur, prev)
+		if added != uint(len(dAdded)) || removed != uint(len(dRemoved)) || changed != uint(len(dChanged)) {
+			t.Fatalf("%s: Audit() => %d, %d, %d; want %d, %d, %d", name,
+				added, removed, changed, len(dAdded), len(dRemoved), len(dChanged))
+		}
+		var kvsAdded, kvsRemoved, kvsChanged = cur.Diff(prev)
+		if added != uint(len(kvsAdded)) || removed != uint(len(kvsRemoved)) || changed != uint(len(kvsChanged)) {
+			t.Fatalf("%s: Audit() => %d, %d, %d; Diff() lengths %d, %d, %d", name,
+				added, removed, changed, len(kvsAdded), len(kvsRemoved), len(kvsChanged))
+		}
+	}
+
+	var prev hamt32.Hamt
+	for _, kv := range KVS[:8192] {
+		prev, _ = prev.Put(kv.Key, kv.Val)
+	}
+
+	var cur = prev
+	for _, kv := range KVS[:100] {
+		cur, _, _ = cur.Del(kv.Key)
+	}
+	for _, kv := range KVS[100:150] {
+		cur, _ = cur.Put(kv.Key, -1)
+	}
+	for _, kv := range KVS[8192:8492] {
+		cur, _ = cur.Put(kv.Key, kv.Val)
+	}
+	var keys = buildCollidingKeys(3)
+	prev, _ = prev.Put(keys[0], 0)
+	prev, _ = prev.Put(keys[1], 1)
+	cur, _ = cur.Put(keys[1], -1)
+	cur, _ = cur.Put(keys[2], 2)
+
+	if added, removed, changed := cur.Audit(prev); added != 301 || removed != 101 || changed != 51 {
+		t.Fatalf("cur.Audit(prev) => %d, %d, %d; want 301, 101, 51", added, removed, changed)
+	}
+	check("cur, prev", cur, prev)
+	check("prev, cur", prev, cur)
+	check("identical", cur, cur)
+	check("empty prev", cur, hamt32.Hamt{})
+	check("empty cur", hamt32.Hamt{}, prev)
+
+	// separately built, so no subtrees are shared
+	var rebuilt hamt32.Hamt
+	for _, kv := range prev.KeyVals() {
+		rebuilt, _ = rebuilt.Put(hamt32.UnfoldKey(kv.Key), kv.Val)
+	}
+	check("rebuilt", cur, rebuilt)
+}
+
+func TestSkipEqualPuts32(t *testing.T) {
+	var eq = func(a, b interface{}) bool { return a == b }
+
+	var plain hamt32.Hamt
+	for _, kv := range KVS[:4096] {
+		plain, _ = plain.Put(kv.Key, kv.Val)
+	}
+	var h = plain.SkipEqualPuts(eq)
+
+	for _, kv := range KVS[:4096] {
+		var nh, added = h.Put(kv.Key, kv.Val)
+		if added || !hamt32.ShareRoot(h, nh) {
+			t.Fatalf("idempotent h.Put(%s) => added=%t, ShareRoot=%t; want false, true",
+				kv.Key, added, hamt32.ShareRoot(h, nh))
+		}
+	}
+
+	// without the comparator an idempotent Put copies the path
+	if nh, _ := plain.Put(KVS[0].Key, KVS[0].Val); hamt32.ShareRoot(plain, nh) {
+		t.Fatal("plain.Put() of an equal val shares the root")
+	}
+	if nh, _ := h.SkipEqualPuts(nil).Put(KVS[0].Key, KVS[0].Val); hamt32.ShareRoot(h, nh) {
+		t.Fatal("SkipEqualPuts(nil).Put() of an equal val shares the root")
+	}
+
+	// a different val is stored
+	var nh, _ = h.Put(KVS[0].Key, -1)
+	if hamt32.ShareRoot(h, nh) {
+		t.Fatal("h.Put() of a different val shares the root")
+	}
+	if val, _ := nh.Get(KVS[0].Key); val != -1 {
+		t.Fatalf("nh.Get(%s),%v != -1", KVS[0].Key, val)
+	}
+
+	// derived Hamts, small Hamts, and collision leafs
+	var keys = buildCollidingKeys(3)
+	var small = hamt32.Hamt{}.SkipEqualPuts(eq)
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+		small, _ = small.Put(k, i)
+	}
+	for i, k := range keys {
+		if nh, added := h.Put(k, i); added || !hamt32.ShareRoot(h, nh) {
+			t.Fatalf("idempotent h.Put(%s) does not share the root", k)
+		}
+		if nh, added := small.Put(k, i); added || !hamt32.ShareRoot(small, nh) {
+			t.Fatalf("idempotent small.Put(%s) does not share the root", k)
+		}
+	}
+}
+
 func BenchmarkHamt32Get(b *testing.B) {
 	var name = fmt.Sprintf("BenchmarkHamt32Get#%d", b.N)
 	log.Printf("BenchmarkHamt32Get: b.N=%d", b.N)
@@ -157,79 +4012,611 @@ func BenchmarkHamt32Get(b *testing.B) {
 	//var kvs = buildKeyVals(name, b.N, "aaa", 0)
 	var lookupHamt32 = createHamt32(name, KVS, TYP)
 
-	//kvs = genRandomizedKvs(kvs)
+	//kvs = genRandomizedKvs(kvs)
+	b.ResetTimer()
+
+	//for _, kv := range kvs {
+	//	var key = kv.Key
+	//	var val = kv.Val
+	for i := 0; i < b.N; i++ {
+		var j = i % numKvs
+		var key = KVS[j].Key
+		var val = KVS[j].Val
+		var v, found = lookupHamt32.Get(key)
+		if !found {
+			b.Fatalf("H.Get(%s) not found", key)
+		}
+		if v != val {
+			b.Fatalf("v,%v != val,%v for key=%s", v, val, key)
+		}
+	}
+}
+
+func BenchmarkHamt32Put(b *testing.B) {
+	var name = fmt.Sprintf("BenchmarkHamt32Put#%d", b.N)
+	log.Printf("BenchmarkHamt32Put: b.N=%d", b.N)
+
+	var kvs = buildKeyVals(name, b.N, "aaa", 0)
+
+	b.ResetTimer()
+
+	var h = hamt32.Hamt{}
+	for i := 0; i < b.N; i++ {
+		key := kvs[i].Key
+		val := kvs[i].Val
+
+		var added bool
+		h, added = h.Put(key, val)
+		if !added {
+			b.Fatalf("failed to h.Put(%s, %v)", key, val)
+		}
+	}
+}
+
+func BenchmarkHamt32Del(b *testing.B) {
+	var name = fmt.Sprintf("BenchmarkHamt32Del#%d", b.N)
+	log.Printf("BenchmarkHamt32Del: b.N=%d", b.N)
+
+	var kvs = buildKeyVals(name, b.N+1, "aaa", 0)
+	var deleteHamt32 = createHamt32(name, kvs, TYP)
+
+	b.ResetTimer()
+
+	StartTime["run BenchmarkHamt32Del"] = time.Now()
+
+	var h = deleteHamt32
+
+	for i := 0; i < b.N; i++ {
+		kv := kvs[i]
+		key := kv.Key
+		val := kv.Val
+
+		var v interface{}
+		var deleted bool
+		h, v, deleted = h.Del(key)
+		if !deleted {
+			b.Fatalf("failed to find and delet key=%s", key)
+		}
+		if v != val {
+			b.Fatalf("deleted key=%s but the value found was wrong v=%d, expected val=%d", key, v, val)
+		}
+	}
+
+	if h.IsEmpty() {
+		b.Fatal("h.IsEmpty() => true; hence this wasn't a valid benchmark")
+	}
+
+	RunTime["run BenchmarkHamt32Del"] = time.Since(StartTime["run BenchmarkHamt32Del"])
+}
+
+func BenchmarkHamt32GetStringKeyNew(b *testing.B) {
+	var name = fmt.Sprintf("BenchmarkHamt32GetStringKeyNew#%d", b.N)
+	log.Printf("BenchmarkHamt32GetStringKeyNew: b.N=%d", b.N)
+
+	var lookupHamt32 = createHamt32(name, KVS, TYP)
+
+	var strs = make([]string, len(KVS))
+	for i, kv := range KVS {
+		strs[i] = kv.Key.(*stringkey.StringKey).Str()
+	}
+
+	b.ReportAllocs()
 	b.ResetTimer()
 
-	//for _, kv := range kvs {
-	//	var key = kv.Key
-	//	var val = kv.Val
 	for i := 0; i < b.N; i++ {
 		var j = i % numKvs
-		var key = KVS[j].Key
-		var val = KVS[j].Val
-		var v, found = lookupHamt32.Get(key)
+		var _, found = lookupHamt32.Get(stringkey.New(strs[j]))
 		if !found {
-			b.Fatalf("H.Get(%s) not found", key)
+			b.Fatalf("H.Get(stringkey.New(%q)) not found", strs[j])
 		}
-		if v != val {
-			b.Fatalf("v,%v != val,%v for key=%s", v, val, key)
+	}
+}
+
+func BenchmarkHamt32GetStr(b *testing.B) {
+	var name = fmt.Sprintf("BenchmarkHamt32GetStr#%d", b.N)
+	log.Printf("BenchmarkHamt32GetStr: b.N=%d", b.N)
+
+	var lookupHamt32 = createHamt32(name, KVS, TYP)
+
+	var strs = make([]string, len(KVS))
+	for i, kv := range KVS {
+		strs[i] = kv.Key.(*stringkey.StringKey).Str()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var j = i % numKvs
+		var _, found = lookupHamt32.GetStr(strs[j])
+		if !found {
+			b.Fatalf("H.GetStr(%q) not found", strs[j])
 		}
 	}
 }
 
-func BenchmarkHamt32Put(b *testing.B) {
-	var name = fmt.Sprintf("BenchmarkHamt32Put#%d", b.N)
-	log.Printf("BenchmarkHamt32Put: b.N=%d", b.N)
+func benchmarkHamt32PutArena(b *testing.B, useArena bool) {
+	var name = fmt.Sprintf("benchmarkHamt32PutArena(%t)#%d", useArena, b.N)
+	log.Printf("benchmarkHamt32PutArena(%t): b.N=%d", useArena, b.N)
 
 	var kvs = buildKeyVals(name, b.N, "aaa", 0)
 
+	var saveUseArena = hamt32.UseArena
+	hamt32.UseArena = useArena
+	defer func() { hamt32.UseArena = saveUseArena }()
+
+	runtime.GC()
+	var ms0, ms1 runtime.MemStats
+	runtime.ReadMemStats(&ms0)
+
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	var h = hamt32.Hamt{}
 	for i := 0; i < b.N; i++ {
-		key := kvs[i].Key
-		val := kvs[i].Val
+		var added bool
+		h, added = h.Put(kvs[i].Key, kvs[i].Val)
+		if !added {
+			b.Fatalf("failed to h.Put(%s, %v)", kvs[i].Key, kvs[i].Val)
+		}
+	}
+
+	b.StopTimer()
+	runtime.ReadMemStats(&ms1)
+	b.ReportMetric(float64(ms1.PauseTotalNs-ms0.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+	b.ReportMetric(float64(ms1.NumGC-ms0.NumGC), "gc-cycles")
+}
+
+func BenchmarkHamt32PutNoArena(b *testing.B) {
+	benchmarkHamt32PutArena(b, false)
+}
+
+func BenchmarkHamt32PutArena(b *testing.B) {
+	benchmarkHamt32PutArena(b, true)
+}
+
+const collidingBucketSize = 1024
+
+func benchmarkHamt32CollisionGet(b *testing.B, threshold uint) {
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = threshold
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	var keys = buildCollidingKeys(collidingBucketSize)
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var k = keys[i%collidingBucketSize]
+		if _, found := h.Get(k); !found {
+			b.Fatalf("h.Get(%s) not found", k)
+		}
+	}
+}
+
+func benchmarkHamt32CollisionPut(b *testing.B, threshold uint) {
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = threshold
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	var keys = buildCollidingKeys(2 * collidingBucketSize)
+	var h hamt32.Hamt
+	for i, k := range keys[:collidingBucketSize] {
+		h, _ = h.Put(k, i)
+	}
+	var newKeys = keys[collidingBucketSize:]
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var k = newKeys[i%collidingBucketSize]
+		if _, added := h.Put(k, i); !added {
+			b.Fatalf("h.Put(%s) not added", k)
+		}
+	}
+}
+
+func benchmarkHamt32CollisionDel(b *testing.B, threshold uint) {
+	var saveThreshold = hamt32.CollisionOverflowThreshold
+	hamt32.CollisionOverflowThreshold = threshold
+	defer func() { hamt32.CollisionOverflowThreshold = saveThreshold }()
+
+	var keys = buildCollidingKeys(collidingBucketSize)
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var k = keys[i%collidingBucketSize]
+		if _, _, deleted := h.Del(k); !deleted {
+			b.Fatalf("h.Del(%s) not deleted", k)
+		}
+	}
+}
+
+func BenchmarkHamt32CollisionLeafGet(b *testing.B) { benchmarkHamt32CollisionGet(b, 0) }
+func BenchmarkHamt32CollisionLeafPut(b *testing.B) { benchmarkHamt32CollisionPut(b, 0) }
+func BenchmarkHamt32CollisionLeafDel(b *testing.B) { benchmarkHamt32CollisionDel(b, 0) }
+func BenchmarkHamt32OverflowLeafGet(b *testing.B)  { benchmarkHamt32CollisionGet(b, 8) }
+func BenchmarkHamt32OverflowLeafPut(b *testing.B)  { benchmarkHamt32CollisionPut(b, 8) }
+func BenchmarkHamt32OverflowLeafDel(b *testing.B)  { benchmarkHamt32CollisionDel(b, 8) }
+
+// BenchmarkHamt32PutHybrid is BenchmarkHamt32Put with tables upgraded and
+// downgraded as they grow, so it measures the cost of grade transitions.
+func BenchmarkHamt32PutHybrid(b *testing.B) {
+	var name = fmt.Sprintf("BenchmarkHamt32PutHybrid#%d", b.N)
+	log.Printf("BenchmarkHamt32PutHybrid: b.N=%d", b.N)
+
+	var kvs = buildKeyVals(name, b.N, "aaa", 0)
 
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var h = hamt32.Hamt{}
+	for i := 0; i < b.N; i++ {
 		var added bool
-		h, added = h.Put(key, val)
+		h, added = h.Put(kvs[i].Key, kvs[i].Val)
 		if !added {
-			b.Fatalf("failed to h.Put(%s, %v)", key, val)
+			b.Fatalf("failed to h.Put(%s, %v)", kvs[i].Key, kvs[i].Val)
 		}
 	}
 }
 
-func BenchmarkHamt32Del(b *testing.B) {
-	var name = fmt.Sprintf("BenchmarkHamt32Del#%d", b.N)
-	log.Printf("BenchmarkHamt32Del: b.N=%d", b.N)
+// indexSink32 keeps the compiler from optimizing away BenchmarkIndex32 work.
+var indexSink32 uint
 
-	var kvs = buildKeyVals(name, b.N+1, "aaa", 0)
-	var deleteHamt32 = createHamt32(name, kvs, TYP)
+// BenchmarkIndex32 measures extracting every depth's index from a HashVal30;
+// the hash path step of every Get, Put, and Del.
+func BenchmarkIndex32(b *testing.B) {
+	var hashes = make([]key.HashVal30, 1024)
+	for i := range hashes {
+		hashes[i] = KVS[i].Key.Hash30()
+	}
 
 	b.ResetTimer()
 
-	StartTime["run BenchmarkHamt32Del"] = time.Now()
+	var sum uint
+	for i := 0; i < b.N; i++ {
+		var h30 = hashes[i%len(hashes)]
+		for depth := uint(0); depth <= hamt32.MaxDepth; depth++ {
+			sum += h30.Index(depth)
+		}
+	}
+	indexSink32 = sum
+}
 
-	var h = deleteHamt32
+// benchmarkHamt32LeafMemory reports the heap bytes per entry of a Hamt with
+// every val set to nil (keyLeafs) or to an int (flatLeafs).
+func benchmarkHamt32LeafMemory(b *testing.B, nilVals bool) {
+	var kvs = KVS[:1024*1024]
+
+	b.ResetTimer()
 
+	var bytesPerEntry float64
 	for i := 0; i < b.N; i++ {
-		kv := kvs[i]
-		key := kv.Key
-		val := kv.Val
+		var ms0, ms1 runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms0)
 
-		var v interface{}
-		var deleted bool
-		h, v, deleted = h.Del(key)
-		if !deleted {
-			b.Fatalf("failed to find and delet key=%s", key)
+		var h hamt32.Hamt
+		for _, kv := range kvs {
+			if nilVals {
+				h, _ = h.Put(kv.Key, nil)
+			} else {
+				h, _ = h.Put(kv.Key, kv.Val)
+			}
 		}
-		if v != val {
-			b.Fatalf("deleted key=%s but the value found was wrong v=%d, expected val=%d", key, v, val)
+
+		runtime.GC()
+		runtime.ReadMemStats(&ms1)
+		bytesPerEntry = float64(int64(ms1.HeapAlloc)-int64(ms0.HeapAlloc)) / float64(h.Nentries())
+		runtime.KeepAlive(h)
+	}
+
+	b.ReportMetric(bytesPerEntry, "heap-bytes/entry")
+}
+
+func BenchmarkHamt32FlatLeafMemory(b *testing.B) {
+	benchmarkHamt32LeafMemory(b, false)
+}
+
+func BenchmarkHamt32KeyLeafMemory(b *testing.B) {
+	benchmarkHamt32LeafMemory(b, true)
+}
+
+// BenchmarkHamt32CompressedMemory reports the heap bytes per entry of a
+// compressed tables only Hamt.
+func BenchmarkHamt32CompressedMemory(b *testing.B) {
+	setLibrary(componly)
+	defer setLibrary(TYP)
+
+	benchmarkHamt32LeafMemory(b, false)
+}
+
+func benchmarkHamt32PutSized(b *testing.B, sized bool) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var kvs = KVS[:64*1024]
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var h hamt32.Hamt
+		if sized {
+			h = hamt32.NewSized(uint(len(kvs)))
+		}
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
 		}
 	}
+}
 
-	if h.IsEmpty() {
-		b.Fatal("h.IsEmpty() => true; hence this wasn't a valid benchmark")
+func BenchmarkHamt32PutUnsized(b *testing.B) {
+	benchmarkHamt32PutSized(b, false)
+}
+
+func BenchmarkHamt32PutSized(b *testing.B) {
+	benchmarkHamt32PutSized(b, true)
+}
+
+func benchmarkHamt32PutHinted(b *testing.B, hinted bool) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var kvs = KVS[:64*1024]
+
+	var base = hamt32.NewStrict()
+	if hinted {
+		base = base.WithSizeHint(uint(len(kvs)))
 	}
 
-	RunTime["run BenchmarkHamt32Del"] = time.Since(StartTime["run BenchmarkHamt32Del"])
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var h = base
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+	}
+}
+
+func BenchmarkHamt32PutUnhinted(b *testing.B) {
+	benchmarkHamt32PutHinted(b, false)
+}
+
+func BenchmarkHamt32PutHinted(b *testing.B) {
+	benchmarkHamt32PutHinted(b, true)
+}
+
+func benchmarkHamt32CollisionSearch(b *testing.B, linear bool) {
+	var saveLinear = hamt32.CollisionLinearSearch
+	hamt32.CollisionLinearSearch = linear
+	defer func() { hamt32.CollisionLinearSearch = saveLinear }()
+
+	var keys = buildCollidingKeys(1000)
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var k = keys[i%len(keys)]
+		if _, found := h.Get(k); !found {
+			b.Fatalf("h.Get(%s) not found", k)
+		}
+	}
+}
+
+func BenchmarkHamt32CollisionLinearSearch(b *testing.B) {
+	benchmarkHamt32CollisionSearch(b, true)
+}
+
+func BenchmarkHamt32CollisionBinarySearch(b *testing.B) {
+	benchmarkHamt32CollisionSearch(b, false)
+}
+
+func benchmarkHamt32EntriesAppend(b *testing.B, reuse bool) {
+	var h hamt32.Hamt
+	for _, kv := range KVS[:16*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var buf []key.KeyVal
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !reuse {
+			buf = nil
+		}
+		buf = h.EntriesAppend(buf[:0])
+	}
+}
+
+func BenchmarkHamt32EntriesAppendFresh(b *testing.B) {
+	benchmarkHamt32EntriesAppend(b, false)
+}
+
+func BenchmarkHamt32EntriesAppendReused(b *testing.B) {
+	benchmarkHamt32EntriesAppend(b, true)
+}
+
+func benchmarkHamt32Adaptive(b *testing.B, threshold uint32) {
+	setLibrary(componly)
+	defer setLibrary(TYP)
+
+	var h = hamt32.Hamt{}.WithAdaptiveThreshold(threshold)
+	for _, kv := range KVS[:256*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	// read the hot keys often enough, then write them, to promote the
+	// tables on their paths.
+	var hot = KVS[:64]
+	for i := 0; i < 16; i++ {
+		for _, kv := range hot {
+			h.Get(kv.Key)
+		}
+	}
+	for _, kv := range hot {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	runtime.GC()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var kv = hot[i%len(hot)]
+		if _, found := h.Get(kv.Key); !found {
+			b.Fatalf("h.Get(%s) not found", kv.Key)
+		}
+	}
+}
+
+func BenchmarkHamt32HotGet(b *testing.B) {
+	benchmarkHamt32Adaptive(b, 0)
+}
+
+func BenchmarkHamt32HotGetAdaptive(b *testing.B) {
+	benchmarkHamt32Adaptive(b, 16)
+}
+
+func benchmarkHamt32GetZipf(b *testing.B, cacheSize int) {
+	var h = hamt32.Hamt{}.WithGetCache(cacheSize)
+	for _, kv := range KVS[:256*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	// a few hot keys are read most of the time
+	var zipf = rand.NewZipf(rand.New(rand.NewSource(32)), 1.2, 1, 256*1024-1)
+	var idxs = make([]uint64, 64*1024)
+	for i := range idxs {
+		idxs[i] = zipf.Uint64()
+	}
+
+	runtime.GC()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var kv = KVS[idxs[i%len(idxs)]]
+		if _, found := h.Get(kv.Key); !found {
+			b.Fatalf("h.Get(%s) not found", kv.Key)
+		}
+	}
+}
+
+func BenchmarkHamt32GetZipf(b *testing.B) {
+	benchmarkHamt32GetZipf(b, 0)
+}
+
+func BenchmarkHamt32GetZipfCached(b *testing.B) {
+	benchmarkHamt32GetZipf(b, 4096)
+}
+
+// BenchmarkHamt32GetSparseDeep gets keys stored below runs of single node
+// compressedTables.
+func BenchmarkHamt32GetSparseDeep(b *testing.B) {
+	setLibrary(hybrid)
+	defer setLibrary(TYP)
+
+	var keys = buildChainKeys32(hamt32.TableCapacity)
+
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var k = keys[i%len(keys)]
+		if _, found := h.Get(k); !found {
+			b.Fatalf("h.Get(%s) not found", k)
+		}
+	}
+}
+
+func benchmarkHamt32Small(b *testing.B, threshold uint) {
+	var saveThreshold = hamt32.LinearThreshold
+	hamt32.LinearThreshold = threshold
+	defer func() { hamt32.LinearThreshold = saveThreshold }()
+
+	var kvs = KVS[:8]
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var h hamt32.Hamt
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		for _, kv := range kvs {
+			if _, found := h.Get(kv.Key); !found {
+				b.Fatalf("h.Get(%s) not found", kv.Key)
+			}
+		}
+	}
+}
+
+func BenchmarkHamt32SmallTrie(b *testing.B) {
+	benchmarkHamt32Small(b, 0)
+}
+
+func BenchmarkHamt32SmallLinear(b *testing.B) {
+	benchmarkHamt32Small(b, 8)
+}
+
+// benchmarkHamt32TableAllocs() Dels and re-Puts keys of a Hamt; so every
+// op allocates the tables replacing those on its hash path. Run it with
+// -memprofile to see the allocations of each kind of table.
+func benchmarkHamt32TableAllocs(b *testing.B, typ int) {
+	setLibrary(typ)
+	defer setLibrary(TYP)
+
+	var kvs = KVS[:64*1024]
+	var h hamt32.Hamt
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var kv = kvs[i%len(kvs)]
+		var deleted bool
+		h, _, deleted = h.Del(kv.Key)
+		if !deleted {
+			b.Fatalf("h.Del(%s) not deleted", kv.Key)
+		}
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+}
+
+func BenchmarkHamt32TableAllocsHybrid(b *testing.B) {
+	benchmarkHamt32TableAllocs(b, hybrid)
+}
+
+func BenchmarkHamt32TableAllocsFullOnly(b *testing.B) {
+	benchmarkHamt32TableAllocs(b, fullonly)
+}
+
+func BenchmarkHamt32TableAllocsCompOnly(b *testing.B) {
+	benchmarkHamt32TableAllocs(b, componly)
 }
@@ -2,8 +2,8 @@ package hamt64
 
 import (
 	"fmt"
-	"log"
 	"strings"
+	"sync/atomic"
 
 	"github.com/lleo/go-hamt-key"
 )
@@ -32,12 +32,20 @@ import (
 // calculating if "nodeMap & (1<<idx) > 0" is true the idx'th bit is set. Given
 // that each 64 entry table is indexed by 6bit section (2^6==64) of the key hash,
 // there is a function to calculate the index called index(hash, depth);
-//
 type compressedTable struct {
 	hashPath key.HashVal60 // depth*Nbits of hash to get to this location in the Trie
 	depth    uint
 	nodeMap  uint64
 	nodes    []nodeI
+	hits     *uint32 // reads of this table; see Hamt.WithAdaptiveThreshold
+}
+
+// countLookup() counts a read of t, if t is a compressedTable counting its
+// lookups. Only reads count; the lookups done by Put and Del do not.
+func countLookup(t tableI) {
+	if ct, isComp := t.(*compressedTable); isComp && ct.hits != nil {
+		atomic.AddUint32(ct.hits, 1)
+	}
 }
 
 func createRootCompressedTable(lf leafI) tableI {
@@ -47,13 +55,13 @@ func createRootCompressedTable(lf leafI) tableI {
 	//ct.hashPath = 0
 	//ct.depth = 0
 	ct.nodeMap = 1 << idx
-	ct.nodes = make([]nodeI, 1)
+	ct.nodes = makeNodes(1)
 	ct.nodes[0] = lf
 
 	return ct
 }
 
-func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
+func createCompressedTable(depth uint, leaf1 leafI, leaf2 leafI) tableI {
 	var retTable = new(compressedTable)
 	retTable.hashPath = leaf1.Hash60() & key.HashPathMask60(depth-1)
 	retTable.depth = depth
@@ -66,7 +74,7 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		var idx2 = leaf2.Hash60().Index(d)
 
 		if idx1 != idx2 {
-			curTable.nodes = make([]nodeI, 2)
+			curTable.nodes = makeNodes(2)
 
 			curTable.nodeMap |= 1 << idx1
 			curTable.nodeMap |= 1 << idx2
@@ -82,7 +90,7 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		}
 		// idx1 == idx2 && continue
 
-		curTable.nodes = make([]nodeI, 1)
+		curTable.nodes = makeNodes(1)
 
 		//hashPath = hashPath.BuildHashPath(idx1, d)
 		hashPath = leaf1.Hash60() & key.HashPathMask60(d)
@@ -103,7 +111,7 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		var idx2 = leaf2.Hash60().Index(d)
 
 		if idx1 != idx2 {
-			curTable.nodes = make([]nodeI, 2)
+			curTable.nodes = makeNodes(2)
 
 			curTable.nodeMap |= 1 << idx1
 			curTable.nodeMap |= 1 << idx2
@@ -123,18 +131,19 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		// leaf1.Hash60() == leaf2.Hash60() all the way to MaxDepth;
 		// because Hamt.createTable() is called only once, and after a
 		// leaf1.Hash60() == leaf2.Hash60() check. It is here for completeness.
-		log.Printf("compressed_table.go:newCompressedTable: SHOULD NOT BE CALLED")
+		logger.Printf("compressed_table.go:newCompressedTable: SHOULD NOT BE CALLED")
 
 		// Check if the path of leaf1 is not equal to the one leaf2 just traversed.
 		if leaf1.Hash60() != leaf2.Hash60() {
-			log.Printf("madDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
-			log.Panicf("newCompressedTable: %s,0x%#06x != %s,0x%#06x",
+			logger.Printf("madDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
+			logger.Panicf("newCompressedTable: %s,0x%#06x != %s,0x%#06x",
 				leaf1.Hash60(), leaf1.Hash60(), leaf2.Hash60(), leaf2.Hash60())
 		}
 
 		// Just for completeness; leaf1.Hash60() == leaf2.hash60()
-		var newLeaf, _ = leaf1.put(leaf2.key, leaf2.val)
-		curTable.nodes = make([]nodeI, 1)
+		var kv2 = leaf2.keyVals()[0]
+		var newLeaf, _ = leaf1.put(kv2.Key, kv2.Val)
+		curTable.nodes = makeNodes(1)
 		curTable.nodeMap |= 1 << idx1
 		curTable.nodes[0] = newLeaf
 	}
@@ -153,7 +162,7 @@ func downgradeToCompressedTable(hashPath key.HashVal60, depth uint, ents []table
 	nt.hashPath = hashPath
 	nt.depth = depth
 	//nt.nodeMap = 0
-	nt.nodes = make([]nodeI, len(ents))
+	nt.nodes = makeNodes(len(ents))
 
 	for i := 0; i < len(ents); i++ {
 		var ent = ents[i]
@@ -182,12 +191,21 @@ func (t compressedTable) copy() *compressedTable {
 
 	//nt.nodes = append(nt.nodes, t.nodes...)
 
-	nt.nodes = make([]nodeI, len(t.nodes))
+	nt.nodes = makeNodes(len(t.nodes))
 	copy(nt.nodes, t.nodes)
 
 	return nt
 }
 
+// checkInvariant() returns an error if the nodeMap of t does not have a bit
+// set for exactly each of its nodes.
+func (t compressedTable) checkInvariant() error {
+	if n := bitCount64(t.nodeMap); n != uint(len(t.nodes)) {
+		return fmt.Errorf("bitCount64(nodeMap),%d != len(nodes),%d", n, len(t.nodes))
+	}
+	return nil
+}
+
 func (t compressedTable) nentries() uint {
 	//return bitCount64(t.nodeMap)
 	return uint(len(t.nodes))
@@ -196,24 +214,32 @@ func (t compressedTable) nentries() uint {
 // This function MUST return the slice of tableEntry structs from lowest
 // tableEntry.idx to highest tableEntry.idx .
 func (t compressedTable) entries() []tableEntry {
-	var n = t.nentries()
-	var ents = make([]tableEntry, n)
+	return t.entriesInto(make([]tableEntry, 0, t.nentries()))
+}
 
+func (t compressedTable) entriesInto(buf []tableEntry) []tableEntry {
 	for i, j := uint(0), uint(0); i < TableCapacity; i++ {
 		var nodeBit = uint64(1 << i)
 
 		if (t.nodeMap & nodeBit) > 0 {
-			ents[j] = tableEntry{i, t.nodes[j]}
+			buf = append(buf, tableEntry{i, t.nodes[j]})
 			j++
 		}
 	}
 
-	return ents
+	return buf
 }
 
 func (t compressedTable) get(idx uint) nodeI {
 	var nodeBit = uint64(1 << idx)
 
+	// A table of a single node, like each table of the run
+	// createCompressedTable() makes for two leafs with a long common hash
+	// path, holds it at nodes[0]; there are no bits below idx to count.
+	if t.nodeMap == nodeBit {
+		return t.nodes[0]
+	}
+
 	if (t.nodeMap & nodeBit) == 0 {
 		return nil
 	}
@@ -229,7 +255,8 @@ func (t compressedTable) get(idx uint) nodeI {
 	return node
 }
 
-func (t compressedTable) insert(idx uint, entry nodeI) tableI {
+// add() returns a copy of t with entry inserted at idx; it is never upgraded.
+func (t compressedTable) add(idx uint, entry nodeI) *compressedTable {
 	var nodeBit = uint64(1 << idx)
 	var bitMask = nodeBit - 1
 	var i = bitCount64(t.nodeMap & bitMask)
@@ -244,14 +271,23 @@ func (t compressedTable) insert(idx uint, entry nodeI) tableI {
 	//nt.nodes = append(nt.nodes[:i], append([]nodeI{entry}, nt.nodes[i:]...)...)
 
 	// Faster copy() way
-	nt.nodes = make([]nodeI, len(t.nodes)+1)
+	nt.nodes = makeNodes(len(t.nodes) + 1)
 	copy(nt.nodes, t.nodes[:i])
 	nt.nodes[i] = entry
 	copy(nt.nodes[i+1:], t.nodes[i:])
 
+	return nt
+}
+
+func (t compressedTable) insert(idx uint, entry nodeI) tableI {
+	var nt = t.add(idx, entry)
+
 	if GradeTables && uint(len(nt.nodes)) >= UpgradeThreshold {
 		// promote compressedTable to fullTable
-		return upgradeToFullTable(nt.hashPath, nt.depth, nt.entries())
+		var bufp = getTableEntryBuf()
+		var ft = upgradeToFullTable(nt.hashPath, nt.depth, nt.entriesInto(*bufp))
+		putTableEntryBuf(bufp)
+		return ft
 	}
 
 	return nt
@@ -269,7 +305,7 @@ func (t compressedTable) replace(idx uint, entry nodeI) tableI {
 	//nt.nodes = append(nt.nodes, t.nodes...)
 
 	// Faster copy() way
-	nt.nodes = make([]nodeI, len(t.nodes))
+	nt.nodes = makeNodes(len(t.nodes))
 	copy(nt.nodes, t.nodes)
 
 	nt.nodes[i] = entry
@@ -291,7 +327,7 @@ func (t compressedTable) remove(idx uint) tableI {
 	//nt.nodes = append(nt.nodes[:i], t.nodes[i+1:]...)
 
 	// Faster copy() way
-	nt.nodes = make([]nodeI, len(t.nodes)-1)
+	nt.nodes = makeNodes(len(t.nodes) - 1)
 	copy(nt.nodes, t.nodes[:i])
 	copy(nt.nodes[i:], t.nodes[i+1:])
 
@@ -317,7 +353,7 @@ func nodeMapString(nodeMap uint64) string {
 	return strings.Join(strs, " ")
 }
 
-//String() is required for nodeI depth
+// String() is required for nodeI depth
 func (t compressedTable) String() string {
 	// compressedTale{hashPath:/%d/%d/%d/%d/%d/%d, nentries:%d,}
 	return fmt.Sprintf("compressedTable{hashPath:%s, nentries()=%d, depth=%d}",
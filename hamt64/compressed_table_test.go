@@ -0,0 +1,24 @@
+package hamt64_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+func TestValidateDesyncedNodeMap64(t *testing.T) {
+	var h hamt64.Hamt
+	for _, s := range []string{"aaa", "aab", "aac"} {
+		h, _ = h.Put(stringkey.New(s), s)
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() => %s", err)
+	}
+
+	var err = hamt64.DesyncNodeMap(h).Validate()
+	if err == nil || !strings.Contains(err.Error(), "bitCount64(nodeMap),3 != len(nodes),4") {
+		t.Fatalf("Validate() of a desynced compressedTable => %v", err)
+	}
+}
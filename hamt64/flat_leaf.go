@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
 )
 
 type flatLeaf struct {
@@ -19,6 +20,14 @@ func newFlatLeaf(key key.Key, val interface{}) *flatLeaf {
 	//return &flatLeaf{key, val}
 }
 
+// createLeaf() returns a keyLeaf for a nil val, otherwise a flatLeaf.
+func createLeaf(k key.Key, v interface{}) leafI {
+	if v == nil {
+		return newKeyLeaf(k)
+	}
+	return newFlatLeaf(k, v)
+}
+
 func (l flatLeaf) Key() key.Key {
 	return l.key
 }
@@ -48,11 +57,19 @@ func (l flatLeaf) get(key key.Key) (interface{}, bool) {
 	return nil, false
 }
 
+// getStr() is get() for a stringkey.StringKey given only its string.
+func (l flatLeaf) getStr(s string) (interface{}, bool) {
+	if sk, ok := l.key.(*stringkey.StringKey); ok && sk.Str() == s {
+		return l.val, true
+	}
+	return nil, false
+}
+
 // put inserts a new key/val pair. Returns new leaf node and a bool indicating if
 // the key/val pair was added?(true), or was a previous key/val pair updated?(false).
 func (l flatLeaf) put(k key.Key, v interface{}) (leafI, bool) {
 	if l.key.Equals(k) {
-		nl := newFlatLeaf(k, v)
+		nl := createLeaf(k, v)
 		return nl, false // did NOT add k/v pair
 	}
 
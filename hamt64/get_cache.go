@@ -0,0 +1,87 @@
+package hamt64
+
+import (
+	"sync/atomic"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// getCache is a small cache of the leafs Get found, by the hash path of the
+// key; see WithGetCache. A Hamt is never modified, so a leaf found in a Hamt
+// stays the leaf for that hash path for as long as that Hamt is used. Every
+// Hamt derived from the one WithGetCache returned shares the getCache; so
+// each entry also holds the root of the Hamt it was found in, and is only used
+// by a Hamt with that root. That root keeps the whole Trie of the Hamt from
+// being collected; so an entry of another root is always replaced, by put(),
+// rather than kept until a superseded Hamt is no longer read.
+//
+// Each hash path has one slot, picked by its lowest bits, for the leaf of one
+// of the hash paths sharing it. An entry used since it was stored is not
+// replaced, but loses its used mark; so an entry is only replaced if it was
+// not used since the previous attempt, like a clock approximation of a least
+// recently used cache. Thus a hot hash path is not replaced by each Get of a
+// cold one. A hit costs an atomic load, and no lock.
+type getCache struct {
+	slots []atomic.Pointer[getCacheEntry]
+	mask  key.HashVal60
+}
+
+type getCacheEntry struct {
+	h60  key.HashVal60
+	root tableI
+	leaf leafI
+	used atomic.Bool
+}
+
+// newGetCache() returns a getCache of at least size slots; a power of two.
+func newGetCache(size int) *getCache {
+	var n = 1
+	for n < size {
+		n <<= 1
+	}
+	return &getCache{
+		slots: make([]atomic.Pointer[getCacheEntry], n),
+		mask:  key.HashVal60(n - 1),
+	}
+}
+
+// get() returns the leaf cached for h60 in the Hamt with root, or nil.
+func (c *getCache) get(root tableI, h60 key.HashVal60) leafI {
+	var ent = c.slots[h60&c.mask].Load()
+	if ent == nil || ent.h60 != h60 || ent.root != root {
+		return nil
+	}
+	if !ent.used.Load() {
+		ent.used.Store(true)
+	}
+	return ent.leaf
+}
+
+// put() caches leaf for h60 in the Hamt with root; unless the entry in the
+// slot of h60, of the same root, was used since it was stored, or since the
+// previous put() to the slot.
+func (c *getCache) put(root tableI, h60 key.HashVal60, leaf leafI) {
+	var slot = &c.slots[h60&c.mask]
+	if ent := slot.Load(); ent != nil && ent.root == root && ent.used.Load() {
+		ent.used.Store(false)
+		return
+	}
+	slot.Store(&getCacheEntry{h60: h60, root: root, leaf: leaf})
+}
+
+// WithGetCache returns h with a new cache, of about size entries, of the
+// leafs that Get, GetH, and GetStr found keys in; so repeated Gets of a small
+// set of hot keys skip the descent of the Trie. Every Hamt derived from the
+// returned Hamt shares the cache, which is safe for concurrent use; but an
+// entry is only used by the Hamt, with the same root, that cached it. An entry
+// keeps the whole Trie of the Hamt that cached it from being garbage
+// collected, until another Hamt caches a leaf in its slot; so a cache read by
+// the latest version of a Hamt holds few superseded Tries, but a slot only
+// read by an old version keeps it. A size of zero, or less, removes the cache.
+func (h Hamt) WithGetCache(size int) Hamt {
+	h.getCache = nil
+	if size > 0 {
+		h.getCache = newGetCache(size)
+	}
+	return h
+}
@@ -4,7 +4,7 @@ It is called hamt64 because this package is using 64 nodes for each level of
 the Trie. The term functional is used to imply immutable and persistent.
 
 The key to the hamt64 datastructure is imported from the
-"github.com/lleogo-hamt-key" module. We get the 60 bits of hash value from key.
+"github.com/lleo/go-hamt-key" module. We get the 60 bits of hash value from key.
 The 60bits of hash are separated into ten 6 bit values that constitue the hash
 path of any Key in this Trie. However, not all ten levels of the Trie are used.
 As many levels (ten or less) are used to find a unique location
@@ -17,10 +17,17 @@ level of the Trie.
 package hamt64
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
-	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 
 	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
 )
 
 // Nbits constant is the number of bits(6) a 60bit hash value is split into,
@@ -64,36 +71,268 @@ var UpgradeThreshold = TableCapacity * 2 / 3
 // The current value is TableCapacity/4.
 var DowngradeThreshold = TableCapacity / 4
 
+// LinearThreshold is a variable that defines how many key/val pairs a Hamt
+// holds in a sorted slice of leafs, searched linearly, before it builds a Trie
+// of tables. Small Hamts, eg. short lived ones for one request, are cheaper to
+// create and to search that way. Once a Hamt grows past LinearThreshold
+// key/val pairs it is a Trie; deleting key/val pairs does not turn it back.
+// Hamts created by NewSized() with a size hint over LinearThreshold start as a
+// Trie. Zero disables the linear representation; eg. 8 suits Hamts that
+// mostly hold a handful of key/val pairs.
+// Default: 0
+var LinearThreshold uint = 0
+
+// CollisionOverflowThreshold is a variable that defines when a collisionLeaf
+// exceeds that number of key/val pairs, it is replaced by an overflowLeaf.
+// An overflowLeaf stores the colliding key/val pairs in a nested Hamt, which
+// indexes them by the unrelated Hash30() of each key; so Get, Put, and Del
+// are no longer linear in the number of colliding keys. Zero disables
+// overflowLeafs.
+// Default: 0
+var CollisionOverflowThreshold uint = 0
+
+// CollisionLinearSearch is a variable that makes searches of a collisionLeaf
+// a linear scan of its key/val pairs; rather than a binary search of them. It
+// is meant for comparing the two.
+// Default: false
+var CollisionLinearSearch = false
+
 type Hamt struct {
-	root     tableI
-	nentries uint
+	root        tableI
+	nentries    uint
+	sizeHint    uint
+	strict      bool
+	fold        Fold
+	onGrade     *func(depth uint, from, to string)
+	putEq       *func(a, b interface{}) bool
+	hysteresis  uint
+	adaptive    uint32
+	metrics     *Metrics
+	getCache    *getCache
+	suggestBase *Config
+}
+
+// NewSized returns an empty Hamt that is expected to hold about n key/val
+// pairs. Tables near the root, where n key/val pairs would be spread densely
+// enough for them to be upgraded anyway, are created as fullTables from the
+// start; rather than being upgraded after a run of compressedTable inserts.
+// Every Hamt derived from the returned Hamt keeps this size hint.
+func NewSized(n uint) Hamt {
+	return Hamt{sizeHint: n}
+}
+
+// WithSizeHint returns h expecting to hold about n key/val pairs; the size
+// hint of NewSized, for a Hamt that has other options, or already holds
+// key/val pairs. Only tables created after the hint is set are affected; the
+// root is a fullTable from the start only if h is empty, or is still kept in
+// a linear list. Every Hamt derived from the returned Hamt keeps the hint; a
+// zero n removes it.
+func (h Hamt) WithSizeHint(n uint) Hamt {
+	h.sizeHint = n
+	return h
 }
 
+// Empty is the empty Hamt without any options; the zero Hamt, and what Clear()
+// returns for a Hamt without options. It is a variable only because a struct
+// cannot be a constant; it must never be assigned to.
+var Empty = Hamt{}
+
+// IsEmpty returns whether the Hamt holds no key/val pairs; that is whether,
+// like Empty, it has no root. Del drops the root with the last key. IsEmpty
+// does not compare h with Empty, only its root: an empty Hamt with options,
+// eg. from NewSized(), is empty though it is not == Empty; and a Hamt without
+// a root is empty whatever its Nentries() claims.
 func (h Hamt) IsEmpty() bool {
 	//return h.root == nil
 	//return h.nentries == 0
 	//return h.root == nil && h.nentries == 0
-	return h == Hamt{}
+	return h.root == nil
 }
 
 //func (h Hamt) Root() tableI {
 //	return h.root
 //}
 
+// Nentries returns the number of key/val pairs in the Hamt.
 func (h Hamt) Nentries() uint {
 	return h.nentries
 }
 
-func createRootTable(leaf leafI) tableI {
+// NewStrict returns an empty Hamt that refuses to store typed nil values; see
+// Put. Every Hamt derived from the returned Hamt is also strict.
+func NewStrict() Hamt {
+	return Hamt{strict: true}
+}
+
+// OnGrade returns h with fn as its hook for table type changes; fn is called
+// whenever a Put or Del upgrades a compressedTable to a fullTable, or
+// downgrades a fullTable to a compressedTable, with the depth of the table and
+// the table types before and after; "compressed" or "full". Tables shared
+// unchanged with the previous Hamt are not reported. Every Hamt derived from
+// the returned Hamt calls fn. A nil fn removes the hook.
+func (h Hamt) OnGrade(fn func(depth uint, from, to string)) Hamt {
+	h.onGrade = nil
+	if fn != nil {
+		h.onGrade = &fn
+	}
+	return h
+}
+
+// SkipEqualPuts returns h with eq as its comparator for Put; a Put, PutH or
+// PutMerge of a key that is already stored with a val that eq reports as
+// equal to the new val returns the Hamt unchanged, sharing its root, rather
+// than an equal copy. So idempotent writes, eg. reconciling a config, do not
+// allocate. Every Hamt derived from the returned Hamt uses eq. A nil eq
+// removes the comparator.
+func (h Hamt) SkipEqualPuts(eq func(a, b interface{}) bool) Hamt {
+	h.putEq = nil
+	if eq != nil {
+		h.putEq = &eq
+	}
+	return h
+}
+
+// WithGradeHysteresis returns h with n as the gap it widens between upgrading
+// and downgrading tables. A compressedTable of h is upgraded when it meets or
+// exceeds UpgradeThreshold+n entries, and a fullTable is downgraded when it
+// drops below DowngradeThreshold-n entries; so tables that hover around a
+// threshold do not churn between types. Every Hamt derived from the returned
+// Hamt keeps n; a zero n grades tables at the thresholds themselves.
+func (h Hamt) WithGradeHysteresis(n uint) Hamt {
+	h.hysteresis = n
+	return h
+}
+
+// WithAdaptiveThreshold returns h with n as the number of times Get, GetH and
+// GetStr must read a compressedTable for it to be replaced by a fullTable,
+// regardless of its number of entries, by the next write to it; which is when
+// a Hamt makes a new table anyway. So the tables on the paths to frequently
+// read keys become fullTables, which are indexed without counting bits of a
+// nodeMap. Only the compressedTables on the path of a write of such a Hamt
+// count their reads; each with an atomic add. Every Hamt derived from the
+// returned Hamt keeps n; a zero n, the default, counts nothing. A fullTable is
+// still downgraded when it drops below DowngradeThreshold entries.
+func (h Hamt) WithAdaptiveThreshold(n uint32) Hamt {
+	h.adaptive = n
+	return h
+}
+
+// hot() returns whether t is a compressedTable read at least the
+// AdaptiveThreshold of h times; then the table that replaces t on a write is a
+// fullTable.
+func (h Hamt) hot(t tableI) bool {
+	var ct, isComp = t.(*compressedTable)
+	return isComp && h.adaptive > 0 && ct.hits != nil &&
+		atomic.LoadUint32(ct.hits) >= h.adaptive
+}
+
+// promote() returns t as a fullTable, if it is a compressedTable.
+func promote(t tableI) tableI {
+	if ct, isComp := t.(*compressedTable); isComp {
+		return upgradeToFullTable(ct.hashPath, ct.depth, ct.entries())
+	}
+	return t
+}
+
+// insert() is t.insert(idx, entry), except that a compressedTable is not
+// upgraded while it has fewer than UpgradeThreshold plus the GradeHysteresis
+// of h entries; unless it is hot(). A run of tables compacted by Compact() is
+// ended at its first table, created as h creates a new table at that depth.
+func (h Hamt) insert(t tableI, idx uint, entry nodeI) tableI {
+	if ct, isChain := t.(*chainTable); isChain {
+		t = ct.first(h.startFull(ct.depth))
+	}
+	if h.hot(t) {
+		return promote(t.insert(idx, entry))
+	}
+	if ct, isComp := t.(*compressedTable); isComp && h.hysteresis > 0 &&
+		uint(len(ct.nodes))+1 < UpgradeThreshold+h.hysteresis {
+		return ct.add(idx, entry)
+	}
+	return t.insert(idx, entry)
+}
+
+// replace() is t.replace(idx, entry), upgraded to a fullTable if t is hot().
+func (h Hamt) replace(t tableI, idx uint, entry nodeI) tableI {
+	if h.hot(t) {
+		return promote(t.replace(idx, entry))
+	}
+	return t.replace(idx, entry)
+}
+
+// remove() is t.remove(idx), except that a fullTable is not downgraded until
+// it drops below DowngradeThreshold minus the GradeHysteresis of h entries;
+// and a hot() compressedTable is upgraded to a fullTable.
+func (h Hamt) remove(t tableI, idx uint) tableI {
+	if h.hot(t) {
+		return promote(t.remove(idx))
+	}
+	if ft, isFull := t.(*fullTable); isFull && h.hysteresis > 0 &&
+		ft.numEnts > 1 && ft.numEnts-1+h.hysteresis >= DowngradeThreshold {
+		return ft.drop(idx)
+	}
+	return t.remove(idx)
+}
+
+// gradeName() is the name OnGrade uses for the type of t; "" for other tables.
+func gradeName(t tableI) string {
+	switch t.(type) {
+	case *fullTable:
+		return "full"
+	case *compressedTable:
+		return "compressed"
+	}
+	return ""
+}
+
+// isTypedNil() returns whether v is a non-nil interface{} holding a nil
+// pointer, map, slice, func, chan, or unsafe.Pointer.
+func isTypedNil(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	var rv = reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan,
+		reflect.Interface, reflect.UnsafePointer:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// startFull() returns whether a new table at depth should be a fullTable; either
+// because of FullTableInit, or because the size hint of h expects that table to
+// grow to UpgradeThreshold, plus the GradeHysteresis of h, entries.
+func (h Hamt) startFull(depth uint) bool {
 	if FullTableInit {
+		return true
+	}
+	if !GradeTables {
+		return false
+	}
+	var expected = h.sizeHint
+	for d := uint(0); d < depth && expected > 0; d++ {
+		expected /= TableCapacity
+	}
+	return expected >= UpgradeThreshold+h.hysteresis
+}
+
+// startLinear() returns whether a new root of h should be a linearTable;
+// unless the size hint of h expects more than LinearThreshold entries.
+func (h Hamt) startLinear() bool {
+	return LinearThreshold > 0 && h.sizeHint <= LinearThreshold
+}
+
+func createRootTable(full bool, leaf leafI) tableI {
+	if full {
 		return createRootFullTable(leaf)
 	}
 	return createRootCompressedTable(leaf)
 }
 
 //func createTable(depth uint, leaf1 leafI, k key.Key, v interface{}) tableI {
-func createTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
-	if FullTableInit {
+func createTable(full bool, depth uint, leaf1 leafI, leaf2 leafI) tableI {
+	if full {
 		return createFullTable(depth, leaf1, leaf2)
 	}
 	return createCompressedTable(depth, leaf1, leaf2)
@@ -102,6 +341,28 @@ func createTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 // persist() is ONLY called on a fresh copy of the current Hamt.
 // Hence, modifying it is allowed.
 func (nh *Hamt) persist(oldTable, newTable tableI, path tableStack) {
+	if newTable != nil {
+		nh.metrics.countCopy()
+	}
+
+	if ct, isComp := newTable.(*compressedTable); isComp && nh.adaptive > 0 {
+		ct.hits = new(uint32)
+	}
+
+	if nh.onGrade != nil && newTable != nil {
+		var from, to = gradeName(oldTable), gradeName(newTable)
+		if ct, isChain := oldTable.(*chainTable); isChain && newTable.nentries() > 1 {
+			// a run is graded as the first table insert() ended it at
+			from = "compressed"
+			if nh.startFull(ct.depth) {
+				from = "full"
+			}
+		}
+		if from != "" && to != "" && from != to {
+			(*nh.onGrade)(uint(path.len()), from, to)
+		}
+	}
+
 	if path.isEmpty() {
 		nh.root = newTable
 		return
@@ -116,9 +377,9 @@ func (nh *Hamt) persist(oldTable, newTable tableI, path tableStack) {
 	var newParent tableI
 
 	if newTable == nil {
-		newParent = oldParent.remove(parentIdx)
+		newParent = nh.remove(oldParent, parentIdx)
 	} else {
-		newParent = oldParent.replace(parentIdx, newTable)
+		newParent = nh.replace(oldParent, parentIdx, newTable)
 	}
 
 	nh.persist(oldParent, newParent, path) //recurses at most MaxDepth-1 times
@@ -127,6 +388,11 @@ func (nh *Hamt) persist(oldTable, newTable tableI, path tableStack) {
 }
 
 func (h Hamt) find(k key.Key) (path tableStack, leaf leafI, idx uint) {
+	return h.findH(k, h.hash60(k))
+}
+
+// findH() is find() given the Hash60() of k.
+func (h Hamt) findH(k key.Key, h60 key.HashVal60) (path tableStack, leaf leafI, idx uint) {
 	if h.IsEmpty() {
 		return nil, nil, 0
 	}
@@ -134,7 +400,6 @@ func (h Hamt) find(k key.Key) (path tableStack, leaf leafI, idx uint) {
 	path = newTableStack()
 	var curTable = h.root
 
-	var h60 = k.Hash60()
 	var depth uint
 	var curNode nodeI
 
@@ -153,12 +418,12 @@ DepthIter:
 			break DepthIter
 		case tableI:
 			if depth == MaxDepth {
-				log.Panicf("SHOULD NOT BE REACHED; depth,%d == MaxDepth,%d & tableI entry found; %s", depth, MaxDepth, n)
+				logger.Panicf("SHOULD NOT BE REACHED; depth,%d == MaxDepth,%d & tableI entry found; %s", depth, MaxDepth, n)
 			}
 			curTable = n
 			// exit switch then loop for
 		default:
-			log.Panicf("SHOULD NOT BE REACHED: depth=%d; curNode unknown type=%T;", depth, curNode)
+			logger.Panicf("SHOULD NOT BE REACHED: depth=%d; curNode unknown type=%T;", depth, curNode)
 		}
 	}
 
@@ -182,45 +447,440 @@ DepthIter:
 // Get(k) retrieves the value for a given key from the Hamt. The bool
 // represents whether the key was found.
 func (h Hamt) Get(k key.Key) (val interface{}, found bool) {
+	return h.GetH(k, h.hash60(k))
+}
+
+// GetOK returns the value stored for k, or nil if k is not in the Hamt. It
+// is Get without the found bool; so a key stored with a nil value and an
+// absent key both return nil. Use Get when that difference matters.
+func (h Hamt) GetOK(k key.Key) interface{} {
+	var val, _ = h.Get(k)
+	return val
+}
+
+// VerifyAll returns whether every key/val pair in kvs is in the Hamt with a
+// value that valEq reports as equal to its Val. If not, it also returns the
+// first key of kvs that is missing or has an unequal value.
+func (h Hamt) VerifyAll(kvs []key.KeyVal, valEq func(a, b interface{}) bool) (bool, key.Key) {
+	for _, kv := range kvs {
+		var val, found = h.Get(kv.Key)
+		if !found || !valEq(val, kv.Val) {
+			return false, kv.Key
+		}
+	}
+	return true, nil
+}
+
+// HasAll returns whether each of keys is in the Hamt; the i'th bool is for
+// keys[i]. It only looks up each key, like GetH, without building the path
+// to it or returning its value. For an empty Hamt every bool is false.
+func (h Hamt) HasAll(keys []key.Key) []bool {
+	var has = make([]bool, len(keys))
+	if h.IsEmpty() {
+		return has
+	}
+	for i, k := range keys {
+		_, has[i] = h.GetH(k, h.hash60(k))
+	}
+	return has
+}
+
+// Project returns a new Hamt of only the key/val pairs of the Hamt whose keys
+// are in keys; keys not in the Hamt are skipped. The new Hamt keeps the
+// options of h, like Clear, except that it is sized for len(keys) key/val
+// pairs; so a slice of a big Hamt can be serialized by itself.
+func (h Hamt) Project(keys []key.Key) Hamt {
+	var nh = h.Clear()
+	nh.sizeHint = uint(len(keys))
+	if h.IsEmpty() {
+		return nh
+	}
+	for _, k := range keys {
+		var h60 = h.hash60(k)
+		if val, found := h.GetH(k, h60); found {
+			nh, _ = nh.PutH(k, h60, val)
+		}
+	}
+	return nh
+}
+
+// Rekey returns a new Hamt of the values of the Hamt, each stored under the
+// key mapping returns for its old key; key/val pairs for which mapping
+// returns false are dropped. If mapping returns the same new key for several
+// old keys, the value of the last of them, in hash path order, is stored. The
+// new Hamt keeps the options of h, like Clear.
+func (h Hamt) Rekey(mapping func(old key.Key) (key.Key, bool)) Hamt {
+	var nh = h.Clear()
+	if h.IsEmpty() {
+		return nh
+	}
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		if k, ok := mapping(UnfoldKey(kv.Key)); ok {
+			nh, _ = nh.Put(k, kv.Val)
+		}
+		return true
+	})
+	return nh
+}
+
+// GetH is Get given h60, the Hash60() of k, as computed by the caller; so a
+// caller doing several operations on the same key hashes it only once. h60
+// MUST be h.PathHash(k), which is k.Hash60() unless h was created by
+// NewFolded; otherwise GetH will not find k, and PutH or DelH will corrupt the
+// Hamt.
+func (h Hamt) GetH(k key.Key, h60 key.HashVal60) (val interface{}, found bool) {
+	val, found = h.getH(k, h60)
+	h.metrics.countGet(found)
+	return
+}
+
+// getH() is GetH without counting it in the Metrics of h.
+func (h Hamt) getH(k key.Key, h60 key.HashVal60) (val interface{}, found bool) {
+	if h.IsEmpty() {
+		return //nil, false
+	}
+
+	if lt, isLinear := h.root.(*linearTable); isLinear {
+		return lt.lookup(k, h60)
+	}
+
+	if h.getCache != nil {
+		if leaf := h.getCache.get(h.root, h60); leaf != nil {
+			return leaf.get(k)
+		}
+	}
+
+	var curTable = h.root
+
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		var idx = h60.Index(depth)
+		if h.adaptive > 0 {
+			countLookup(curTable)
+		}
+		var curNode = curTable.get(idx)
+
+		if curNode == nil {
+			return //nil, false
+		}
+
+		if leaf, isLeaf := curNode.(leafI); isLeaf {
+			val, found = leaf.get(k)
+			if found && h.getCache != nil {
+				h.getCache.put(h.root, h60, leaf)
+			}
+			return
+		}
+
+		if depth == MaxDepth {
+			panic("SHOULD NOT HAPPEN")
+		}
+
+		// skip a whole run of tables compacted by Compact()
+		if ct, isChain := curNode.(*chainTable); isChain {
+			if !ct.matches(h60) {
+				return //nil, false
+			}
+			depth += uint(len(ct.idxs))
+			curTable = ct.child
+			continue
+		}
+
+		curTable = curNode.(tableI)
+	}
+
+	panic("SHOULD NEVER BE REACHED")
+}
+
+// GetStr(s) retrieves the value for a key created by stringkey.New(s),
+// without the caller having to allocate that key. The results are identical
+// to h.Get(stringkey.New(s)).
+func (h Hamt) GetStr(s string) (val interface{}, found bool) {
+	val, found = h.getStr(s)
+	h.metrics.countGet(found)
+	return
+}
+
+// getStr() is GetStr without counting it in the Metrics of h.
+func (h Hamt) getStr(s string) (val interface{}, found bool) {
 	if h.IsEmpty() {
 		return //nil, false
 	}
+	if h.fold != FoldXor {
+		var k = stringkey.New(s)
+		return h.getH(k, h.hash60(k))
+	}
+
+	var h60 = hashString60(s)
+
+	if lt, isLinear := h.root.(*linearTable); isLinear {
+		return lt.lookupStr(s, h60)
+	}
 
-	var h60 = k.Hash60()
+	if h.getCache != nil {
+		if leaf := h.getCache.get(h.root, h60); leaf != nil {
+			return leaf.getStr(s)
+		}
+	}
 
 	var curTable = h.root
 
 	for depth := uint(0); depth <= MaxDepth; depth++ {
 		var idx = h60.Index(depth)
+		if h.adaptive > 0 {
+			countLookup(curTable)
+		}
 		var curNode = curTable.get(idx)
 
 		if curNode == nil {
 			return //nil, false
 		}
 
+		if leaf, isLeaf := curNode.(leafI); isLeaf {
+			val, found = leaf.getStr(s)
+			if found && h.getCache != nil {
+				h.getCache.put(h.root, h60, leaf)
+			}
+			return
+		}
+
+		if depth == MaxDepth {
+			panic("SHOULD NOT HAPPEN")
+		}
+
+		// skip a whole run of tables compacted by Compact()
+		if ct, isChain := curNode.(*chainTable); isChain {
+			if !ct.matches(h60) {
+				return //nil, false
+			}
+			depth += uint(len(ct.idxs))
+			curTable = ct.child
+			continue
+		}
+
+		curTable = curNode.(tableI)
+	}
+
+	panic("SHOULD NEVER BE REACHED")
+}
+
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+	mask60      uint64 = 1<<60 - 1
+)
+
+// hashString60() calculates the same key.HashVal60 as stringkey.New(s).Hash60();
+// the FNV-1 64bit hash of the string folded down to 60 bits. It is done inline
+// so no hash.Hash64 or []byte(s) is allocated.
+func hashString60(s string) key.HashVal60 {
+	var h64 = fnvOffset64
+	for i := 0; i < len(s); i++ {
+		h64 *= fnvPrime64
+		h64 ^= uint64(s[i])
+	}
+	return key.HashVal60((h64 >> 60) ^ (h64 & mask60))
+}
+
+// DecodeHashPath60 returns the table index at every depth of the hash path of
+// the 60 bit hash h60; index 0 is the index in the root table. Only the lower
+// 60 bits of h60 are used; a key's Hash60(), or the PathHash of a key in a
+// Hamt, is already 60 bits.
+func DecodeHashPath60(h60 uint64) [MaxDepth + 1]uint {
+	var idxs [MaxDepth + 1]uint
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		idxs[depth] = key.HashVal60(h60 & mask60).Index(depth)
+	}
+	return idxs
+}
+
+// MayContain is a shallow Get(k), for a cheap probe; eg. by a tiered cache.
+// It descends no further than the table at maxDepth, and returns known false
+// if k may be below it. Otherwise known is true, and found is whether k is in
+// the Hamt; found is false, without searching deeper, as soon as the hash
+// path of k leads to an empty entry.
+func (h Hamt) MayContain(k key.Key, maxDepth uint) (found, known bool) {
+	if h.IsEmpty() {
+		return false, true
+	}
+
+	var h60 = h.hash60(k)
+
+	if lt, isLinear := h.root.(*linearTable); isLinear {
+		_, found = lt.lookup(k, h60)
+		return found, true
+	}
+
+	var curTable = h.root
+
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var curNode = curTable.get(h60.Index(depth))
+
+		if curNode == nil {
+			return false, true
+		}
+
+		if leaf, isLeaf := curNode.(leafI); isLeaf {
+			_, found = leaf.get(k)
+			return found, true
+		}
+
+		// a run of tables compacted by Compact() counts as every table of it
+		if ct, isChain := curNode.(*chainTable); isChain {
+			if !ct.matches(h60) {
+				return false, true
+			}
+			depth += uint(len(ct.idxs))
+			curTable = ct.child
+			continue
+		}
+
+		curTable = curNode.(tableI)
+	}
+
+	return false, false
+}
+
+// GetKind(k) is Get(k) that also names the kind of leaf the key was found in;
+// "flat", "collision", or "overflow". It is a debugging aid. If the key is not found the
+// kind is "".
+func (h Hamt) GetKind(k key.Key) (val interface{}, kind string, found bool) {
+	if h.IsEmpty() {
+		return //nil, "", false
+	}
+
+	var h60 = h.hash60(k)
+
+	var curTable = h.root
+
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		var idx = h60.Index(depth)
+		var curNode = curTable.get(idx)
+
+		if curNode == nil {
+			return //nil, "", false
+		}
+
 		if leaf, isLeaf := curNode.(leafI); isLeaf {
 			val, found = leaf.get(k)
+			if found {
+				kind = leafKind(leaf)
+			}
 			return
 		}
 
 		if depth == MaxDepth {
 			panic("SHOULD NOT HAPPEN")
 		}
+
+		// skip a whole run of tables compacted by Compact()
+		if ct, isChain := curNode.(*chainTable); isChain {
+			if !ct.matches(h60) {
+				return //nil, "", false
+			}
+			depth += uint(len(ct.idxs))
+			curTable = ct.child
+			continue
+		}
+
 		curTable = curNode.(tableI)
 	}
 
 	panic("SHOULD NEVER BE REACHED")
 }
 
+func leafKind(leaf leafI) string {
+	switch leaf.(type) {
+	case flatLeaf, *flatLeaf, keyLeaf, *keyLeaf:
+		return "flat"
+	case collisionLeaf, *collisionLeaf:
+		return "collision"
+	case overflowLeaf, *overflowLeaf:
+		return "overflow"
+	}
+	logger.Panicf("SHOULD NOT BE REACHED: unknown leaf type=%T", leaf)
+	return ""
+}
+
 // Put inserts a key/val pair into Hamt, returning a new persistent Hamt and a
 // bool indicating if the key/val pair was added(true) or mearly updated(false).
+//
+// Beware that a typed nil value (eg. a nil *T) is stored as given; Get will
+// return it as a val that is != nil. A Hamt created by NewStrict does not
+// store typed nil values; Put returns the original Hamt and false instead.
 func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
+	return h.PutH(k, h.hash60(k), v)
+}
+
+// PutH is Put given h60, the Hash60() of k, as computed by the caller; see GetH.
+func (h Hamt) PutH(k key.Key, h60 key.HashVal60, v interface{}) (nh Hamt, added bool) {
+	return h.putMerge(k, h60, v, nil)
+}
+
+// PutInPlace is Put that stores the new Hamt in *h, rather than returning it;
+// eg. in a loop building a Hamt. The Hamt *h held before is not modified, so
+// copies of it taken earlier are unaffected. It returns whether k was added.
+func (h *Hamt) PutInPlace(k key.Key, v interface{}) bool {
+	var added bool
+	*h, added = h.Put(k, v)
+	return added
+}
+
+// PutMerge is Put, except that if k is already in the Hamt, it stores
+// merge(old, v), where old is the val k had; eg. to accumulate a sum. Finding
+// old and storing the merged val take one descent of the Trie. The bool is
+// true if k was added. merge is not called if k was not in the Hamt.
+func (h Hamt) PutMerge(k key.Key, v interface{}, merge func(old, new interface{}) interface{}) (Hamt, bool) {
+	return h.putMerge(k, h.hash60(k), v, merge)
+}
+
+// putMerge() is PutH that stores merge(old, v) if k is in h; merge may be nil.
+func (h Hamt) putMerge(k key.Key, h60 key.HashVal60, v interface{}, merge func(old, new interface{}) interface{}) (nh Hamt, added bool) {
 	nh = h //copy by value
 
-	var path, leaf, idx = h.find(k)
+	h.metrics.countPut()
+
+	if h.strict && isTypedNil(v) {
+		return
+	}
+
+	k = h.foldKey(k, h60)
+
+	if lt, isLinear := h.root.(*linearTable); isLinear {
+		if merge != nil || h.putEq != nil {
+			if old, found := lt.lookup(k, h60); found {
+				if merge != nil {
+					v = merge(old, v)
+					if h.strict && isTypedNil(v) {
+						return
+					}
+				}
+				if h.putEq != nil && (*h.putEq)(old, v) {
+					return
+				}
+			}
+		}
+		var nlt *linearTable
+		nlt, added = lt.put(k, h60, v)
+		if !added || h.nentries < LinearThreshold && h.startLinear() {
+			h.metrics.countCopy()
+			nh.root = nlt
+			if added {
+				nh.nentries++
+			}
+			return
+		}
+		// h grows past LinearThreshold, or its size hint; continue as a Trie
+		h.root = lt.trieRoot(h.startFull(0))
+	}
+
+	var path, leaf, idx = h.findH(k, h60)
 
 	if path == nil { // h.IsEmpty()
-		nh.root = createRootTable(newFlatLeaf(k, v))
+		if h.startLinear() {
+			nh.root = createLinearTable(createLeaf(k, v))
+		} else {
+			nh.root = createRootTable(h.startFull(0), createLeaf(k, v))
+		}
 		nh.nentries++
 
 		//return nh, true
@@ -234,16 +894,29 @@ func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
 	var newTable tableI
 
 	if leaf == nil {
-		newTable = curTable.insert(idx, newFlatLeaf(k, v))
+		newTable = h.insert(curTable, idx, createLeaf(k, v))
 		added = true
 	} else {
-		if leaf.Hash60() == k.Hash60() {
+		if leaf.Hash60() == h60 {
+			if merge != nil || h.putEq != nil {
+				if old, found := leaf.get(k); found {
+					if merge != nil {
+						v = merge(old, v)
+						if h.strict && isTypedNil(v) {
+							return
+						}
+					}
+					if h.putEq != nil && (*h.putEq)(old, v) {
+						return
+					}
+				}
+			}
 			var newLeaf leafI
 			newLeaf, added = leaf.put(k, v)
-			newTable = curTable.replace(idx, newLeaf)
+			newTable = h.replace(curTable, idx, newLeaf)
 		} else {
-			var tmpTable = createTable(depth+1, leaf, *newFlatLeaf(k, v))
-			newTable = curTable.replace(idx, tmpTable)
+			var tmpTable = createTable(h.startFull(depth+1), depth+1, leaf, createLeaf(k, v))
+			newTable = h.replace(curTable, idx, tmpTable)
 			added = true
 		}
 	}
@@ -258,20 +931,126 @@ func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
 	return
 }
 
+// PutBounded is Put that only adds a new key/val pair if the Hamt would still
+// hold no more than maxEntries key/val pairs. Replacing the value of a key
+// already in the Hamt is always allowed. The bool is true if k is stored with
+// v in the returned Hamt; it is false, and h is returned unchanged, if adding
+// k would exceed maxEntries.
+func (h Hamt) PutBounded(k key.Key, v interface{}, maxEntries uint) (Hamt, bool) {
+	if h.strict && isTypedNil(v) {
+		return h, false
+	}
+	if h.nentries >= maxEntries {
+		if _, found := h.Get(k); !found {
+			return h, false
+		}
+	}
+	var nh, _ = h.Put(k, v)
+	return nh, true
+}
+
+// GetOrCompute returns the Hamt unchanged and the value of k, if k is in
+// the Hamt. Otherwise it calls compute for the value of k, and returns a new
+// Hamt with k stored with that value, and the value. k is hashed only once.
+func (h Hamt) GetOrCompute(k key.Key, compute func() interface{}) (Hamt, interface{}) {
+	var h60 = h.hash60(k)
+	if val, found := h.GetH(k, h60); found {
+		return h, val
+	}
+	var val = compute()
+	var nh, _ = h.PutH(k, h60, val)
+	return nh, val
+}
+
+// CompareAndSet Puts every key/val pair of updates, if every key/val pair of
+// expected is in the Hamt, with a value valEq reports as equal; see
+// VerifyAll. It returns the new Hamt and true; otherwise it returns the Hamt
+// unchanged and false. Because a Hamt is never modified, either all of
+// updates are applied in the returned Hamt, or none are.
+func (h Hamt) CompareAndSet(expected, updates []key.KeyVal, valEq func(a, b interface{}) bool) (Hamt, bool) {
+	if ok, _ := h.VerifyAll(expected, valEq); !ok {
+		return h, false
+	}
+	var nh = h
+	for _, kv := range updates {
+		nh, _ = nh.Put(kv.Key, kv.Val)
+	}
+	return nh, true
+}
+
+// PutRaw is Put for a key given as its bytes and its precomputed 60 bit hash;
+// so the key is not hashed again. The caller is responsible for h60 being the
+// correct hash of keyBytes; only the lower 60 bits are used. Keys stored by
+// PutRaw are only equal to other keys stored by PutRaw with the same bytes.
+func (h Hamt) PutRaw(keyBytes []byte, h60 uint64, v interface{}) (Hamt, bool) {
+	return h.Put(newRawKey(keyBytes, h60&mask60), v)
+}
+
 // Hamt.Del(k) returns a Hamt structure, a value, and a boolean that specifies
 // whether or not the key was found (and therefor deleted). If the key was
 // found & deleted it returns the value assosiated with the key and a new
 // persistent Hamt structure, otherwise it returns a nil value and the original
 // (immutable) Hamt structure
 func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
-	nh = h // copy by value
+	return h.DelH(k, h.hash60(k))
+}
 
-	var path, leaf, idx = h.find(k)
+// DelInPlace is Del that stores the new Hamt in *h, rather than returning
+// it; see PutInPlace. It returns the value k had, and whether k was deleted.
+func (h *Hamt) DelInPlace(k key.Key) (interface{}, bool) {
+	var val interface{}
+	var deleted bool
+	*h, val, deleted = h.Del(k)
+	return val, deleted
+}
 
-	if path == nil { // h.IsEmpty()
-		//return nh, nil, false
-		return
-	}
+// DelH is Del given h60, the Hash60() of k, as computed by the caller; see GetH.
+func (h Hamt) DelH(k key.Key, h60 key.HashVal60) (nh Hamt, val interface{}, deleted bool) {
+	nh, val, deleted, _ = h.delH(k, h60)
+	return
+}
+
+// DelDetailed is Del that also returns whether the deletion changed the Trie
+// beyond the leaf of k; collapsed is true if a collision leaf was left with one
+// key/val pair, and became a plain leaf, or if k was the last key/val pair of a
+// table, and the table was removed. Deleting from a collision leaf that keeps
+// more than one key/val pair does not collapse it.
+func (h Hamt) DelDetailed(k key.Key) (nh Hamt, val interface{}, deleted, collapsed bool) {
+	return h.delH(k, h.hash60(k))
+}
+
+// delH() is DelH that also returns whether the deletion collapsed a leaf or
+// removed a table; see DelDetailed.
+func (h Hamt) delH(k key.Key, h60 key.HashVal60) (nh Hamt, val interface{}, deleted, collapsed bool) {
+	nh = h // copy by value
+
+	h.metrics.countDel()
+
+	if lt, isLinear := h.root.(*linearTable); isLinear {
+		var nlt *linearTable
+		nlt, val, deleted = lt.del(k, h60)
+		if deleted {
+			h.metrics.countCopy()
+			nh.nentries--
+			nh.root = nil
+			collapsed = nlt == nil
+			if nlt != nil {
+				nh.root = nlt
+				var i, _ = lt.find(h60)
+				if j, found := nlt.find(h60); found {
+					collapsed = collapsedLeaf(lt.leafs[i], nlt.leafs[j])
+				}
+			}
+		}
+		return
+	}
+
+	var path, leaf, idx = h.findH(k, h60)
+
+	if path == nil { // h.IsEmpty()
+		//return nh, nil, false
+		return
+	}
 
 	var curTable = path.pop()
 	//var depth = uint(path.len())
@@ -293,9 +1072,11 @@ func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
 		}
 
 		if newLeaf == nil {
-			newTable = curTable.remove(idx)
+			newTable = h.remove(curTable, idx)
+			collapsed = newTable == nil
 		} else {
-			newTable = curTable.replace(idx, newLeaf)
+			newTable = h.replace(curTable, idx, newLeaf)
+			collapsed = collapsedLeaf(leaf, newLeaf)
 		}
 	}
 
@@ -309,22 +1090,1689 @@ func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
 	return
 }
 
-func (h Hamt) String() string {
-	return fmt.Sprintf("Hamt{ nentries: %d, root: %s }", h.nentries, h.root)
+// collapsedLeaf() returns whether deleting a key/val pair from the leaf l,
+// leaving the leaf nl, turned a leaf of many key/val pairs into a leaf of one.
+func collapsedLeaf(l, nl leafI) bool {
+	switch l.(type) {
+	case *flatLeaf, *keyLeaf:
+		return false
+	}
+	switch nl.(type) {
+	case *flatLeaf, *keyLeaf:
+		return true
+	}
+	return false
 }
 
-const halfIndent = "  "
-const fullIndent = "    "
+// KeyVals returns all the key/val pairs in the Hamt in hash path order.
+func (h Hamt) KeyVals() []key.KeyVal {
+	var kvs = make([]key.KeyVal, 0, h.nentries)
+	if h.IsEmpty() {
+		return kvs
+	}
+	return appendKeyVals(kvs, h.root)
+}
 
-func (h Hamt) LongString(indent string) string {
-	var str string
+// EntriesSortedByValue returns all the key/val pairs of the Hamt sorted by
+// their values, as ordered by less; key/val pairs with equal values are in
+// hash path order. The key/val pairs are collected by one walk of the Trie,
+// then sorted; so it is O(n log n) in h.Nentries(), and allocates O(n)
+// memory. Since the Hamt is immutable, it is a consistent snapshot; eg. for
+// reporting the top N key/val pairs by value.
+func (h Hamt) EntriesSortedByValue(less func(a, b interface{}) bool) []key.KeyVal {
+	var kvs = h.KeyVals()
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return less(kvs[i].Val, kvs[j].Val)
+	})
+	return kvs
+}
+
+// FlattenEntries returns all the key/val pairs of the Hamt in hash path
+// order, in one slice allocated up front for Nentries() key/val pairs; the
+// order BuildFromSorted rebuilds a Hamt from in one pass. So a Hamt can be
+// saved and reloaded as one contiguous slice. It returns an error for a Hamt
+// created by NewFolded, whose keys are not in the hash path order of their own
+// Hash60(); or if the Hamt does not hold Nentries() key/val pairs.
+func (h Hamt) FlattenEntries() ([]key.KeyVal, error) {
+	if h.fold != FoldXor {
+		return nil, fmt.Errorf("hamt64: FlattenEntries: the keys of a folded Hamt are not in Hash60() order")
+	}
+
+	var kvs = make([]key.KeyVal, 0, h.nentries)
+	if !h.IsEmpty() {
+		kvs = appendKeyVals(kvs, h.root)
+	}
+	if uint(len(kvs)) != h.nentries {
+		return nil, fmt.Errorf("hamt64: FlattenEntries: %d key/val pairs != Nentries(),%d", len(kvs), h.nentries)
+	}
+
+	return kvs, nil
+}
+
+// EntriesAppend appends all the key/val pairs in the Hamt, in hash path order,
+// to buf and returns the extended slice. buf is only grown, to fit all the
+// key/val pairs, when it lacks the capacity; so reusing buf, eg. from a
+// sync.Pool, avoids allocating on every call.
+func (h Hamt) EntriesAppend(buf []key.KeyVal) []key.KeyVal {
+	if h.IsEmpty() {
+		return buf
+	}
+	if cap(buf)-len(buf) < int(h.nentries) {
+		var nbuf = make([]key.KeyVal, len(buf), len(buf)+int(h.nentries))
+		copy(nbuf, buf)
+		buf = nbuf
+	}
+	return appendKeyVals(buf, h.root)
+}
+
+// KeysAppend appends all the keys in the Hamt, in hash path order, to buf and
+// returns the extended slice. Like EntriesAppend, buf is only grown when it
+// lacks the capacity.
+func (h Hamt) KeysAppend(buf []key.Key) []key.Key {
+	if h.IsEmpty() {
+		return buf
+	}
+	if cap(buf)-len(buf) < int(h.nentries) {
+		var nbuf = make([]key.Key, len(buf), len(buf)+int(h.nentries))
+		copy(nbuf, buf)
+		buf = nbuf
+	}
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		buf = append(buf, kv.Key)
+		return true
+	})
+	return buf
+}
+
+// ValuesAppend appends all the values in the Hamt, in hash path order, to buf
+// and returns the extended slice. Like EntriesAppend, buf is only grown when
+// it lacks the capacity.
+func (h Hamt) ValuesAppend(buf []interface{}) []interface{} {
+	if h.IsEmpty() {
+		return buf
+	}
+	if cap(buf)-len(buf) < int(h.nentries) {
+		var nbuf = make([]interface{}, len(buf), len(buf)+int(h.nentries))
+		copy(nbuf, buf)
+		buf = nbuf
+	}
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		buf = append(buf, kv.Val)
+		return true
+	})
+	return buf
+}
+
+func appendKeyVals(kvs []key.KeyVal, t tableI) []key.KeyVal {
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			kvs = append(kvs, n.keyVals()...)
+		case tableI:
+			kvs = appendKeyVals(kvs, n)
+		}
+	}
+	return kvs
+}
+
+// BuildFromSorted returns a Hamt of the key/val pairs of sorted, which MUST
+// be in hash path order; the order KeyVals() returns them in. The Trie is
+// built bottom-up in one pass over sorted, rather than by a Put, and a
+// descent of the Trie, per key/val pair. Runs of keys with the same Hash60()
+// become collision leafs; if a key is in sorted more than once, the last
+// val is kept. If sorted is not in hash path order, the key/val pairs are
+// Put one at a time instead.
+func BuildFromSorted(sorted []key.KeyVal) Hamt {
+	var h Hamt
+	if len(sorted) == 0 {
+		return h
+	}
+
+	var root, nentries, ok = buildSortedTable(sorted, 0)
+	if !ok {
+		for _, kv := range sorted {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		return h
+	}
+
+	h.root = root
+	h.nentries = nentries
+	return h
+}
+
+// BuildWithProgress returns a Hamt of the key/val pairs of kvs, Put in order.
+// Each time the number of key/val pairs Put so far is a multiple of every, it
+// calls progress with that number; so a long load can report its progress.
+// progress is not called if every is zero. The tables near the root are
+// sized for len(kvs) key/val pairs, like NewSized does; the returned Hamt
+// does not keep that size hint.
+func BuildWithProgress(kvs []key.KeyVal, every uint, progress func(count uint)) Hamt {
+	var h = NewSized(uint(len(kvs)))
+	for i, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+		if count := uint(i + 1); every > 0 && count%every == 0 {
+			progress(count)
+		}
+	}
+	return h.WithSizeHint(0)
+}
+
+// buildSortedTable() returns the table at depth holding kvs, which all share
+// the hash path to it, and the number of key/val pairs in it. It returns
+// false if kvs are not in hash path order.
+func buildSortedTable(kvs []key.KeyVal, depth uint) (tableI, uint, bool) {
+	var ents = make([]tableEntry, 0, TableCapacity)
+	var nentries uint
+
+	for i := 0; i < len(kvs); {
+		var idx = kvs[i].Key.Hash60().Index(depth)
+		if len(ents) > 0 && idx <= ents[len(ents)-1].idx {
+			return nil, 0, false
+		}
+
+		var j = i + 1
+		var sameHash = true
+		for ; j < len(kvs) && kvs[j].Key.Hash60().Index(depth) == idx; j++ {
+			sameHash = sameHash && kvs[j].Key.Hash60() == kvs[i].Key.Hash60()
+		}
+
+		var node nodeI
+		var n uint
+		if sameHash {
+			var leaf = createLeaf(kvs[i].Key, kvs[i].Val)
+			n = 1
+			for _, kv := range kvs[i+1 : j] {
+				var added bool
+				if leaf, added = leaf.put(kv.Key, kv.Val); added {
+					n++
+				}
+			}
+			node = leaf
+		} else {
+			var ok bool
+			if node, n, ok = buildSortedTable(kvs[i:j], depth+1); !ok {
+				return nil, 0, false
+			}
+		}
+
+		ents = append(ents, tableEntry{idx, node})
+		nentries += n
+		i = j
+	}
+
+	var hashPath = kvs[0].Key.Hash60() & (1<<(depth*Nbits) - 1)
+	if FullTableInit || (GradeTables && uint(len(ents)) >= UpgradeThreshold) {
+		return upgradeToFullTable(hashPath, depth, ents), nentries, true
+	}
+	return downgradeToCompressedTable(hashPath, depth, ents), nentries, true
+}
+
+// EntriesByDepth returns all the key/val pairs in the Hamt grouped by the
+// depth of the table their leaf is in. The returned slice is indexed by depth
+// and has MaxDepth+1 entries.
+func (h Hamt) EntriesByDepth() [][]key.KeyVal {
+	var byDepth = make([][]key.KeyVal, MaxDepth+1)
+	if h.IsEmpty() {
+		return byDepth
+	}
+	appendEntriesByDepth(byDepth, h.root, 0)
+	return byDepth
+}
+
+func appendEntriesByDepth(byDepth [][]key.KeyVal, t tableI, depth uint) {
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			byDepth[depth] = append(byDepth[depth], n.keyVals()...)
+		case tableI:
+			appendEntriesByDepth(byDepth, n, depth+1)
+		}
+	}
+}
+
+// ToNestedMap returns the shape of the Trie as nested maps; for tools, like
+// visualizers and debuggers, that cannot see the unexported tables. A table is
+// a map from the index of each of its entries to the entry: a nested map for
+// a table, a key.KeyVal for a leaf of one key/val pair, or a []key.KeyVal for
+// a collision leaf. A run of tables made by Compact() shows as the single
+// entry tables it replaced, and a Hamt small enough to be kept in a linear
+// list shows as the Trie it would be. An empty Hamt returns an empty map.
+func (h Hamt) ToNestedMap() map[uint]interface{} {
+	if h.IsEmpty() {
+		return map[uint]interface{}{}
+	}
+	var root = h.root
+	if lt, isLinear := root.(*linearTable); isLinear {
+		root = lt.trie()
+	}
+	return nestedMap(root)
+}
+
+func nestedMap(t tableI) map[uint]interface{} {
+	var m = make(map[uint]interface{}, t.nentries())
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			var kvs = n.keyVals()
+			if len(kvs) == 1 {
+				m[ent.idx] = kvs[0]
+			} else {
+				m[ent.idx] = kvs
+			}
+		case tableI:
+			m[ent.idx] = nestedMap(n)
+		}
+	}
+	return m
+}
+
+// MaxCollisionSize returns the number of key/val pairs in the biggest leaf of
+// the Hamt. It is 1 if there are no collision leafs, and 0 if the Hamt is
+// empty. A large value points to a bad key distribution or a weak hash.
+func (h Hamt) MaxCollisionSize() uint {
+	if h.IsEmpty() {
+		return 0
+	}
+	return maxLeafSize(h.root)
+}
+
+func maxLeafSize(t tableI) uint {
+	var max uint
+	for _, ent := range t.entries() {
+		var size uint
+		switch n := ent.node.(type) {
+		case leafI:
+			size = uint(len(n.keyVals()))
+		case tableI:
+			size = maxLeafSize(n)
+		}
+		if size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+// FindDuplicates returns every key that is stored more than once in the Hamt.
+// A correctly built Hamt never has duplicates, so this is a corruption check.
+// Each duplicated key is returned once.
+func (h Hamt) FindDuplicates() []key.Key {
+	var dups []key.Key
+	var seen = make(map[key.HashVal60][]key.Key)
+
+KeyIter:
+	for _, kv := range h.KeyVals() {
+		var h60 = kv.Key.Hash60()
+
+		var isDup bool
+		for _, k := range seen[h60] {
+			if k.Equals(kv.Key) {
+				isDup = true
+				break
+			}
+		}
+
+		if !isDup {
+			seen[h60] = append(seen[h60], kv.Key)
+			continue
+		}
+
+		for _, k := range dups {
+			if k.Equals(kv.Key) {
+				continue KeyIter // already reported
+			}
+		}
+		dups = append(dups, kv.Key)
+	}
+
+	return dups
+}
+
+// CheckNentries returns the number of entries the Hamt reports, via
+// h.Nentries(), and the actual number of key/val pairs found by walking the
+// Trie, along with whether they match. It is meant for tests asserting that a
+// sequence of Put and Del calls kept the Hamt consistent.
+func CheckNentries(h Hamt) (reported, actual uint, ok bool) {
+	reported = h.nentries
 	if h.root != nil {
-		str = indent + fmt.Sprintf("Hamt{ nentries: %d, root:\n", h.nentries)
-		str += indent + h.root.LongString(indent+fullIndent, true)
-		str += indent + "}end\n"
-		return str
-	} else {
-		str = indent + fmt.Sprintf("Hamt{ nentries: %d, root: nil }", h.nentries)
+		actual = countKeyVals(h.root)
 	}
-	return str
+	ok = reported == actual
+	return
+}
+
+func countKeyVals(t tableI) uint {
+	var n uint
+	for _, ent := range t.entries() {
+		switch x := ent.node.(type) {
+		case leafI:
+			n += uint(len(x.keyVals()))
+		case tableI:
+			n += countKeyVals(x)
+		}
+	}
+	return n
+}
+
+// Any returns true if pred returns true for any key/val pair in the Hamt. It
+// stops at the first pair pred returns true for. Any is false for an empty
+// Hamt.
+func (h Hamt) Any(pred func(k key.Key, v interface{}) bool) bool {
+	if h.IsEmpty() {
+		return false
+	}
+	var stopped = !walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		return !pred(kv.Key, kv.Val)
+	})
+	return stopped
+}
+
+// All returns true if pred returns true for all key/val pairs in the Hamt. It
+// stops at the first pair pred returns false for. All is true for an empty
+// Hamt.
+func (h Hamt) All(pred func(k key.Key, v interface{}) bool) bool {
+	if h.IsEmpty() {
+		return true
+	}
+	return walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		return pred(kv.Key, kv.Val)
+	})
+}
+
+// walkKeyVals() calls fn for each key/val pair under t, in hash path order,
+// until fn returns false. It returns false if fn stopped the walk.
+func walkKeyVals(t tableI, fn func(kv key.KeyVal) bool) bool {
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			for _, kv := range n.keyVals() {
+				if !fn(kv) {
+					return false
+				}
+			}
+		case tableI:
+			if !walkKeyVals(n, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RangeOrdered calls fn for every key/val pair of the Hamt, until fn returns
+// false. The key/val pairs are visited in ascending order of
+// h.PathHash(k).String(), the hash path of each key rendered by
+// key.HashVal60.String(), compared as strings; keys with the same hash path,
+// ie. the keys of a collision leaf, are visited in no particular order among
+// themselves. The order is the same for hamt32 and hamt64, each by its own
+// hash; so it does not depend on the table types of the Hamt, or on the order
+// the keys were Put.
+func (h Hamt) RangeOrdered(fn func(k key.Key, v interface{}) bool) {
+	if h.IsEmpty() {
+		return
+	}
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		return fn(kv.Key, kv.Val)
+	})
+}
+
+// LongestPrefixLeaf returns a key/val pair of the leaf whose hash path shares
+// the longest prefix with the hash path of k, and the depth of the table
+// holding that leaf. It follows the hash path of k until it reaches a leaf;
+// if it reaches an empty entry instead, it returns the leaf of least depth
+// below the table it stopped in; the first in hash path order, of those. For
+// a collision leaf it returns the first key/val pair of the leaf. The bool is
+// false only for an empty Hamt. It is a query of the structure of the Trie,
+// for finding the neighbors of a key in hash space; k itself need not be in
+// the Hamt.
+func (h Hamt) LongestPrefixLeaf(k key.Key) (key.Key, interface{}, uint, bool) {
+	if h.IsEmpty() {
+		return nil, nil, 0, false
+	}
+
+	var h60 = h.hash60(k)
+	var curTable = h.root
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		switch n := curTable.get(h60.Index(depth)).(type) {
+		case leafI:
+			var kv = n.keyVals()[0]
+			return kv.Key, kv.Val, depth, true
+		case tableI:
+			curTable = n
+			continue
+		}
+		var leaf, leafDepth = shallowestLeaf(curTable, depth)
+		var kv = leaf.keyVals()[0]
+		return kv.Key, kv.Val, leafDepth, true
+	}
+
+	panic("SHOULD NEVER BE REACHED")
+}
+
+// NeighborsOf returns up to n key/val pairs, other than k, whose keys share
+// the longest hash path prefix with k; its neighbors in hash space. It
+// follows the hash path of k down to the deepest table on it, and collects
+// the key/val pairs below that table; then, if there are fewer than n, those
+// below each table above it, in turn. So the key/val pairs are in order of
+// decreasing shared prefix, and in hash path order among those sharing the
+// same prefix. k need not be in the Hamt.
+func (h Hamt) NeighborsOf(k key.Key, n int) []key.KeyVal {
+	if h.IsEmpty() || n <= 0 {
+		return nil
+	}
+
+	var h60 = h.hash60(k)
+	var path = []tableI{h.root}
+	for depth := uint(0); depth < MaxDepth; depth++ {
+		var t, isTable = path[depth].get(h60.Index(depth)).(tableI)
+		if !isTable {
+			break
+		}
+		path = append(path, t)
+	}
+
+	var kvs = make([]key.KeyVal, 0, n)
+	var collect = func(kv key.KeyVal) bool {
+		if !kv.Key.Equals(k) {
+			kvs = append(kvs, kv)
+		}
+		return len(kvs) < n
+	}
+
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		for _, ent := range path[depth].entries() {
+			if depth < len(path)-1 && ent.idx == h60.Index(uint(depth)) {
+				continue // already collected from path[depth+1]
+			}
+			var more = true
+			switch x := ent.node.(type) {
+			case leafI:
+				for _, kv := range x.keyVals() {
+					if more = collect(kv); !more {
+						break
+					}
+				}
+			case tableI:
+				more = walkKeyVals(x, collect)
+			}
+			if !more {
+				return kvs
+			}
+		}
+	}
+	return kvs
+}
+
+// shallowestLeaf() returns the leaf of least depth below t, at depth, and the
+// depth of the table holding it. Tables are searched breadth first, and the
+// entries of each in hash path order.
+func shallowestLeaf(t tableI, depth uint) (leafI, uint) {
+	var tables = []tableI{t}
+	for ; len(tables) > 0; depth++ {
+		var next []tableI
+		for _, t := range tables {
+			for _, ent := range t.entries() {
+				switch n := ent.node.(type) {
+				case leafI:
+					return n, depth
+				case tableI:
+					next = append(next, n)
+				}
+			}
+		}
+		tables = next
+	}
+
+	panic("SHOULD NEVER BE REACHED")
+}
+
+// RangeDeepest calls fn for every key/val pair whose leaf is in a table at
+// MaxDepth, and for every key/val pair of a collision leaf at any depth, in
+// hash path order, until fn returns false. These are the keys that take the
+// longest to reach.
+func (h Hamt) RangeDeepest(fn func(k key.Key, v interface{}) bool) {
+	if h.IsEmpty() {
+		return
+	}
+	rangeDeepest(h.root, 0, fn)
+}
+
+func rangeDeepest(t tableI, depth uint, fn func(k key.Key, v interface{}) bool) bool {
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case leafI:
+			var kvs = n.keyVals()
+			if depth != MaxDepth && len(kvs) == 1 {
+				continue
+			}
+			for _, kv := range kvs {
+				if !fn(kv.Key, kv.Val) {
+					return false
+				}
+			}
+		case tableI:
+			if !rangeDeepest(n, depth+1, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RangeFrom calls fn for the key/val pairs of the Hamt in hash path order,
+// starting at the one cursor leads to, until fn returns false. A nil cursor
+// starts at the first key/val pair. It returns the cursor of the key/val pair
+// after the one fn returned false for; or nil if fn was called for the last
+// key/val pair. Because the Hamt is immutable, a cursor stays valid for every
+// later call on the same Hamt; so a Hamt can be paginated without any state
+// besides the cursor. A cursor is the table indexes of the hash path to a
+// leaf, followed by the position of the key/val pair in that leaf.
+func (h Hamt) RangeFrom(cursor []uint, fn func(k key.Key, v interface{}) bool) []uint {
+	if h.IsEmpty() {
+		return nil
+	}
+	var rf = rangeFrom{fn: fn}
+	rf.table(h.root, cursor)
+	return rf.next
+}
+
+// rangeFrom holds the state of one call of Hamt.RangeFrom().
+type rangeFrom struct {
+	fn      func(k key.Key, v interface{}) bool
+	path    []uint
+	stopped bool
+	next    []uint
+}
+
+// table() ranges over the key/val pairs under t from the one cursor, relative
+// to t, leads to. It returns true once the cursor of the next key/val pair is
+// found.
+func (rf *rangeFrom) table(t tableI, cursor []uint) bool {
+	var start uint
+	if len(cursor) > 0 {
+		start = cursor[0]
+	}
+
+	for _, ent := range t.entries() {
+		if ent.idx < start {
+			continue
+		}
+
+		var sub []uint
+		if ent.idx == start && len(cursor) > 0 {
+			sub = cursor[1:]
+		}
+
+		rf.path = append(rf.path, ent.idx)
+		switch n := ent.node.(type) {
+		case leafI:
+			if rf.leaf(n, sub) {
+				return true
+			}
+		case tableI:
+			if rf.table(n, sub) {
+				return true
+			}
+		}
+		rf.path = rf.path[:len(rf.path)-1]
+	}
+
+	return false
+}
+
+// leaf() is table() for the key/val pairs of a leaf; cursor is the position
+// of the first one.
+func (rf *rangeFrom) leaf(l leafI, cursor []uint) bool {
+	var pos uint
+	if len(cursor) > 0 {
+		pos = cursor[0]
+	}
+
+	var kvs = l.keyVals()
+	for i := pos; i < uint(len(kvs)); i++ {
+		if rf.stopped {
+			rf.next = append(append([]uint(nil), rf.path...), i)
+			return true
+		}
+		if !rf.fn(kvs[i].Key, kvs[i].Val) {
+			rf.stopped = true
+		}
+	}
+
+	return false
+}
+
+// Subtrie returns a Hamt of only the key/val pairs under the node found by
+// following pathIdxs, a sequence of table indexes, down from the root. The
+// returned Hamt shares that node with h; only the tables on the path to it
+// are new, and they hold just the one entry. The bool is false if the path
+// leads to an empty entry, or through a leaf before its last index.
+func (h Hamt) Subtrie(pathIdxs []uint) (Hamt, bool) {
+	if len(pathIdxs) == 0 {
+		return h, true
+	}
+	if h.IsEmpty() || uint(len(pathIdxs)) > MaxDepth+1 {
+		return Hamt{}, false
+	}
+
+	var path = newTableStack()
+	var curTable = h.root
+	var node nodeI
+
+	for i, idx := range pathIdxs {
+		if idx >= TableCapacity {
+			return Hamt{}, false
+		}
+
+		node = curTable.get(idx)
+		if node == nil {
+			return Hamt{}, false
+		}
+		path.push(curTable)
+
+		if i == len(pathIdxs)-1 {
+			break
+		}
+
+		var tab, isTable = node.(tableI)
+		if !isTable {
+			return Hamt{}, false
+		}
+		curTable = tab
+	}
+
+	// nh keeps every option of h
+	var nh = h
+
+	switch n := node.(type) {
+	case leafI:
+		nh.nentries = uint(len(n.keyVals()))
+	case tableI:
+		nh.nentries = countKeyVals(n)
+	}
+
+	for depth := len(pathIdxs) - 1; depth >= 0; depth-- {
+		var tab = path.pop()
+		node = createSingleEntryTable(tab.Hash60(), uint(depth), pathIdxs[depth], node)
+	}
+	nh.root = node.(tableI)
+
+	return nh, true
+}
+
+// createSingleEntryTable() creates a table, of the FullTableInit type, with
+// node as its only entry.
+func createSingleEntryTable(hashPath key.HashVal60, depth uint, idx uint, node nodeI) tableI {
+	var ents = []tableEntry{{idx, node}}
+	if FullTableInit {
+		return upgradeToFullTable(hashPath, depth, ents)
+	}
+	return downgradeToCompressedTable(hashPath, depth, ents)
+}
+
+// GetRef(k) retrieves the value stored for k, exactly as Get(k) does; it
+// exists to document the one supported way of mutating a Hamt's contents in
+// place. Values are stored as given, never copied, so if the stored value is
+// a pointer, the pointed-to data may be modified through the returned value.
+// That change is seen by every Hamt sharing the leaf for k, which is usually
+// every Hamt derived from the one k was Put into; the keys and the structure
+// of the Trie are not changed. Replacing the value itself (rather than the
+// data it points to) still requires Put.
+func (h Hamt) GetRef(k key.Key) (interface{}, bool) {
+	return h.Get(k)
+}
+
+// DelPrefix removes every key/val pair whose key is a *stringkey.StringKey
+// starting with prefix. It returns the new Hamt and the number of key/val
+// pairs removed. The matching keys are collected in one walk of the whole
+// Hamt, then deleted from it; so DelPrefix is O(n) in h.Nentries().
+func (h Hamt) DelPrefix(prefix string) (Hamt, uint) {
+	if h.IsEmpty() {
+		return h, 0
+	}
+
+	var keys []key.Key
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		if sk, ok := UnfoldKey(kv.Key).(*stringkey.StringKey); ok && strings.HasPrefix(sk.Str(), prefix) {
+			keys = append(keys, kv.Key)
+		}
+		return true
+	})
+
+	var nh = h
+	for _, k := range keys {
+		nh, _, _ = nh.Del(k)
+	}
+
+	return nh, uint(len(keys))
+}
+
+// MaxValue returns the key and value of the greatest value in the Hamt, as
+// ordered by less, found in a single walk of the Hamt. If several values are
+// equally greatest, which of their keys is returned is unspecified. The bool
+// is false if the Hamt is empty.
+func (h Hamt) MaxValue(less func(a, b interface{}) bool) (key.Key, interface{}, bool) {
+	return h.extremeValue(func(a, b interface{}) bool { return less(b, a) })
+}
+
+// MinValue returns the key and value of the least value in the Hamt, as
+// ordered by less; otherwise it is the same as MaxValue.
+func (h Hamt) MinValue(less func(a, b interface{}) bool) (key.Key, interface{}, bool) {
+	return h.extremeValue(less)
+}
+
+// extremeValue() returns the key/val pair whose val is first as ordered by
+// before.
+func (h Hamt) extremeValue(before func(a, b interface{}) bool) (key.Key, interface{}, bool) {
+	if h.IsEmpty() {
+		return nil, nil, false
+	}
+
+	var best key.KeyVal
+	var found bool
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		if !found || before(kv.Val, best.Val) {
+			best, found = kv, true
+		}
+		return true
+	})
+
+	return best.Key, best.Val, found
+}
+
+// MergeSumInts returns a Hamt with every key/val pair of h and other; where
+// a key is in both, its val is the sum of the two vals. The key/val pairs of
+// the smaller Hamt are merged into the larger one by PutMerge; one descent of
+// the Trie per key/val pair. The vals of a key in both Hamts must both be
+// ints; otherwise MergeSumInts panics with the runtime error of the failed
+// type assertion. The val of a key in only one of the Hamts is kept as it is,
+// whatever its type.
+func (h Hamt) MergeSumInts(other Hamt) Hamt {
+	var nh, from = h, other
+	if other.Nentries() > h.Nentries() {
+		nh, from = other, h
+	}
+
+	if from.IsEmpty() {
+		return nh
+	}
+
+	var sum = func(old, new interface{}) interface{} {
+		return old.(int) + new.(int)
+	}
+	walkKeyVals(from.root, func(kv key.KeyVal) bool {
+		nh, _ = nh.PutMerge(kv.Key, kv.Val, sum)
+		return true
+	})
+
+	return nh
+}
+
+// MergeLWW returns a Hamt with every key/val pair of h and other; where a key
+// is in both, its val is the one with the greater timestampOf(val), ie. the
+// last write wins. On equal timestamps the val of h is kept; so as long as no
+// two different vals of a key have the same timestamp, merging any number of
+// Hamts gives the same Hamt in any order and grouping. The key/val pairs of
+// the smaller Hamt are Put into the larger one.
+func (h Hamt) MergeLWW(other Hamt, timestampOf func(v interface{}) int64) Hamt {
+	var nh, from = h, other
+	var fromIsH bool
+	if other.Nentries() > h.Nentries() {
+		nh, from = other, h
+		fromIsH = true
+	}
+
+	if from.IsEmpty() {
+		return nh
+	}
+
+	walkKeyVals(from.root, func(kv key.KeyVal) bool {
+		if val, found := nh.Get(kv.Key); found {
+			var ts, fromTs = timestampOf(val), timestampOf(kv.Val)
+			if fromTs < ts || (fromTs == ts && !fromIsH) {
+				return true
+			}
+		}
+		nh, _ = nh.Put(kv.Key, kv.Val)
+		return true
+	})
+
+	return nh
+}
+
+// ShareRoot returns whether a and b have the same root table; ie. whether
+// they are the same version of a Hamt. Empty Hamts share no root.
+func ShareRoot(a, b Hamt) bool {
+	return a.root != nil && a.root == b.root
+}
+
+// ShareAnyNode returns whether a and b share any table or leaf; as they do
+// when one was derived from the other, or both from a common Hamt, and not
+// every node along the way was replaced.
+func ShareAnyNode(a, b Hamt) bool {
+	if a.IsEmpty() || b.IsEmpty() {
+		return false
+	}
+
+	var nodes = make(map[nodeI]struct{})
+	collectNodes(a.root, nodes)
+
+	return findSharedNode(b.root, nodes)
+}
+
+// collectNodes() adds t and every node below it to nodes.
+func collectNodes(t tableI, nodes map[nodeI]struct{}) {
+	nodes[t] = struct{}{}
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			collectNodes(subTable, nodes)
+		} else {
+			nodes[ent.node] = struct{}{}
+		}
+	}
+}
+
+// findSharedNode() returns whether t or any node below it is in nodes.
+func findSharedNode(t tableI, nodes map[nodeI]struct{}) bool {
+	if _, found := nodes[t]; found {
+		return true
+	}
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			if findSharedNode(subTable, nodes) {
+				return true
+			}
+		} else if _, found := nodes[ent.node]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstDiff returns a key that is in only one of h and other, or is in both
+// with values that valEq reports as unequal; and whether there is any such
+// key. Subtrees that h and other share are skipped without being walked; so
+// comparing two versions of a Hamt costs about the size of their changes.
+func (h Hamt) FirstDiff(other Hamt, valEq func(a, b interface{}) bool) (key.Key, bool) {
+	switch {
+	case h.IsEmpty() && other.IsEmpty():
+		return nil, false
+	case h.IsEmpty():
+		return firstKey(other.root), true
+	case other.IsEmpty():
+		return firstKey(h.root), true
+	}
+	return firstDiffTables(h.root, other.root, valEq)
+}
+
+// Equals returns whether h and other hold the same key/val pairs; values are
+// compared with ==, so they must be comparable. The order of the key/val pairs
+// within a collision leaf depends on the order they were Put in, so collision
+// leafs are compared as sets; as is any subtree whose structure differs. Like
+// FirstDiff, subtrees h and other share are not walked.
+func (h Hamt) Equals(other Hamt) bool {
+	if h.nentries != other.nentries {
+		return false
+	}
+	var _, diff = h.FirstDiff(other, func(a, b interface{}) bool { return a == b })
+	return !diff
+}
+
+// ContentHash returns a SHA-256 hash of the key/val pairs of the Hamt; so
+// Hamts of the same key/val pairs have the same ContentHash, whatever order
+// they were Put in and whatever tables hold them, and any other change of the
+// key/val pairs changes it. The key/val pairs are hashed sorted by the bytes
+// of their keys, each as its key type, its key bytes, and valHash(val). Keys
+// must be *stringkey.StringKey, or stored by PutRaw; other keys are hashed
+// by their String().
+func (h Hamt) ContentHash(valHash func(v interface{}) uint64) [32]byte {
+	type keyHash struct {
+		kind byte
+		key  []byte
+		val  uint64
+	}
+	var khs = make([]keyHash, 0, h.nentries)
+	if !h.IsEmpty() {
+		walkKeyVals(h.root, func(kv key.KeyVal) bool {
+			var kh = keyHash{val: valHash(kv.Val)}
+			switch k := UnfoldKey(kv.Key).(type) {
+			case *stringkey.StringKey:
+				kh.kind, kh.key = 's', []byte(k.Str())
+			case *rawKey:
+				kh.kind, kh.key = 'r', k.bytes
+			default:
+				kh.kind, kh.key = 'k', []byte(k.String())
+			}
+			khs = append(khs, kh)
+			return true
+		})
+	}
+	sort.Slice(khs, func(i, j int) bool {
+		if c := bytes.Compare(khs[i].key, khs[j].key); c != 0 {
+			return c < 0
+		}
+		return khs[i].kind < khs[j].kind
+	})
+
+	var sum = sha256.New()
+	var buf [8]byte
+	for _, kh := range khs {
+		sum.Write([]byte{kh.kind})
+		binary.BigEndian.PutUint64(buf[:], uint64(len(kh.key)))
+		sum.Write(buf[:])
+		sum.Write(kh.key)
+		binary.BigEndian.PutUint64(buf[:], kh.val)
+		sum.Write(buf[:])
+	}
+
+	var hash [32]byte
+	sum.Sum(hash[:0])
+	return hash
+}
+
+// diffTables() walks a and b, two tables at the same hash path, in step; the
+// subtrees they share are skipped. fn is called for every pair of nodes at the
+// same index that differ, except when both are tables, which are walked in
+// turn; a node at an index of only one of a and b is paired with nil. It
+// returns false if fn stopped the walk.
+func diffTables(a, b tableI, fn func(an, bn nodeI) bool) bool {
+	if a == b {
+		return true
+	}
+
+	var aEnts, bEnts = a.entries(), b.entries()
+	var i, j int
+	for i < len(aEnts) || j < len(bEnts) {
+		var an, bn nodeI
+		switch {
+		case j == len(bEnts) || (i < len(aEnts) && aEnts[i].idx < bEnts[j].idx):
+			an = aEnts[i].node
+			i++
+		case i == len(aEnts) || bEnts[j].idx < aEnts[i].idx:
+			bn = bEnts[j].node
+			j++
+		default:
+			an, bn = aEnts[i].node, bEnts[j].node
+			i++
+			j++
+			if an == bn {
+				continue
+			}
+
+			var at, aIsTable = an.(tableI)
+			var bt, bIsTable = bn.(tableI)
+			if aIsTable && bIsTable {
+				if !diffTables(at, bt, fn) {
+					return false
+				}
+				continue
+			}
+		}
+
+		if !fn(an, bn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// firstDiffTables() is FirstDiff for two tables at the same hash path.
+func firstDiffTables(a, b tableI, valEq func(a, b interface{}) bool) (key.Key, bool) {
+	var k key.Key
+	var diff bool
+	diffTables(a, b, func(an, bn nodeI) bool {
+		switch {
+		case an == nil:
+			k, diff = firstKey(bn), true
+		case bn == nil:
+			k, diff = firstKey(an), true
+		default:
+			k, diff = firstDiffKeyVals(nodeKeyVals(an), nodeKeyVals(bn), valEq)
+		}
+		return !diff
+	})
+	return k, diff
+}
+
+// firstDiffKeyVals() is FirstDiff for the key/val pairs of two nodes at the
+// same hash path, where at least one of them is a leaf; hence they are few.
+func firstDiffKeyVals(akvs, bkvs []key.KeyVal, valEq func(a, b interface{}) bool) (key.Key, bool) {
+	for _, akv := range akvs {
+		var found bool
+		for _, bkv := range bkvs {
+			if akv.Key.Equals(bkv.Key) {
+				if !valEq(akv.Val, bkv.Val) {
+					return akv.Key, true
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return akv.Key, true
+		}
+	}
+	if len(akvs) != len(bkvs) {
+		for _, bkv := range bkvs {
+			var found bool
+			for _, akv := range akvs {
+				if bkv.Key.Equals(akv.Key) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return bkv.Key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// nodeKeyVals() returns the key/val pairs of a leaf, or of every leaf under a
+// table; none for a nil node.
+func nodeKeyVals(n nodeI) []key.KeyVal {
+	switch x := n.(type) {
+	case tableI:
+		return appendKeyVals(nil, x)
+	case leafI:
+		return x.keyVals()
+	}
+	return nil
+}
+
+// firstKey() returns the first key, in hash path order, under n.
+func firstKey(n nodeI) key.Key {
+	for {
+		switch x := n.(type) {
+		case leafI:
+			return x.keyVals()[0].Key
+		case tableI:
+			n = x.entries()[0].node
+		}
+	}
+}
+
+// Audit returns the number of keys added to, removed from, and with a changed
+// val in h, compared to prev; eg. a previous version of h. Values are compared
+// with ==, so they must be comparable. Like FirstDiff, subtrees h and prev
+// share are not walked; so auditing two versions of a large Hamt costs about
+// the size of their changes.
+func (h Hamt) Audit(prev Hamt) (added, removed, changed uint) {
+	switch {
+	case h.IsEmpty() && prev.IsEmpty():
+		return 0, 0, 0
+	case h.IsEmpty():
+		return 0, prev.nentries, 0
+	case prev.IsEmpty():
+		return h.nentries, 0, 0
+	}
+
+	diffTables(h.root, prev.root, func(cn, pn nodeI) bool {
+		diffKeyVals(nodeKeyVals(cn), nodeKeyVals(pn), func(ckv, pkv *key.KeyVal) {
+			switch {
+			case pkv == nil:
+				added++
+			case ckv == nil:
+				removed++
+			default:
+				changed++
+			}
+		})
+		return true
+	})
+	return added, removed, changed
+}
+
+// Diff returns the key/val pairs added to h, and removed from h, compared to
+// prev; eg. a previous version of h; and the key/val pairs of h whose val
+// changed. Values are compared with ==, so they must be comparable. Like
+// Audit, subtrees h and prev share are not walked. The key/val pairs are in
+// hash path order.
+func (h Hamt) Diff(prev Hamt) (added, removed, changed []key.KeyVal) {
+	switch {
+	case h.IsEmpty() && prev.IsEmpty():
+		return nil, nil, nil
+	case h.IsEmpty():
+		return nil, prev.KeyVals(), nil
+	case prev.IsEmpty():
+		return h.KeyVals(), nil, nil
+	}
+
+	diffTables(h.root, prev.root, func(cn, pn nodeI) bool {
+		diffKeyVals(nodeKeyVals(cn), nodeKeyVals(pn), func(ckv, pkv *key.KeyVal) {
+			switch {
+			case pkv == nil:
+				added = append(added, *ckv)
+			case ckv == nil:
+				removed = append(removed, *pkv)
+			default:
+				changed = append(changed, *ckv)
+			}
+		})
+		return true
+	})
+	return added, removed, changed
+}
+
+// ChangedVsMap returns the keys of the Hamt whose val differs from the val
+// of the same string in baseline, or which are not in baseline; sorted. It is
+// meant for reconciling a Hamt of *stringkey.StringKey keys with a map kept
+// elsewhere; other keys are skipped, as are keys only in baseline. Values are
+// compared with ==, so they must be comparable. It returns an empty slice if
+// the Hamt is in sync with baseline.
+func (h Hamt) ChangedVsMap(baseline map[string]interface{}) []string {
+	var changed = []string{}
+	if h.IsEmpty() {
+		return changed
+	}
+	walkKeyVals(h.root, func(kv key.KeyVal) bool {
+		var sk, isStr = UnfoldKey(kv.Key).(*stringkey.StringKey)
+		if !isStr {
+			return true
+		}
+		if val, found := baseline[sk.Str()]; !found || val != kv.Val {
+			changed = append(changed, sk.Str())
+		}
+		return true
+	})
+	sort.Strings(changed)
+	return changed
+}
+
+// diffKeyVals() calls fn for every key of ckvs and pkvs, the key/val pairs of
+// two nodes at the same hash path, that is in only one of them, or is in both
+// with vals that are not ==; with its key/val pair in each, nil for the one it
+// is not in.
+func diffKeyVals(ckvs, pkvs []key.KeyVal, fn func(ckv, pkv *key.KeyVal)) {
+	for i := range ckvs {
+		var found bool
+		for j := range pkvs {
+			if ckvs[i].Key.Equals(pkvs[j].Key) {
+				if ckvs[i].Val != pkvs[j].Val {
+					fn(&ckvs[i], &pkvs[j])
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			fn(&ckvs[i], nil)
+		}
+	}
+	for j := range pkvs {
+		if !KeyVals(ckvs).contains(pkvs[j].Key) {
+			fn(nil, &pkvs[j])
+		}
+	}
+}
+
+// SymmetricDifference returns a Hamt with the key/val pairs whose key is in
+// exactly one of h and other, with the val it has there. Subtrees that h and
+// other share hold no such keys, and are skipped without being walked. The
+// returned Hamt keeps the options of h.
+func (h Hamt) SymmetricDifference(other Hamt) Hamt {
+	if other.IsEmpty() {
+		return h
+	}
+
+	var kvs []key.KeyVal
+	if h.IsEmpty() {
+		kvs = appendKeyVals(nil, other.root)
+	} else {
+		kvs = symDiffTables(nil, h.root, other.root)
+	}
+
+	var nh = h.Clear()
+	for _, kv := range kvs {
+		nh, _ = nh.Put(kv.Key, kv.Val)
+	}
+	return nh
+}
+
+// symDiffTables() appends the key/val pairs of SymmetricDifference for two
+// tables at the same hash path to kvs.
+func symDiffTables(kvs []key.KeyVal, a, b tableI) []key.KeyVal {
+	diffTables(a, b, func(an, bn nodeI) bool {
+		var akvs, bkvs = KeyVals(nodeKeyVals(an)), KeyVals(nodeKeyVals(bn))
+		for _, kv := range akvs {
+			if !bkvs.contains(kv.Key) {
+				kvs = append(kvs, kv)
+			}
+		}
+		for _, kv := range bkvs {
+			if !akvs.contains(kv.Key) {
+				kvs = append(kvs, kv)
+			}
+		}
+		return true
+	})
+	return kvs
+}
+
+// Compact returns a Hamt with the same key/val pairs, where every run of
+// single entry tables below the root, whose only entry is the next table of
+// the run, is collapsed into one path-compressed table. Such runs are created
+// for keys sharing a long hash path prefix; in a sparse, deep Trie compacting
+// saves tables and Get() skips a whole run in one step. A Hamt derived from a
+// compacted Hamt keeps the runs compacted, unless a Put() lands in the middle
+// of one; new runs are not compacted until Compact() is called again.
+func (h Hamt) Compact() Hamt {
+	if h.IsEmpty() {
+		return h
+	}
+
+	var nh = h
+	nh.root = compactTable(h.root, 0)
+	return nh
+}
+
+// compactTable() returns t, at depth, with every run of single entry tables
+// below it compacted.
+func compactTable(t tableI, depth uint) tableI {
+	var nt = t
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			var newSub = compactRun(subTable, depth+1)
+			if newSub != subTable {
+				nt = nt.replace(ent.idx, newSub)
+			}
+		}
+	}
+	return nt
+}
+
+// compactRun() returns t, at depth, as a chainTable if t starts a run of
+// single entry tables; compacting the tables below the run either way.
+func compactRun(t tableI, depth uint) tableI {
+	var idxs []uint
+	var cur = t
+
+	for {
+		if ct, isChain := cur.(*chainTable); isChain {
+			idxs = append(idxs, ct.idxs...)
+			cur = ct.child
+			continue
+		}
+		if cur.nentries() != 1 {
+			break
+		}
+		var ent = cur.entries()[0]
+		var next, isTable = ent.node.(tableI)
+		if !isTable {
+			break
+		}
+		idxs = append(idxs, ent.idx)
+		cur = next
+	}
+
+	var child = compactTable(cur, depth+uint(len(idxs)))
+
+	if len(idxs) == 0 {
+		return child
+	}
+
+	if ct, isChain := t.(*chainTable); isChain && child == ct.child && len(idxs) == len(ct.idxs) {
+		return t // already compacted
+	}
+
+	var ct = new(chainTable)
+	ct.hashPath = t.Hash60()
+	ct.depth = depth
+	ct.idxs = idxs
+	ct.child = child
+	return ct
+}
+
+// NumTables returns the number of tables in the Hamt; a run of single entry
+// tables compacted by Compact() counts as one table, and so does the root of a
+// Hamt of no more than LinearThreshold key/val pairs.
+func (h Hamt) NumTables() uint {
+	if h.IsEmpty() {
+		return 0
+	}
+	return numTables(h.root)
+}
+
+func numTables(t tableI) uint {
+	switch x := t.(type) {
+	case *chainTable:
+		return 1 + numTables(x.child)
+	case *linearTable:
+		return 1
+	}
+
+	var n uint = 1
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			n += numTables(subTable)
+		}
+	}
+	return n
+}
+
+// TableComposition returns the number of fullTables and of compressedTables in
+// the Hamt; eg. to confirm that GradeTables and FullTableInit took effect. The
+// run of tables of a chainTable, and the root of a Hamt of no more than
+// LinearThreshold key/val pairs, are not counted as either; see NumTables.
+func (h Hamt) TableComposition() (full, compressed uint) {
+	if h.IsEmpty() {
+		return 0, 0
+	}
+	return tableComposition(h.root)
+}
+
+func tableComposition(t tableI) (full, compressed uint) {
+	switch x := t.(type) {
+	case *chainTable:
+		return tableComposition(x.child)
+	case *linearTable:
+		return 0, 0
+	case *fullTable:
+		full++
+	case *compressedTable:
+		compressed++
+	}
+
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			var f, c = tableComposition(subTable)
+			full += f
+			compressed += c
+		}
+	}
+	return full, compressed
+}
+
+// BatchStream returns a channel on which a goroutine sends every key/val pair
+// of the Hamt, in slices of batchSize key/val pairs; the last slice may be
+// shorter. The channel is closed after the last slice. The channel is not
+// buffered, so the walk only runs ahead of the receiver by one slice; the
+// receiver must drain the channel, otherwise the goroutine is never done.
+// A batchSize less than 1 is treated as 1.
+func (h Hamt) BatchStream(batchSize int) <-chan []key.KeyVal {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	var ch = make(chan []key.KeyVal)
+
+	go func() {
+		defer close(ch)
+
+		if h.IsEmpty() {
+			return
+		}
+
+		var batch = make([]key.KeyVal, 0, batchSize)
+		walkKeyVals(h.root, func(kv key.KeyVal) bool {
+			batch = append(batch, kv)
+			if len(batch) == batchSize {
+				ch <- batch
+				batch = make([]key.KeyVal, 0, batchSize)
+			}
+			return true
+		})
+
+		if len(batch) > 0 {
+			ch <- batch
+		}
+	}()
+
+	return ch
+}
+
+// Repair returns h without any empty tables. Put and Del never leave an
+// empty table in the Trie, but DecodeStructure may read them from a malformed
+// encoding. A table left empty by pruning the empty tables below it is pruned
+// too; if that leaves the root empty, the returned Hamt is empty.
+func (h Hamt) Repair() Hamt {
+	if h.root == nil {
+		return h
+	}
+
+	var nh = h
+	nh.root = pruneEmptyTables(h.root)
+	return nh
+}
+
+// pruneEmptyTables() returns t without the empty tables below it, or nil if
+// t is, or is left, empty.
+func pruneEmptyTables(t tableI) tableI {
+	var nt = t
+	for _, ent := range t.entries() {
+		var subTable, isTable = ent.node.(tableI)
+		if !isTable {
+			continue
+		}
+
+		var newSub = pruneEmptyTables(subTable)
+		if newSub == nil {
+			nt = nt.remove(ent.idx)
+			if nt == nil {
+				return nil
+			}
+		} else if newSub != subTable {
+			nt = nt.replace(ent.idx, newSub)
+		}
+	}
+
+	if nt.nentries() == 0 {
+		return nil
+	}
+	return nt
+}
+
+// Validate returns an error for the first problem found in the structure of
+// h; either an empty table, a compressedTable whose nodeMap does not match
+// its nodes, or a Nentries() that is not the number of key/val pairs in the
+// Trie. Validate returns nil for a valid Hamt. See Repair.
+func (h Hamt) Validate() error {
+	if h.root != nil {
+		if t := findEmptyTable(h.root); t != nil {
+			return fmt.Errorf("hamt64: empty table %s", t)
+		}
+		if err := checkTables(h.root); err != nil {
+			return fmt.Errorf("hamt64: %s", err)
+		}
+	}
+
+	if reported, actual, ok := CheckNentries(h); !ok {
+		return fmt.Errorf("hamt64: Nentries(),%d != %d key/val pairs", reported, actual)
+	}
+
+	return nil
+}
+
+// checkTables() returns an error for the first table at or below t whose
+// own invariant does not hold; see compressedTable.checkInvariant().
+func checkTables(t tableI) error {
+	if ct, isCompressed := t.(*compressedTable); isCompressed {
+		if err := ct.checkInvariant(); err != nil {
+			return fmt.Errorf("%s: %s", ct, err)
+		}
+	}
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			if err := checkTables(subTable); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findEmptyTable() returns the first empty table at or below t, or nil.
+func findEmptyTable(t tableI) tableI {
+	if t.nentries() == 0 {
+		return t
+	}
+	for _, ent := range t.entries() {
+		if subTable, isTable := ent.node.(tableI); isTable {
+			if et := findEmptyTable(subTable); et != nil {
+				return et
+			}
+		}
+	}
+	return nil
+}
+
+// Clear returns an empty Hamt. Like every Hamt derived from h, it keeps the
+// options h was created with by NewSized, NewStrict or NewFolded, and its
+// OnGrade hook, SkipEqualPuts comparator, GradeHysteresis,
+// AdaptiveThreshold, Metrics, Get cache, and SuggestBaseline; for a Hamt with
+// none of them, Clear returns Hamt{}.
+func (h Hamt) Clear() Hamt {
+	return Hamt{sizeHint: h.sizeHint, strict: h.strict, fold: h.fold, onGrade: h.onGrade, putEq: h.putEq, hysteresis: h.hysteresis, adaptive: h.adaptive, metrics: h.metrics, getCache: h.getCache, suggestBase: h.suggestBase}
+}
+
+// CountInHashRange returns the number of key/val pairs whose key's Hash60()
+// is within [lo, hi]; the key/val pairs RangeHashRange visits, counted by
+// the same walk.
+func (h Hamt) CountInHashRange(lo, hi uint64) uint {
+	var n uint
+	h.RangeHashRange(lo, hi, func(k key.Key, v interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// hashPathInRange() returns whether any hash whose lowest depth*Nbits bits
+// are hashPath is within [lo, hi]; ie. whether the smallest such hash >= lo
+// is <= hi.
+func hashPathInRange(hashPath uint64, depth uint, lo, hi uint64) bool {
+	var mask = uint64(1)<<(depth*Nbits) - 1
+	var first = lo + (hashPath-lo)&mask
+	return first <= hi
+}
+
+// RangeHashRange calls fn for every key/val pair whose key's Hash60() is
+// within [lo, hi], in hash path order, until fn returns false. Tables whose
+// hash path puts every hash below them outside of [lo, hi] are not walked; so
+// adjacent ranges split the key/val pairs of a Hamt into windows, or shards,
+// each visiting only its own part of the Trie.
+func (h Hamt) RangeHashRange(lo, hi uint64, fn func(k key.Key, v interface{}) bool) {
+	if h.IsEmpty() || lo > hi {
+		return
+	}
+	rangeHashRange(h.root, 0, lo, hi, fn)
+}
+
+// rangeHashRange() returns false if fn stopped the walk.
+func rangeHashRange(t tableI, depth uint, lo, hi uint64, fn func(k key.Key, v interface{}) bool) bool {
+	for _, ent := range t.entries() {
+		switch x := ent.node.(type) {
+		case leafI:
+			if h60 := uint64(x.Hash60()); h60 < lo || hi < h60 {
+				continue
+			}
+			for _, kv := range x.keyVals() {
+				if !fn(kv.Key, kv.Val) {
+					return false
+				}
+			}
+		case tableI:
+			if !hashPathInRange(uint64(x.Hash60()), depth+1, lo, hi) {
+				continue
+			}
+			if !rangeHashRange(x, depth+1, lo, hi, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WalkWithContext calls fn for every node in the Hamt, tables and leafs, in
+// hash path order. The depth is the depth of the table holding the node, and
+// siblings is the number of entries in that table. If fn returns false the
+// walk is stopped.
+func (h Hamt) WalkWithContext(fn func(depth uint, siblings uint, isLeaf bool) bool) {
+	if h.IsEmpty() {
+		return
+	}
+	walkTableWithContext(h.root, 0, fn)
+}
+
+// walkTableWithContext returns false if fn stopped the walk.
+func walkTableWithContext(t tableI, depth uint, fn func(depth uint, siblings uint, isLeaf bool) bool) bool {
+	var siblings = t.nentries()
+
+	for _, ent := range t.entries() {
+		var tab, isTable = ent.node.(tableI)
+
+		if !fn(depth, siblings, !isTable) {
+			return false
+		}
+
+		if isTable && !walkTableWithContext(tab, depth+1, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h Hamt) String() string {
+	return fmt.Sprintf("Hamt{ nentries: %d, root: %s }", h.nentries, h.root)
+}
+
+const halfIndent = "  "
+const fullIndent = "    "
+
+func (h Hamt) LongString(indent string) string {
+	var str string
+	if h.root != nil {
+		str = indent + fmt.Sprintf("Hamt{ nentries: %d, root:\n", h.nentries)
+		str += indent + h.root.LongString(indent+fullIndent, true)
+		str += indent + "}end\n"
+		return str
+	} else {
+		str = indent + fmt.Sprintf("Hamt{ nentries: %d, root: nil }", h.nentries)
+	}
+	return str
+}
+
+// LongStringN is a LongString that renders at most maxNodes nodes. The nodes
+// are visited breadth first, so the top of the Trie is shown first. One line
+// is rendered per node, prefixed by its depth. If nodes are left out a
+// truncation notice is appended.
+func (h Hamt) LongStringN(indent string, maxNodes int) string {
+	if h.root == nil {
+		return indent + fmt.Sprintf("Hamt{ nentries: %d, root: nil }", h.nentries)
+	}
+
+	type queued struct {
+		node  nodeI
+		depth uint
+	}
+
+	var strs = []string{indent + fmt.Sprintf("Hamt{ nentries: %d, root:", h.nentries)}
+
+	var queue = []queued{{h.root, 0}}
+	var n int
+	for len(queue) > 0 && n < maxNodes {
+		var q = queue[0]
+		queue = queue[1:]
+
+		strs = append(strs, indent+halfIndent+fmt.Sprintf("[%d] %s", q.depth, q.node))
+		n++
+
+		if t, isTable := q.node.(tableI); isTable {
+			for _, ent := range t.entries() {
+				queue = append(queue, queued{ent.node, q.depth + 1})
+			}
+		}
+	}
+
+	if len(queue) > 0 {
+		strs = append(strs, indent+halfIndent+fmt.Sprintf("... truncated after %d nodes", n))
+	}
+
+	strs = append(strs, indent+"}end")
+
+	return strings.Join(strs, "\n")
 }
@@ -0,0 +1,259 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// linearTable is the root of a Hamt of no more than LinearThreshold key/val
+// pairs; its leafs in a slice, sorted by their Hash60(), that is searched
+// linearly. So a small Hamt has no tables to allocate or to chase pointers
+// through. Hamt.Put() replaces it by a Trie, once the Hamt grows past
+// LinearThreshold key/val pairs.
+//
+// As a tableI a linearTable behaves like the root table of the Trie holding
+// its leafs; so code walking the Trie one table at a time still works. Its
+// methods work on the leafs directly; only the leafs that share an index are
+// built into a table, when that index is read. Hamt.Get(), Hamt.Put(), and
+// Hamt.Del() use the leafs directly.
+type linearTable struct {
+	leafs []leafI
+}
+
+func createLinearTable(leaf leafI) *linearTable {
+	return &linearTable{leafs: []leafI{leaf}}
+}
+
+// Hash60() is required for nodeI
+func (t linearTable) Hash60() key.HashVal60 {
+	return 0
+}
+
+// String() is required for nodeI
+func (t linearTable) String() string {
+	return fmt.Sprintf("linearTable{nleafs=%d}", len(t.leafs))
+}
+
+// LongString() is required for tableI
+func (t linearTable) LongString(indent string, recurse bool) string {
+	var strs = make([]string, 2+len(t.leafs))
+
+	strs[0] = indent + fmt.Sprintf("linearTable{nleafs=%d,", len(t.leafs))
+
+	for i, l := range t.leafs {
+		strs[1+i] = indent + fmt.Sprintf(halfIndent+"t.leafs[%d]: %s", i, l.String())
+	}
+
+	strs[len(strs)-1] = indent + "}"
+
+	return strings.Join(strs, "\n")
+}
+
+// find() returns the position in t.leafs of the leaf for h60, and whether
+// there is one; if not, the position a leaf for h60 belongs at.
+func (t linearTable) find(h60 key.HashVal60) (int, bool) {
+	for i, l := range t.leafs {
+		var lh60 = l.Hash60()
+		if lh60 >= h60 {
+			return i, lh60 == h60
+		}
+	}
+	return len(t.leafs), false
+}
+
+// lookup() is Hamt.GetH() for a linearTable root.
+func (t linearTable) lookup(k key.Key, h60 key.HashVal60) (interface{}, bool) {
+	if i, found := t.find(h60); found {
+		return t.leafs[i].get(k)
+	}
+	return nil, false
+}
+
+// lookupStr() is Hamt.GetStr() for a linearTable root.
+func (t linearTable) lookupStr(s string, h60 key.HashVal60) (interface{}, bool) {
+	if i, found := t.find(h60); found {
+		return t.leafs[i].getStr(s)
+	}
+	return nil, false
+}
+
+// put() returns a new linearTable with k stored with v, and whether k was
+// added.
+func (t linearTable) put(k key.Key, h60 key.HashVal60, v interface{}) (*linearTable, bool) {
+	var i, found = t.find(h60)
+
+	var nt = new(linearTable)
+	if found {
+		var newLeaf, added = t.leafs[i].put(k, v)
+		nt.leafs = make([]leafI, len(t.leafs))
+		copy(nt.leafs, t.leafs)
+		nt.leafs[i] = newLeaf
+		return nt, added
+	}
+
+	nt.leafs = make([]leafI, len(t.leafs)+1)
+	copy(nt.leafs, t.leafs[:i])
+	nt.leafs[i] = createLeaf(k, v)
+	copy(nt.leafs[i+1:], t.leafs[i:])
+	return nt, true
+}
+
+// del() returns a new linearTable without k, or nil if k was its last key,
+// the value of k, and whether k was deleted.
+func (t linearTable) del(k key.Key, h60 key.HashVal60) (*linearTable, interface{}, bool) {
+	var i, found = t.find(h60)
+	if !found {
+		return nil, nil, false
+	}
+
+	var newLeaf, val, deleted = t.leafs[i].del(k)
+	if !deleted {
+		return nil, nil, false
+	}
+
+	if newLeaf != nil {
+		var nt = new(linearTable)
+		nt.leafs = make([]leafI, len(t.leafs))
+		copy(nt.leafs, t.leafs)
+		nt.leafs[i] = newLeaf
+		return nt, val, true
+	}
+
+	if len(t.leafs) == 1 {
+		return nil, val, true
+	}
+
+	var nt = new(linearTable)
+	nt.leafs = make([]leafI, len(t.leafs)-1)
+	copy(nt.leafs, t.leafs[:i])
+	copy(nt.leafs[i:], t.leafs[i+1:])
+	return nt, val, true
+}
+
+// trie() returns the root table of the Trie holding the leafs of t.
+func (t linearTable) trie() tableI {
+	return t.trieRoot(FullTableInit)
+}
+
+// trieRoot() is trie() with a root fullTable if full; for a Hamt whose size
+// hint expects the root to be upgraded anyway.
+func (t linearTable) trieRoot(full bool) tableI {
+	var root = createRootTable(full, t.leafs[0])
+	for _, l := range t.leafs[1:] {
+		root = insertLeaf(root, 0, l)
+	}
+	return root
+}
+
+// insertLeaf() returns a copy of the table t, at depth, with l inserted below
+// it. No leaf below t has the Hash60() of l.
+func insertLeaf(t tableI, depth uint, l leafI) tableI {
+	var idx = l.Hash60().Index(depth)
+	switch n := t.get(idx).(type) {
+	case tableI:
+		return t.replace(idx, insertLeaf(n, depth+1, l))
+	case leafI:
+		return t.replace(idx, createTable(FullTableInit, depth+1, n, l))
+	}
+	return t.insert(idx, l)
+}
+
+// nodeMap() returns the bitmap of the indexes, at depth 0, of the leafs of t.
+func (t linearTable) nodeMap() uint64 {
+	var nodeMap uint64
+	for _, l := range t.leafs {
+		nodeMap |= 1 << l.Hash60().Index(0)
+	}
+	return nodeMap
+}
+
+func (t linearTable) nentries() uint {
+	return bitCount64(t.nodeMap())
+}
+
+func (t linearTable) entries() []tableEntry {
+	return t.entriesInto(nil)
+}
+
+func (t linearTable) entriesInto(buf []tableEntry) []tableEntry {
+	var nodeMap = t.nodeMap()
+	for idx := uint(0); idx < TableCapacity; idx++ {
+		if nodeMap&(1<<idx) != 0 {
+			buf = append(buf, tableEntry{idx, t.get(idx)})
+		}
+	}
+	return buf
+}
+
+// get() returns the leaf of t at idx; or, when several leafs of t share idx,
+// the table of the Trie that would hold them, which is built for the call.
+func (t linearTable) get(idx uint) nodeI {
+	var node nodeI
+	for _, l := range t.leafs {
+		if l.Hash60().Index(0) != idx {
+			continue
+		}
+		switch n := node.(type) {
+		case nil:
+			node = l
+		case leafI:
+			node = createTable(FullTableInit, 1, n, l)
+		case tableI:
+			node = insertLeaf(n, 1, l)
+		}
+	}
+	return node
+}
+
+// without() returns the leafs of t that are not at idx.
+func (t linearTable) without(idx uint) []leafI {
+	var leafs = make([]leafI, 0, len(t.leafs))
+	for _, l := range t.leafs {
+		if l.Hash60().Index(0) != idx {
+			leafs = append(leafs, l)
+		}
+	}
+	return leafs
+}
+
+// with() returns a linearTable of leafs and the leaf l, kept sorted.
+func with(leafs []leafI, l leafI) *linearTable {
+	var nt = &linearTable{leafs: leafs}
+	var i, _ = nt.find(l.Hash60())
+	nt.leafs = make([]leafI, len(leafs)+1)
+	copy(nt.leafs, leafs[:i])
+	nt.leafs[i] = l
+	copy(nt.leafs[i+1:], leafs[i:])
+	return nt
+}
+
+// insert() returns a linearTable with the leaf entry added; a table entry can
+// not be held by a linearTable, so then the root table of the Trie is
+// returned.
+func (t linearTable) insert(idx uint, entry nodeI) tableI {
+	if l, isLeaf := entry.(leafI); isLeaf {
+		return with(t.leafs, l)
+	}
+	return t.trie().insert(idx, entry)
+}
+
+// replace() returns a linearTable with the leafs at idx replaced by the leaf
+// entry; like insert(), a table entry turns t into a Trie.
+func (t linearTable) replace(idx uint, entry nodeI) tableI {
+	if l, isLeaf := entry.(leafI); isLeaf {
+		return with(t.without(idx), l)
+	}
+	return t.trie().replace(idx, entry)
+}
+
+// remove() returns a linearTable without the leafs at idx, or nil if those
+// were its last leafs.
+func (t linearTable) remove(idx uint) tableI {
+	var leafs = t.without(idx)
+	if len(leafs) == 0 {
+		return nil
+	}
+	return &linearTable{leafs: leafs}
+}
@@ -1,16 +1,19 @@
 package hamt64
 
 import (
+	"sync"
+
 	"github.com/lleo/go-hamt-key"
 )
 
 // nodeI is the interface for every entry in a table; so table entries are
 // either a leaf or a table or nil.
 //
-// The nodeI interface can be for compressedTable, fullTable, flatLeaf, or
-// collisionLeaf.
+// The nodeI interface can be for compressedTable, fullTable, chainTable,
+// linearTable, flatLeaf, collisionLeaf, or overflowLeaf.
 //
-// The tableI interface is for compressedTable and fullTable.
+// The tableI interface is for compressedTable, fullTable, chainTable, and
+// linearTable.
 //
 // The Hash60() method for leaf structs is the 60 most significant bits of
 // the keys hash.
@@ -32,6 +35,7 @@ type leafI interface {
 	get(key key.Key) (interface{}, bool)
 	put(key key.Key, val interface{}) (leafI, bool) //bool == added? key/val pair
 	del(key key.Key) (leafI, interface{}, bool)     //bool == deleted? key
+	getStr(s string) (interface{}, bool)
 	keyVals() []key.KeyVal
 }
 
@@ -60,6 +64,9 @@ type tableI interface {
 	// from lowest index to highest.
 	entries() []tableEntry
 
+	// entriesInto() is entries() appended to buf; the same ordering applies.
+	entriesInto(buf []tableEntry) []tableEntry
+
 	get(idx uint) nodeI
 
 	insert(idx uint, entry nodeI) tableI
@@ -71,3 +78,28 @@ type tableEntry struct {
 	idx  uint
 	node nodeI
 }
+
+// tableEntryPool holds *[]tableEntry buffers, with TableCapacity capacity, for
+// table upgrades/downgrades. This saves allocating a []tableEntry every time a
+// table changes type during a bulk build.
+var tableEntryPool = sync.Pool{
+	New: func() interface{} {
+		var buf = make([]tableEntry, 0, TableCapacity)
+		return &buf
+	},
+}
+
+func getTableEntryBuf() *[]tableEntry {
+	return tableEntryPool.Get().(*[]tableEntry)
+}
+
+// putTableEntryBuf() clears the buffer, so it does not keep nodes from being
+// garbage collected, and returns it to the pool.
+func putTableEntryBuf(bufp *[]tableEntry) {
+	var buf = (*bufp)[:cap(*bufp)]
+	for i := range buf {
+		buf[i] = tableEntry{}
+	}
+	*bufp = buf[:0]
+	tableEntryPool.Put(bufp)
+}
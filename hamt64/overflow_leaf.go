@@ -0,0 +1,112 @@
+package hamt64
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// overflowLeaf is a collisionLeaf with too many key/val pairs to search
+// linearly. Every key in an overflowLeaf has the same Hash60(), so they are
+// stored in a nested Hamt indexed by a rehash of each key; see rehashKey and
+// CollisionOverflowThreshold.
+type overflowLeaf struct {
+	hash60 key.HashVal60
+	kvs    Hamt
+}
+
+// rehashKey is a key.Key stored in the nested Hamt of an overflowLeaf. Its
+// Hash60() is the Hash30() of the key it wraps, a second, unrelated hash of
+// the key, mixed into 60 bits like FoldMix does.
+type rehashKey struct {
+	key.Key
+	hash60 key.HashVal60
+}
+
+func newRehashKey(k key.Key) *rehashKey {
+	var h64 = bits.Reverse64(uint64(k.Hash30()) * goldenRatio64)
+	return &rehashKey{k, key.HashVal60(h64 & mask60)}
+}
+
+func (k *rehashKey) Hash60() key.HashVal60 {
+	return k.hash60
+}
+
+func (k *rehashKey) Equals(k1 key.Key) bool {
+	if rk, isRehashed := k1.(*rehashKey); isRehashed {
+		k1 = rk.Key
+	}
+	return k.Key.Equals(k1)
+}
+
+func newOverflowLeaf(kvs []key.KeyVal) *overflowLeaf {
+	var leaf = new(overflowLeaf)
+	leaf.hash60 = kvs[0].Key.Hash60()
+	for _, kv := range kvs {
+		leaf.kvs, _ = leaf.kvs.Put(newRehashKey(kv.Key), kv.Val)
+	}
+	return leaf
+}
+
+func (l overflowLeaf) Hash60() key.HashVal60 {
+	// valid because ALL keys in l.kvs MUST have the same key.HashVal60
+	return l.hash60
+}
+
+func (l overflowLeaf) String() string {
+	return fmt.Sprintf("overflowLeaf{hash60:%s, kvs:%s}", l.hash60, l.kvs)
+}
+
+func (l overflowLeaf) get(k key.Key) (interface{}, bool) {
+	return l.kvs.Get(newRehashKey(k))
+}
+
+func (l overflowLeaf) getStr(s string) (interface{}, bool) {
+	return l.get(stringkey.New(s))
+}
+
+func (l overflowLeaf) put(k key.Key, v interface{}) (leafI, bool) {
+	var nl = new(overflowLeaf)
+	nl.hash60 = l.hash60
+
+	var added bool
+	nl.kvs, added = l.kvs.Put(newRehashKey(k), v)
+
+	return nl, added
+}
+
+// del() converts back to a collisionLeaf, or a flatLeaf, when the number of
+// key/val pairs drops to CollisionOverflowThreshold.
+func (l overflowLeaf) del(k key.Key) (leafI, interface{}, bool) {
+	var nkvs, val, deleted = l.kvs.Del(newRehashKey(k))
+	if !deleted {
+		return nil, nil, false
+	}
+
+	var nl = new(overflowLeaf)
+	nl.hash60 = l.hash60
+	nl.kvs = nkvs
+
+	if nkvs.Nentries() == 1 {
+		var kv = nl.keyVals()[0]
+		return createLeaf(kv.Key, kv.Val), val, true
+	}
+
+	if nkvs.Nentries() <= CollisionOverflowThreshold {
+		return newCollisionLeaf(nl.keyVals()), val, true
+	}
+
+	return nl, val, true
+}
+
+// keyVals() returns the key/val pairs with the keys that were Put; not the
+// rehashKeys of the nested Hamt.
+func (l overflowLeaf) keyVals() []key.KeyVal {
+	var kvs = l.kvs.KeyVals()
+	for i := range kvs {
+		kvs[i].Key = kvs[i].Key.(*rehashKey).Key
+	}
+	return kvs
+}
@@ -0,0 +1,62 @@
+package hamt64
+
+import (
+	"bytes"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// rawKey is the key.Key created by Hamt.PutRaw() from the caller's key bytes
+// and precomputed hash. A rawKey only Equals() another rawKey with the same
+// bytes. Its Hash30(), which the caller does not give, is hashed from the key
+// bytes; so colliding rawKeys are told apart by it like other keys are.
+type rawKey struct {
+	bytes  []byte
+	hash60 key.HashVal60
+	hash30 key.HashVal30
+}
+
+func newRawKey(keyBytes []byte, h60 uint64) *rawKey {
+	var k = new(rawKey)
+	// copy keyBytes, the caller may reuse it; Hamts are immutable.
+	k.bytes = append([]byte(nil), keyBytes...)
+	k.hash60 = key.HashVal60(h60)
+	k.hash30 = hashBytes30(k.bytes)
+	return k
+}
+
+const (
+	fnvOffset32 uint32 = 2166136261
+	fnvPrime32  uint32 = 16777619
+	mask30      uint32 = 1<<30 - 1
+)
+
+// hashBytes30() is a 30 bit FNV-1 hash of b, folded like hashString60() folds
+// its 64 bit hash.
+func hashBytes30(b []byte) key.HashVal30 {
+	var h32 = fnvOffset32
+	for i := 0; i < len(b); i++ {
+		h32 *= fnvPrime32
+		h32 ^= uint32(b[i])
+	}
+	return key.HashVal30((h32 >> 30) ^ (h32 & mask30))
+}
+
+func (k *rawKey) Equals(k1 key.Key) bool {
+	var rk, ok = k1.(*rawKey)
+	return ok && k.hash60 == rk.hash60 && bytes.Equal(k.bytes, rk.bytes)
+}
+
+// Hash30() is hashed from the key bytes; only the 60 bit hash was given to
+// Hamt.PutRaw().
+func (k *rawKey) Hash30() key.HashVal30 {
+	return k.hash30
+}
+
+func (k *rawKey) Hash60() key.HashVal60 {
+	return k.hash60
+}
+
+func (k *rawKey) String() string {
+	return string(k.bytes)
+}
@@ -0,0 +1,159 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config is a setting of the package variables that tune every Hamt of this
+// package; see SuggestConfig.
+type Config struct {
+	GradeTables                bool
+	FullTableInit              bool
+	UpgradeThreshold           uint
+	DowngradeThreshold         uint
+	LinearThreshold            uint
+	CollisionOverflowThreshold uint
+
+	// UseHamt32 suggests that the keys would be better stored in a
+	// hamt32.Hamt; no package variable is set by it.
+	UseHamt32 bool
+}
+
+// CurrentConfig returns the current setting of the package variables.
+func CurrentConfig() Config {
+	return Config{
+		GradeTables:                GradeTables,
+		FullTableInit:              FullTableInit,
+		UpgradeThreshold:           UpgradeThreshold,
+		DowngradeThreshold:         DowngradeThreshold,
+		LinearThreshold:            LinearThreshold,
+		CollisionOverflowThreshold: CollisionOverflowThreshold,
+	}
+}
+
+// Apply sets the package variables to c. Like the variables themselves, it
+// should not be called during the lifetime of any Hamt.
+func (c Config) Apply() {
+	GradeTables = c.GradeTables
+	FullTableInit = c.FullTableInit
+	UpgradeThreshold = c.UpgradeThreshold
+	DowngradeThreshold = c.DowngradeThreshold
+	LinearThreshold = c.LinearThreshold
+	CollisionOverflowThreshold = c.CollisionOverflowThreshold
+}
+
+// suggestHamt32Max is the most entries SuggestConfig suggests storing in a
+// hamt32.Hamt; few enough that their 30 bit hashes rarely collide.
+const suggestHamt32Max = 1 << 12
+
+// WithConfig returns h forked for diagnostics; with cfg as its SuggestBaseline,
+// and a new, zeroed, Metrics that the returned Hamt, and every Hamt derived
+// from it, counts into; whether or not h counts into Metrics. The returned
+// Hamt shares the whole Trie of h, no table is copied; so a Hamt can be forked
+// without being rebuilt. Like WithSuggestBaseline, cfg does not set the
+// package variables that Put, Del, and the grading of tables follow; see
+// Apply.
+func (h Hamt) WithConfig(cfg Config) Hamt {
+	h = h.WithSuggestBaseline(cfg)
+	h.metrics = new(Metrics)
+	return h
+}
+
+// WithSuggestBaseline returns h with cfg as the Config SuggestConfig starts
+// from, rather than CurrentConfig. It is only a baseline for suggestions: Put,
+// Del, and the grading of tables still follow the package variables, which cfg
+// does not set; see Apply. Every Hamt derived from the returned Hamt keeps
+// cfg.
+func (h Hamt) WithSuggestBaseline(cfg Config) Hamt {
+	h.suggestBase = &cfg
+	return h
+}
+
+// SuggestBaseline returns the Config SuggestConfig starts from; the one given
+// by WithSuggestBaseline, or CurrentConfig if there is none.
+func (h Hamt) SuggestBaseline() Config {
+	if h.suggestBase == nil {
+		return CurrentConfig()
+	}
+	return *h.suggestBase
+}
+
+// suggestOverflowAt is the CollisionOverflowThreshold SuggestConfig suggests
+// for a Hamt with bigger collision leafs.
+const suggestOverflowAt = 8
+
+// SuggestConfig returns the SuggestBaseline of h changed to suit the shape of
+// the Hamt; its average leaf depth, collision leafs, and table composition,
+// as reported by Report. SuggestConfigReason explains the changes.
+func (h Hamt) SuggestConfig() Config {
+	var cfg, _ = h.suggestConfig()
+	return cfg
+}
+
+// SuggestConfigReason returns why SuggestConfig suggests what it does; one
+// line per change, or one line saying why there is none.
+func (h Hamt) SuggestConfigReason() string {
+	var _, reasons = h.suggestConfig()
+	return strings.Join(reasons, "\n")
+}
+
+func (h Hamt) suggestConfig() (Config, []string) {
+	var cfg = h.SuggestBaseline()
+	if h.IsEmpty() {
+		return cfg, []string{"the Hamt is empty; there is nothing to go by"}
+	}
+
+	var r report
+	r.table(h.root, 0)
+
+	var numLeafs = r.flatLeafs + r.collLeafs + r.overLeafs
+	var avgDepth = float64(r.leafDepthSum) / float64(numLeafs)
+
+	var reasons []string
+	var suggest = func(format string, args ...interface{}) {
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+
+	if r.maxCollision == 1 && avgDepth < 2 && h.nentries <= suggestHamt32Max {
+		cfg.UseHamt32 = true
+		suggest("%d entries at an average leaf depth of %.2f; the "+
+			"smaller tables of a hamt32.Hamt waste less space", h.nentries, avgDepth)
+	}
+	if r.maxCollision > suggestOverflowAt &&
+		(cfg.CollisionOverflowThreshold == 0 ||
+			cfg.CollisionOverflowThreshold > suggestOverflowAt) {
+		cfg.CollisionOverflowThreshold = suggestOverflowAt
+		suggest("the biggest collision leaf holds %d key/val pairs; "+
+			"CollisionOverflowThreshold %d searches them by a second hash",
+			r.maxCollision, suggestOverflowAt)
+	}
+
+	if !cfg.FullTableInit && r.fullTables > 0 && r.fullTables >= 2*r.compTables {
+		cfg.FullTableInit = true
+		suggest("%d of %d tables are fullTables; FullTableInit "+
+			"saves upgrading them", r.fullTables, r.fullTables+r.compTables)
+	}
+
+	if !cfg.GradeTables && 2*r.sparseFulls > r.fullTables {
+		cfg.GradeTables = true
+		cfg.FullTableInit = false
+		suggest("%d of %d fullTables hold fewer than DowngradeThreshold "+
+			"entries; GradeTables without FullTableInit stores them "+
+			"compressed", r.sparseFulls, r.fullTables)
+	}
+
+	if !cfg.GradeTables && r.denseComps > 0 {
+		cfg.GradeTables = true
+		suggest("%d compressedTables hold more than UpgradeThreshold "+
+			"entries; GradeTables upgrades them to fullTables", r.denseComps)
+	}
+
+	if reasons == nil {
+		reasons = []string{fmt.Sprintf("the Hamt suits the current "+
+			"configuration; %d entries at an average leaf depth of %.2f",
+			h.nentries, avgDepth)}
+	}
+
+	return cfg, reasons
+}
@@ -1,12 +1,18 @@
 package hamt_test
 
 import (
+	"bytes"
+	"encoding/gob"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -340,6 +346,68 @@ func genRandomizedKvs(kvs []key.KeyVal) []key.KeyVal {
 	return randKvs
 }
 
+// encodedHamt and encodedNode have the field names of the gob encoding
+// written by EncodeStructure in the hamt32 and hamt64 packages; so tests can
+// encode a Trie, malformed or not, for DecodeStructure to read.
+type encodedHamt struct {
+	Nentries uint
+	Root     *encodedNode
+}
+
+type encodedNode struct {
+	Kind     int // 1 is a compressedTable, 2 a flatLeaf
+	HashPath uint64
+	Depth    uint
+	Idxs     []uint
+	Nodes    []encodedNode
+	Keys     []struct{ Str string }
+	Vals     []interface{}
+}
+
+// encodeWithEmptyTables() encodes a Trie with the key "aaa", its val 1, in the
+// root table; along with an empty table and a table whose only entry is an
+// empty table. idx is the root table index of "aaa".
+func encodeWithEmptyTables(idx uint) []byte {
+	var leaf = encodedNode{Kind: 2, Keys: []struct{ Str string }{{"aaa"}}, Vals: []interface{}{1}}
+	var empty1 = encodedNode{Kind: 1, Depth: 1}
+	var empty2 = encodedNode{Kind: 1, Depth: 2}
+	var parent = encodedNode{Kind: 1, Depth: 1, Idxs: []uint{3}, Nodes: []encodedNode{empty2}}
+
+	// root table entries must be in idx order.
+	var root = encodedNode{Kind: 1}
+	var nodes = map[uint]encodedNode{idx: leaf, idx ^ 1: empty1, idx ^ 2: parent}
+	for i := uint(0); i < 64; i++ {
+		if n, found := nodes[i]; found {
+			if n.Depth == 1 {
+				n.HashPath = uint64(i)
+			}
+			root.Idxs = append(root.Idxs, i)
+			root.Nodes = append(root.Nodes, n)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(encodedHamt{Nentries: 1, Root: &root}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeWithIdxs() encodes a Trie whose root compressedTable holds the keys
+// "aaa" and "aab" at the table indexes idxs; for idxs that are not ascending,
+// the nodeMap could not have a bit set for each of its nodes.
+func encodeWithIdxs(idxs []uint) []byte {
+	var aaa = encodedNode{Kind: 2, Keys: []struct{ Str string }{{"aaa"}}, Vals: []interface{}{1}}
+	var aab = encodedNode{Kind: 2, Keys: []struct{ Str string }{{"aab"}}, Vals: []interface{}{2}}
+	var root = encodedNode{Kind: 1, Idxs: idxs, Nodes: []encodedNode{aaa, aab}}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(encodedHamt{Nentries: 2, Root: &root}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
 ////First genRandomizedSvs() copies []StrVal passed in. Then it randomizes that
 ////copy in-place. Finnally, it returns the randomized copy.
 //func genRandomizedSvs(svs []StrVal) []StrVal {
@@ -419,3 +487,488 @@ func TestGlobal64(t *testing.T) {
 		t.Fatalf("new h1.Nentries(),%d != 1", h1.Nentries())
 	}
 }
+
+func TestEmpty32(t *testing.T) {
+	var h = hamt.NewHamt32()
+	if !h.IsEmpty() || h != hamt32.Empty || h != (hamt32.Hamt{}) {
+		t.Fatalf("hamt.NewHamt32() => %s; expected the zero hamt32.Hamt", h)
+	}
+
+	// emptied again, with and without options
+	for _, eh := range []hamt32.Hamt{h, hamt32.NewSized(10), hamt32.NewStrict()} {
+		var nh, _ = eh.Put(KVS[0].Key, KVS[0].Val)
+		if nh.IsEmpty() {
+			t.Fatalf("%s.IsEmpty() => true", nh)
+		}
+		nh, _, _ = nh.Del(KVS[0].Key)
+		if !nh.IsEmpty() || nh != eh || nh.Nentries() != 0 {
+			t.Fatalf("emptied %s.IsEmpty() => %t; expected %s", nh, nh.IsEmpty(), eh)
+		}
+	}
+
+	if hamt32.NewSized(10) == hamt32.Empty {
+		t.Fatal("hamt32.NewSized(10) == hamt32.Empty")
+	}
+}
+
+func TestEmpty64(t *testing.T) {
+	var h = hamt.NewHamt64()
+	if !h.IsEmpty() || h != hamt64.Empty || h != (hamt64.Hamt{}) {
+		t.Fatalf("hamt.NewHamt64() => %s; expected the zero hamt64.Hamt", h)
+	}
+
+	// emptied again, with and without options
+	for _, eh := range []hamt64.Hamt{h, hamt64.NewSized(10), hamt64.NewStrict()} {
+		var nh, _ = eh.Put(KVS[0].Key, KVS[0].Val)
+		if nh.IsEmpty() {
+			t.Fatalf("%s.IsEmpty() => true", nh)
+		}
+		nh, _, _ = nh.Del(KVS[0].Key)
+		if !nh.IsEmpty() || nh != eh || nh.Nentries() != 0 {
+			t.Fatalf("emptied %s.IsEmpty() => %t; expected %s", nh, nh.IsEmpty(), eh)
+		}
+	}
+
+	if hamt64.NewSized(10) == hamt64.Empty {
+		t.Fatal("hamt64.NewSized(10) == hamt64.Empty")
+	}
+}
+
+func TestModify32(t *testing.T) {
+	const numWriters = 16
+	const numIncrements = 200
+
+	var p atomic.Pointer[hamt32.Hamt]
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(k key.Key) {
+			defer wg.Done()
+			for i := 0; i < numIncrements; i++ {
+				hamt.Modify32(&p, func(h hamt32.Hamt) hamt32.Hamt {
+					var n, _ = h.Get(k)
+					if n == nil {
+						n = 0
+					}
+					var nh, _ = h.Put(k, n.(int)+1)
+					return nh
+				})
+			}
+		}(stringkey.New(fmt.Sprintf("writer%d", w)))
+	}
+	wg.Wait()
+
+	var h = *p.Load()
+	if h.Nentries() != numWriters {
+		t.Fatalf("h.Nentries(),%d != numWriters,%d", h.Nentries(), numWriters)
+	}
+	for w := 0; w < numWriters; w++ {
+		var k = stringkey.New(fmt.Sprintf("writer%d", w))
+		var n, _ = h.Get(k)
+		if n != numIncrements {
+			t.Fatalf("h.Get(%s),%v != numIncrements,%d", k, n, numIncrements)
+		}
+	}
+}
+
+func TestModify64(t *testing.T) {
+	const numWriters = 16
+	const numIncrements = 200
+
+	var p atomic.Pointer[hamt64.Hamt]
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(k key.Key) {
+			defer wg.Done()
+			for i := 0; i < numIncrements; i++ {
+				hamt.Modify64(&p, func(h hamt64.Hamt) hamt64.Hamt {
+					var n, _ = h.Get(k)
+					if n == nil {
+						n = 0
+					}
+					var nh, _ = h.Put(k, n.(int)+1)
+					return nh
+				})
+			}
+		}(stringkey.New(fmt.Sprintf("writer%d", w)))
+	}
+	wg.Wait()
+
+	var h = *p.Load()
+	if h.Nentries() != numWriters {
+		t.Fatalf("h.Nentries(),%d != numWriters,%d", h.Nentries(), numWriters)
+	}
+	for w := 0; w < numWriters; w++ {
+		var k = stringkey.New(fmt.Sprintf("writer%d", w))
+		var n, _ = h.Get(k)
+		if n != numIncrements {
+			t.Fatalf("h.Get(%s),%v != numIncrements,%d", k, n, numIncrements)
+		}
+	}
+}
+
+func TestDiffString32(t *testing.T) {
+	var a = hamt.NewHamt32()
+	for _, s := range []string{"a", "b", "c", "d"} {
+		a, _ = a.Put(stringkey.New(s), s)
+	}
+
+	var b = a
+	b, _, _ = b.Del(stringkey.New("b"))
+	b, _ = b.Put(stringkey.New("c"), "C")
+	b, _ = b.Put(stringkey.New("e"), "e")
+
+	var want = strings.Join([]string{
+		`- b: b`,
+		`~ c: c -> C`,
+		`+ e: e`,
+	}, "\n")
+	if got := hamt.DiffString32(a, b); got != want {
+		t.Fatalf("DiffString32(a, b) =>\n%s\nwant:\n%s", got, want)
+	}
+	if got := hamt.DiffString32(a, a); got != "" {
+		t.Fatalf("DiffString32(a, a) => %q; want \"\"", got)
+	}
+}
+
+func TestDiffString64(t *testing.T) {
+	var a = hamt.NewHamt64()
+	for _, s := range []string{"a", "b", "c", "d"} {
+		a, _ = a.Put(stringkey.New(s), s)
+	}
+
+	var b = a
+	b, _, _ = b.Del(stringkey.New("b"))
+	b, _ = b.Put(stringkey.New("c"), "C")
+	b, _ = b.Put(stringkey.New("e"), "e")
+
+	var want = strings.Join([]string{
+		`- b: b`,
+		`~ c: c -> C`,
+		`+ e: e`,
+	}, "\n")
+	if got := hamt.DiffString64(a, b); got != want {
+		t.Fatalf("DiffString64(a, b) =>\n%s\nwant:\n%s", got, want)
+	}
+	if got := hamt.DiffString64(a, a); got != "" {
+		t.Fatalf("DiffString64(a, a) => %q; want \"\"", got)
+	}
+}
+
+func TestGetLayered32(t *testing.T) {
+	var kA = stringkey.New("a")
+	var kB = stringkey.New("b")
+
+	var defaults = hamt.NewHamt32()
+	defaults, _ = defaults.Put(kA, "default a")
+	defaults, _ = defaults.Put(kB, "default b")
+
+	var overrides = hamt.NewHamt32()
+	overrides, _ = overrides.Put(kA, "override a")
+
+	var val, found = hamt.GetLayered32(kA, overrides, defaults)
+	if !found || val != "override a" {
+		t.Fatalf("GetLayered32(%s) => (%v, %t); expected shadowed value", kA, val, found)
+	}
+
+	val, found = hamt.GetLayered32(kB, overrides, defaults)
+	if !found || val != "default b" {
+		t.Fatalf("GetLayered32(%s) => (%v, %t); expected lower layer value", kB, val, found)
+	}
+
+	val, found = hamt.GetLayered32(stringkey.New("c"), overrides, defaults)
+	if found {
+		t.Fatalf("GetLayered32(\"c\") => (%v, %t); expected not found", val, found)
+	}
+}
+
+func TestGetLayered64(t *testing.T) {
+	var kA = stringkey.New("a")
+	var kB = stringkey.New("b")
+
+	var defaults = hamt.NewHamt64()
+	defaults, _ = defaults.Put(kA, "default a")
+	defaults, _ = defaults.Put(kB, "default b")
+
+	var overrides = hamt.NewHamt64()
+	overrides, _ = overrides.Put(kA, "override a")
+
+	var val, found = hamt.GetLayered64(kA, overrides, defaults)
+	if !found || val != "override a" {
+		t.Fatalf("GetLayered64(%s) => (%v, %t); expected shadowed value", kA, val, found)
+	}
+
+	val, found = hamt.GetLayered64(kB, overrides, defaults)
+	if !found || val != "default b" {
+		t.Fatalf("GetLayered64(%s) => (%v, %t); expected lower layer value", kB, val, found)
+	}
+
+	val, found = hamt.GetLayered64(stringkey.New("c"), overrides, defaults)
+	if found {
+		t.Fatalf("GetLayered64(\"c\") => (%v, %t); expected not found", val, found)
+	}
+}
+
+func TestShareNodes32(t *testing.T) {
+	var a, d hamt32.Hamt
+	for _, kv := range KVS[:10*1024] {
+		a, _ = a.Put(kv.Key, kv.Val)
+		d, _ = d.Put(kv.Key, kv.Val)
+	}
+	var b, _ = a.Put(KVS[10*1024].Key, KVS[10*1024].Val)
+	var c, _, _ = b.Del(KVS[0].Key)
+
+	if !hamt.ShareRoot32(a, a) {
+		t.Fatal("!ShareRoot32(a, a)")
+	}
+	if hamt.ShareRoot32(a, b) || hamt.ShareRoot32(a, d) {
+		t.Fatal("ShareRoot32(a, b) or ShareRoot32(a, d)")
+	}
+	if hamt.ShareRoot32(hamt32.Hamt{}, hamt32.Hamt{}) {
+		t.Fatal("ShareRoot32(empty, empty)")
+	}
+
+	if !hamt.ShareAnyNode32(a, b) || !hamt.ShareAnyNode32(c, a) {
+		t.Fatal("!ShareAnyNode32(a, b) or !ShareAnyNode32(c, a)")
+	}
+	if hamt.ShareAnyNode32(a, d) {
+		t.Fatal("ShareAnyNode32(a, d) for independently built a and d")
+	}
+	if hamt.ShareAnyNode32(a, hamt32.Hamt{}) {
+		t.Fatal("ShareAnyNode32(a, empty)")
+	}
+}
+
+func TestShareNodes64(t *testing.T) {
+	var a, d hamt64.Hamt
+	for _, kv := range KVS[:10*1024] {
+		a, _ = a.Put(kv.Key, kv.Val)
+		d, _ = d.Put(kv.Key, kv.Val)
+	}
+	var b, _ = a.Put(KVS[10*1024].Key, KVS[10*1024].Val)
+	var c, _, _ = b.Del(KVS[0].Key)
+
+	if !hamt.ShareRoot64(a, a) {
+		t.Fatal("!ShareRoot64(a, a)")
+	}
+	if hamt.ShareRoot64(a, b) || hamt.ShareRoot64(a, d) {
+		t.Fatal("ShareRoot64(a, b) or ShareRoot64(a, d)")
+	}
+	if hamt.ShareRoot64(hamt64.Hamt{}, hamt64.Hamt{}) {
+		t.Fatal("ShareRoot64(empty, empty)")
+	}
+
+	if !hamt.ShareAnyNode64(a, b) || !hamt.ShareAnyNode64(c, a) {
+		t.Fatal("!ShareAnyNode64(a, b) or !ShareAnyNode64(c, a)")
+	}
+	if hamt.ShareAnyNode64(a, d) {
+		t.Fatal("ShareAnyNode64(a, d) for independently built a and d")
+	}
+	if hamt.ShareAnyNode64(a, hamt64.Hamt{}) {
+		t.Fatal("ShareAnyNode64(a, empty)")
+	}
+}
+
+func setOfStrings32(strs ...string) hamt.Set32 {
+	var s hamt.Set32
+	for _, str := range strs {
+		s, _ = s.Add(stringkey.New(str))
+	}
+	return s
+}
+
+func assertSet32(t *testing.T, name string, s hamt.Set32, strs ...string) {
+	if s.Len() != uint(len(strs)) {
+		t.Fatalf("%s: s.Len(),%d != %d", name, s.Len(), len(strs))
+	}
+	for _, str := range strs {
+		if !s.Contains(stringkey.New(str)) {
+			t.Fatalf("%s: !s.Contains(%q)", name, str)
+		}
+	}
+}
+
+func TestSet32(t *testing.T) {
+	var empty hamt.Set32
+	assertSet32(t, "empty", empty)
+
+	var s, added = empty.Add(stringkey.New("a"))
+	if !added {
+		t.Fatal("failed to s.Add(\"a\")")
+	}
+	if _, added = s.Add(stringkey.New("a")); added {
+		t.Fatal("s.Add(\"a\") added a second time")
+	}
+	assertSet32(t, "empty unchanged", empty)
+
+	var removed bool
+	if _, removed = empty.Remove(stringkey.New("a")); removed {
+		t.Fatal("empty.Remove(\"a\") removed")
+	}
+	if s, removed = s.Remove(stringkey.New("a")); !removed {
+		t.Fatal("failed to s.Remove(\"a\")")
+	}
+	assertSet32(t, "removed", s)
+
+	var ab = setOfStrings32("a", "b")
+	var bc = setOfStrings32("b", "c")
+
+	assertSet32(t, "union", ab.Union(bc), "a", "b", "c")
+	assertSet32(t, "intersect", ab.Intersect(bc), "b")
+	assertSet32(t, "diff", ab.Diff(bc), "a")
+
+	assertSet32(t, "union empty", ab.Union(empty), "a", "b")
+	assertSet32(t, "empty union", empty.Union(ab), "a", "b")
+	assertSet32(t, "intersect empty", ab.Intersect(empty))
+	assertSet32(t, "diff empty", ab.Diff(empty), "a", "b")
+	assertSet32(t, "empty diff", empty.Diff(ab))
+
+	assertSet32(t, "operands unchanged", ab, "a", "b")
+}
+
+func setOfStrings64(strs ...string) hamt.Set64 {
+	var s hamt.Set64
+	for _, str := range strs {
+		s, _ = s.Add(stringkey.New(str))
+	}
+	return s
+}
+
+func assertSet64(t *testing.T, name string, s hamt.Set64, strs ...string) {
+	if s.Len() != uint(len(strs)) {
+		t.Fatalf("%s: s.Len(),%d != %d", name, s.Len(), len(strs))
+	}
+	for _, str := range strs {
+		if !s.Contains(stringkey.New(str)) {
+			t.Fatalf("%s: !s.Contains(%q)", name, str)
+		}
+	}
+}
+
+func TestSet64(t *testing.T) {
+	var empty hamt.Set64
+	assertSet64(t, "empty", empty)
+
+	var s, added = empty.Add(stringkey.New("a"))
+	if !added {
+		t.Fatal("failed to s.Add(\"a\")")
+	}
+	if _, added = s.Add(stringkey.New("a")); added {
+		t.Fatal("s.Add(\"a\") added a second time")
+	}
+	assertSet64(t, "empty unchanged", empty)
+
+	var removed bool
+	if _, removed = empty.Remove(stringkey.New("a")); removed {
+		t.Fatal("empty.Remove(\"a\") removed")
+	}
+	if s, removed = s.Remove(stringkey.New("a")); !removed {
+		t.Fatal("failed to s.Remove(\"a\")")
+	}
+	assertSet64(t, "removed", s)
+
+	var ab = setOfStrings64("a", "b")
+	var bc = setOfStrings64("b", "c")
+
+	assertSet64(t, "union", ab.Union(bc), "a", "b", "c")
+	assertSet64(t, "intersect", ab.Intersect(bc), "b")
+	assertSet64(t, "diff", ab.Diff(bc), "a")
+
+	assertSet64(t, "union empty", ab.Union(empty), "a", "b")
+	assertSet64(t, "empty union", empty.Union(ab), "a", "b")
+	assertSet64(t, "intersect empty", ab.Intersect(empty))
+	assertSet64(t, "diff empty", ab.Diff(empty), "a", "b")
+	assertSet64(t, "empty diff", empty.Diff(ab))
+
+	assertSet64(t, "operands unchanged", ab, "a", "b")
+}
+
+func TestSet32Contains(t *testing.T) {
+	var name = "TestSet32Contains"
+	StartTime[name] = time.Now()
+
+	var s hamt.Set32
+	for _, kv := range KVS {
+		s, _ = s.Add(kv.Key)
+	}
+
+	if s.Len() != uint(len(KVS)) {
+		t.Fatalf("s.Len(),%d != len(KVS),%d", s.Len(), len(KVS))
+	}
+	for _, kv := range KVS {
+		if !s.Contains(kv.Key) {
+			t.Fatalf("!s.Contains(%s)", kv.Key)
+		}
+	}
+	if s.Contains(stringkey.New("0 not a key")) {
+		t.Fatal("s.Contains(\"0 not a key\")")
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+func TestSet64Contains(t *testing.T) {
+	var name = "TestSet64Contains"
+	StartTime[name] = time.Now()
+
+	var s hamt.Set64
+	for _, kv := range KVS {
+		s, _ = s.Add(kv.Key)
+	}
+
+	if s.Len() != uint(len(KVS)) {
+		t.Fatalf("s.Len(),%d != len(KVS),%d", s.Len(), len(KVS))
+	}
+	for _, kv := range KVS {
+		if !s.Contains(kv.Key) {
+			t.Fatalf("!s.Contains(%s)", kv.Key)
+		}
+	}
+	if s.Contains(stringkey.New("0 not a key")) {
+		t.Fatal("s.Contains(\"0 not a key\")")
+	}
+
+	RunTime[name] = time.Since(StartTime[name])
+}
+
+// TestNoLogOutput checks that, with the default loggers, normal Hamt
+// operations write nothing to stderr; even with the standard logger, which
+// TestMain points at test.log, pointed at stderr.
+func TestNoLogOutput(t *testing.T) {
+	var r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saveStderr = os.Stderr
+	var saveLogOutput = log.Writer()
+	os.Stderr = w
+	log.SetOutput(w)
+
+	var h32 hamt32.Hamt
+	var h64 hamt64.Hamt
+	var kvs = append(KVS[:1024:1024],
+		key.KeyVal{Key: stringkey.New("ewwd"), Val: 0}, key.KeyVal{Key: stringkey.New("fwdyy"), Val: 1})
+	for _, kv := range kvs {
+		h32, _ = h32.Put(kv.Key, kv.Val)
+		h64, _ = h64.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs {
+		h32.Get(kv.Key)
+		h64.Get(kv.Key)
+		h32, _, _ = h32.Del(kv.Key)
+		h64, _, _ = h64.Del(kv.Key)
+	}
+
+	os.Stderr = saveStderr
+	log.SetOutput(saveLogOutput)
+	w.Close()
+
+	var out, _ = io.ReadAll(r)
+	if len(out) != 0 {
+		t.Fatalf("unexpected output to stderr: %q", out)
+	}
+}
@@ -0,0 +1,163 @@
+package hamt
+
+import (
+	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+)
+
+// Set32 is a functional (immutable & persistent) set of keys backed by a
+// hamt32.Hamt. Every key is stored with a nil value, so the Hamt uses its
+// value-free leafs. Like hamt32.Hamt the zero value is an empty set, and every
+// modifying method returns a new Set32.
+type Set32 struct {
+	h hamt32.Hamt
+}
+
+// Add returns a Set32 containing k, and whether k was added (true) or was
+// already in the set (false).
+func (s Set32) Add(k key.Key) (Set32, bool) {
+	var h, added = s.h.Put(k, nil)
+	if !added {
+		return s, false
+	}
+	return Set32{h}, true
+}
+
+// Contains returns whether k is in the set.
+func (s Set32) Contains(k key.Key) bool {
+	var _, found = s.h.Get(k)
+	return found
+}
+
+// Remove returns a Set32 without k, and whether k was in the set.
+func (s Set32) Remove(k key.Key) (Set32, bool) {
+	var h, _, deleted = s.h.Del(k)
+	return Set32{h}, deleted
+}
+
+// Len returns the number of keys in the set.
+func (s Set32) Len() uint {
+	return s.h.Nentries()
+}
+
+// Keys returns the keys of the set in hash path order.
+func (s Set32) Keys() []key.Key {
+	var kvs = s.h.KeyVals()
+	var keys = make([]key.Key, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	return keys
+}
+
+// Union returns a Set32 of the keys in either s or o.
+func (s Set32) Union(o Set32) Set32 {
+	if o.Len() > s.Len() {
+		s, o = o, s
+	}
+	for _, k := range o.Keys() {
+		s, _ = s.Add(k)
+	}
+	return s
+}
+
+// Intersect returns a Set32 of the keys in both s and o.
+func (s Set32) Intersect(o Set32) Set32 {
+	if o.Len() < s.Len() {
+		s, o = o, s
+	}
+	var r Set32
+	for _, k := range s.Keys() {
+		if o.Contains(k) {
+			r, _ = r.Add(k)
+		}
+	}
+	return r
+}
+
+// Diff returns a Set32 of the keys in s that are not in o.
+func (s Set32) Diff(o Set32) Set32 {
+	for _, k := range o.Keys() {
+		s, _ = s.Remove(k)
+	}
+	return s
+}
+
+// Set64 is a functional (immutable & persistent) set of keys backed by a
+// hamt64.Hamt. Every key is stored with a nil value, so the Hamt uses its
+// value-free leafs. Like hamt64.Hamt the zero value is an empty set, and every
+// modifying method returns a new Set64.
+type Set64 struct {
+	h hamt64.Hamt
+}
+
+// Add returns a Set64 containing k, and whether k was added (true) or was
+// already in the set (false).
+func (s Set64) Add(k key.Key) (Set64, bool) {
+	var h, added = s.h.Put(k, nil)
+	if !added {
+		return s, false
+	}
+	return Set64{h}, true
+}
+
+// Contains returns whether k is in the set.
+func (s Set64) Contains(k key.Key) bool {
+	var _, found = s.h.Get(k)
+	return found
+}
+
+// Remove returns a Set64 without k, and whether k was in the set.
+func (s Set64) Remove(k key.Key) (Set64, bool) {
+	var h, _, deleted = s.h.Del(k)
+	return Set64{h}, deleted
+}
+
+// Len returns the number of keys in the set.
+func (s Set64) Len() uint {
+	return s.h.Nentries()
+}
+
+// Keys returns the keys of the set in hash path order.
+func (s Set64) Keys() []key.Key {
+	var kvs = s.h.KeyVals()
+	var keys = make([]key.Key, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	return keys
+}
+
+// Union returns a Set64 of the keys in either s or o.
+func (s Set64) Union(o Set64) Set64 {
+	if o.Len() > s.Len() {
+		s, o = o, s
+	}
+	for _, k := range o.Keys() {
+		s, _ = s.Add(k)
+	}
+	return s
+}
+
+// Intersect returns a Set64 of the keys in both s and o.
+func (s Set64) Intersect(o Set64) Set64 {
+	if o.Len() < s.Len() {
+		s, o = o, s
+	}
+	var r Set64
+	for _, k := range s.Keys() {
+		if o.Contains(k) {
+			r, _ = r.Add(k)
+		}
+	}
+	return r
+}
+
+// Diff returns a Set64 of the keys in s that are not in o.
+func (s Set64) Diff(o Set64) Set64 {
+	for _, k := range o.Keys() {
+		s, _ = s.Remove(k)
+	}
+	return s
+}
@@ -0,0 +1,295 @@
+package typedhamt
+
+import (
+	"math/bits"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Key is the constraint on the key type of a Map. Every concrete key.Key
+// type, eg. *stringkey.StringKey, satisfies it.
+type Key interface {
+	key.Key
+}
+
+// Map is a persistent Hash Array Mapped Trie from keys of type K to values of
+// type V; a typed counterpart of hamt32.Hamt, with the same 30 bit hash path
+// and MaxDepth. Its keys and values are stored as a K and a V, not as
+// interfaces; so a V is never boxed, and Get, Put, and Del call the Hash30()
+// and Equals() methods of K itself, which the compiler can devirtualize for a
+// K that is not a pointer or interface type. Map is a Trie of its own, rather
+// than a wrapper of a hamt32.Hamt, whose key/val pairs are interfaces; see
+// BenchmarkMapGetIntKey. The zero Map is empty and ready to use. A Map is
+// never modified; Put and Del return a new Map that shares all the tables off
+// the path to the key with the old one.
+type Map[K Key, V any] struct {
+	root     *mapTable[K, V]
+	nentries uint
+}
+
+// mapTable is a compressed table of a Map; bitmap has a bit set for the index
+// of each of its nodes, which are kept in index order.
+type mapTable[K Key, V any] struct {
+	bitmap uint32
+	nodes  []mapNode[K, V]
+}
+
+// mapNode is an entry of a mapTable. It is either a table, or a leaf of the
+// key/val pairs whose keys all have the Hash30() h30.
+type mapNode[K Key, V any] struct {
+	table *mapTable[K, V]
+	h30   key.HashVal30
+	kvs   mapKeyVals[K, V]
+}
+
+type mapKeyVal[K Key, V any] struct {
+	key K
+	val V
+}
+
+// pos() returns the position of the node for idx in t.nodes, and whether
+// there is one; if not, the position a node for idx belongs at.
+func (t *mapTable[K, V]) pos(idx uint) (int, bool) {
+	var bit = uint32(1) << idx
+	return bits.OnesCount32(t.bitmap & (bit - 1)), t.bitmap&bit != 0
+}
+
+// Len returns the number of key/val pairs in the Map.
+func (m Map[K, V]) Len() uint {
+	return m.nentries
+}
+
+// Get returns the value stored for k, and whether k is in the Map. If not, it
+// returns the zero V.
+func (m Map[K, V]) Get(k K) (V, bool) {
+	var h30 = k.Hash30()
+	var t = m.root
+	for depth := uint(0); t != nil; depth++ {
+		var i, found = t.pos(h30.Index(depth))
+		if !found {
+			break
+		}
+		var n = &t.nodes[i]
+		if n.table != nil {
+			t = n.table
+			continue
+		}
+		if n.h30 == h30 {
+			for _, kv := range n.kvs {
+				if kv.key.Equals(k) {
+					return kv.val, true
+				}
+			}
+		}
+		break
+	}
+	var zero V
+	return zero, false
+}
+
+// Put returns a new Map with k stored with v, and whether k was added; false
+// if k was in the Map and only its value was replaced.
+func (m Map[K, V]) Put(k K, v V) (Map[K, V], bool) {
+	var root = m.root
+	if root == nil {
+		root = new(mapTable[K, V])
+	}
+
+	var nm = m
+	var added bool
+	nm.root, added = root.put(0, k.Hash30(), k, v)
+	if added {
+		nm.nentries++
+	}
+	return nm, added
+}
+
+// put() returns a copy of t, at depth, with k stored with v below it; and
+// whether k was added.
+func (t *mapTable[K, V]) put(depth uint, h30 key.HashVal30, k K, v V) (*mapTable[K, V], bool) {
+	var idx = h30.Index(depth)
+	var i, found = t.pos(idx)
+
+	var nt = &mapTable[K, V]{bitmap: t.bitmap}
+	if !found {
+		nt.bitmap |= uint32(1) << idx
+		nt.nodes = make([]mapNode[K, V], len(t.nodes)+1)
+		copy(nt.nodes, t.nodes[:i])
+		nt.nodes[i] = mapNode[K, V]{h30: h30, kvs: mapKeyVals[K, V]{{k, v}}}
+		copy(nt.nodes[i+1:], t.nodes[i:])
+		return nt, true
+	}
+
+	nt.nodes = make([]mapNode[K, V], len(t.nodes))
+	copy(nt.nodes, t.nodes)
+
+	var n = t.nodes[i]
+	var added bool
+	switch {
+	case n.table != nil:
+		var child *mapTable[K, V]
+		child, added = n.table.put(depth+1, h30, k, v)
+		nt.nodes[i] = mapNode[K, V]{table: child}
+	case n.h30 == h30:
+		nt.nodes[i] = mapNode[K, V]{h30: h30, kvs: n.kvs.put(k, v, &added)}
+	default:
+		var leaf = mapNode[K, V]{h30: h30, kvs: mapKeyVals[K, V]{{k, v}}}
+		nt.nodes[i] = mapNode[K, V]{table: pairTable(depth+1, n, leaf)}
+		added = true
+	}
+	return nt, added
+}
+
+// pairTable() returns a new table, at depth, holding the leafs a and b, which
+// have different Hash30()s.
+func pairTable[K Key, V any](depth uint, a, b mapNode[K, V]) *mapTable[K, V] {
+	var ia, ib = a.h30.Index(depth), b.h30.Index(depth)
+	var t = &mapTable[K, V]{bitmap: uint32(1)<<ia | uint32(1)<<ib}
+	switch {
+	case ia == ib:
+		t.nodes = []mapNode[K, V]{{table: pairTable(depth+1, a, b)}}
+	case ia < ib:
+		t.nodes = []mapNode[K, V]{a, b}
+	default:
+		t.nodes = []mapNode[K, V]{b, a}
+	}
+	return t
+}
+
+// Del returns a new Map without k, the value k had, and whether k was
+// deleted. If k is not in the Map, it returns the Map unchanged, the zero V,
+// and false.
+func (m Map[K, V]) Del(k K) (Map[K, V], V, bool) {
+	if m.root == nil {
+		var zero V
+		return m, zero, false
+	}
+
+	var root, val, deleted = m.root.del(0, k.Hash30(), k)
+	if !deleted {
+		return m, val, false
+	}
+
+	var nm = Map[K, V]{root: root, nentries: m.nentries - 1}
+	if nm.nentries == 0 {
+		nm.root = nil
+	}
+	return nm, val, true
+}
+
+// del() returns a copy of t, at depth, without k below it, or nil if k was
+// its last key; the value k had; and whether k was deleted. A child table
+// left with only a leaf is replaced by that leaf.
+func (t *mapTable[K, V]) del(depth uint, h30 key.HashVal30, k K) (*mapTable[K, V], V, bool) {
+	var zero V
+	var idx = h30.Index(depth)
+	var i, found = t.pos(idx)
+	if !found {
+		return nil, zero, false
+	}
+
+	var n = t.nodes[i]
+	var nn mapNode[K, V]
+	var val V
+	switch {
+	case n.table != nil:
+		var child, v, deleted = n.table.del(depth+1, h30, k)
+		if !deleted {
+			return nil, zero, false
+		}
+		val = v
+		if child != nil && len(child.nodes) == 1 && child.nodes[0].table == nil {
+			nn = child.nodes[0]
+		} else if child != nil {
+			nn = mapNode[K, V]{table: child}
+		}
+	case n.h30 == h30:
+		var j = n.kvs.index(k)
+		if j < 0 {
+			return nil, zero, false
+		}
+		val = n.kvs[j].val
+		if len(n.kvs) > 1 {
+			nn = mapNode[K, V]{h30: h30, kvs: n.kvs.del(j)}
+		}
+	default:
+		return nil, zero, false
+	}
+
+	var nt = &mapTable[K, V]{bitmap: t.bitmap}
+	if nn.table == nil && nn.kvs == nil {
+		if len(t.nodes) == 1 {
+			return nil, val, true
+		}
+		nt.bitmap &^= uint32(1) << idx
+		nt.nodes = make([]mapNode[K, V], len(t.nodes)-1)
+		copy(nt.nodes, t.nodes[:i])
+		copy(nt.nodes[i:], t.nodes[i+1:])
+		return nt, val, true
+	}
+
+	nt.nodes = make([]mapNode[K, V], len(t.nodes))
+	copy(nt.nodes, t.nodes)
+	nt.nodes[i] = nn
+	return nt, val, true
+}
+
+// mapKeyVals is the key/val pairs of a leaf; more than one only if their keys
+// collide.
+type mapKeyVals[K Key, V any] []mapKeyVal[K, V]
+
+func (kvs mapKeyVals[K, V]) index(k K) int {
+	for i, kv := range kvs {
+		if kv.key.Equals(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+// put() returns a copy of kvs with k stored with v; *added is set if k was
+// not in kvs.
+func (kvs mapKeyVals[K, V]) put(k K, v V, added *bool) mapKeyVals[K, V] {
+	var i = kvs.index(k)
+	if i < 0 {
+		*added = true
+		return append(kvs[:len(kvs):len(kvs)], mapKeyVal[K, V]{k, v})
+	}
+	var nkvs = make(mapKeyVals[K, V], len(kvs))
+	copy(nkvs, kvs)
+	nkvs[i] = mapKeyVal[K, V]{kvs[i].key, v}
+	return nkvs
+}
+
+// del() returns a copy of kvs without the i'th key/val pair.
+func (kvs mapKeyVals[K, V]) del(i int) mapKeyVals[K, V] {
+	var nkvs = make(mapKeyVals[K, V], len(kvs)-1)
+	copy(nkvs, kvs[:i])
+	copy(nkvs[i:], kvs[i+1:])
+	return nkvs
+}
+
+// Range calls fn for every key/val pair of the Map, in hash path order, until
+// fn returns false.
+func (m Map[K, V]) Range(fn func(k K, v V) bool) {
+	if m.root != nil {
+		m.root.walk(fn)
+	}
+}
+
+func (t *mapTable[K, V]) walk(fn func(k K, v V) bool) bool {
+	for _, n := range t.nodes {
+		if n.table != nil {
+			if !n.table.walk(fn) {
+				return false
+			}
+			continue
+		}
+		for _, kv := range n.kvs {
+			if !fn(kv.key, kv.val) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package typedhamt
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"testing"
+
+	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// intKey is a key.Key that is not a pointer; so Map[intKey, V] calls its
+// Hash30() and Equals() methods directly, where a hamt32.Hamt calls them
+// through the key.Key interface.
+type intKey uint32
+
+func (k intKey) Hash30() key.HashVal30 {
+	return key.HashVal30(bits.Reverse32(uint32(k)*0x9e3779b1) >> 2)
+}
+
+func (k intKey) Hash60() key.HashVal60 {
+	return key.HashVal60(bits.Reverse64(uint64(k)*0x9e3779b97f4a7c15) >> 4)
+}
+
+func (k intKey) Equals(k1 key.Key) bool {
+	var ik, isInt = k1.(intKey)
+	return isInt && ik == k
+}
+
+func (k intKey) String() string {
+	return strconv.FormatUint(uint64(k), 10)
+}
+
+// collidingKey is a stringkey with a constant Hash30(), so every collidingKey
+// ends up in the same leaf of a Map.
+type collidingKey struct {
+	*stringkey.StringKey
+}
+
+func (k collidingKey) Hash30() key.HashVal30 {
+	return key.HashVal30(0x2aaaaaaa)
+}
+
+func (k collidingKey) Equals(k1 key.Key) bool {
+	var ck, ok = k1.(collidingKey)
+	return ok && ck.Str() == k.Str()
+}
+
+func buildStringKeys(n int) []*stringkey.StringKey {
+	var keys = make([]*stringkey.StringKey, n)
+	for i := range keys {
+		keys[i] = stringkey.New(fmt.Sprintf("key%d", i))
+	}
+	return keys
+}
+
+func TestMap(t *testing.T) {
+	var keys = buildStringKeys(4096)
+	var missing = stringkey.New("missing")
+
+	var m Map[*stringkey.StringKey, int]
+	for i, k := range keys {
+		var added bool
+		if m, added = m.Put(k, i); !added {
+			t.Fatalf("m.Put(%s, %d) not added", k, i)
+		}
+	}
+	if m.Len() != uint(len(keys)) {
+		t.Fatalf("m.Len(),%d != %d", m.Len(), len(keys))
+	}
+	for i, k := range keys {
+		if v, found := m.Get(k); !found || v != i {
+			t.Fatalf("m.Get(%s) => %d, %t; want %d, true", k, v, found, i)
+		}
+	}
+	if v, found := m.Get(missing); found || v != 0 {
+		t.Fatalf("m.Get(%s) => %d, %t; want 0, false", missing, v, found)
+	}
+
+	var m1, added = m.Put(keys[0], -1)
+	if v, _ := m1.Get(keys[0]); added || v != -1 || m1.Len() != m.Len() {
+		t.Fatalf("update m.Put(%s, -1) => %d, added=%t", keys[0], v, added)
+	}
+	if v, _ := m.Get(keys[0]); v != 0 {
+		t.Fatalf("m.Get(%s),%d != 0; original Map was modified", keys[0], v)
+	}
+
+	var n uint
+	m.Range(func(k *stringkey.StringKey, v int) bool {
+		if keys[v] != k {
+			t.Fatalf("m.Range() visited %s,%d", k, v)
+		}
+		n++
+		return true
+	})
+	if n != m.Len() {
+		t.Fatalf("m.Range() visited %d key/val pairs; want %d", n, m.Len())
+	}
+
+	for i, k := range keys {
+		var val int
+		var deleted bool
+		if m, val, deleted = m.Del(k); !deleted || val != i {
+			t.Fatalf("m.Del(%s) => %d, %t; want %d, true", k, val, deleted, i)
+		}
+		if _, found := m.Get(k); found {
+			t.Fatalf("m.Get(%s) found after m.Del()", k)
+		}
+	}
+	if m.Len() != 0 {
+		t.Fatalf("m.Len(),%d != 0 after deleting all keys", m.Len())
+	}
+
+	// colliding keys share one leaf
+	var cm Map[collidingKey, int]
+	var ckeys = make([]collidingKey, 8)
+	for i := range ckeys {
+		ckeys[i] = collidingKey{stringkey.New(fmt.Sprintf("colliding%d", i))}
+		cm, _ = cm.Put(ckeys[i], i)
+	}
+	for i, k := range ckeys {
+		if v, found := cm.Get(k); !found || v != i {
+			t.Fatalf("cm.Get(%s) => %d, %t; want %d, true", k, v, found, i)
+		}
+	}
+	for _, k := range ckeys {
+		cm, _, _ = cm.Del(k)
+	}
+	if cm.Len() != 0 {
+		t.Fatalf("cm.Len(),%d != 0 after deleting all keys", cm.Len())
+	}
+}
+
+const benchKeys = 256 * 1024
+
+// BenchmarkMapGet and BenchmarkHamt32Get compare Get of a Map with Get of a
+// hamt32.Hamt holding the same *stringkey.StringKey keys.
+func BenchmarkMapGet(b *testing.B) {
+	var keys = buildStringKeys(benchKeys)
+	var m Map[*stringkey.StringKey, int]
+	for i, k := range keys {
+		m, _ = m.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var j = i % len(keys)
+		if v, _ := m.Get(keys[j]); v != j {
+			b.Fatalf("m.Get(%s),%d != %d", keys[j], v, j)
+		}
+	}
+}
+
+func BenchmarkHamt32Get(b *testing.B) {
+	var keys = buildStringKeys(benchKeys)
+	var h hamt32.Hamt
+	for i, k := range keys {
+		h, _ = h.Put(k, i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var j = i % len(keys)
+		if v, _ := h.Get(keys[j]); v != j {
+			b.Fatalf("h.Get(%s),%v != %d", keys[j], v, j)
+		}
+	}
+}
+
+// BenchmarkMapGetIntKey and BenchmarkHamt32GetIntKey are BenchmarkMapGet and
+// BenchmarkHamt32Get for intKey keys; which are not pointers, so only Map can
+// call their methods directly.
+func BenchmarkMapGetIntKey(b *testing.B) {
+	var m Map[intKey, int]
+	for i := 0; i < benchKeys; i++ {
+		m, _ = m.Put(intKey(i), i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var j = i % benchKeys
+		if v, _ := m.Get(intKey(j)); v != j {
+			b.Fatalf("m.Get(%d),%d != %d", j, v, j)
+		}
+	}
+}
+
+func BenchmarkHamt32GetIntKey(b *testing.B) {
+	var h hamt32.Hamt
+	for i := 0; i < benchKeys; i++ {
+		h, _ = h.Put(intKey(i), i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var j = i % benchKeys
+		if v, _ := h.Get(intKey(j)); v != j {
+			b.Fatalf("h.Get(%d),%v != %d", j, v, j)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Package typedhamt holds typed helpers over the untyped interface{} values of
+hamt32.Hamt; and Map, a generic Hamt whose keys and values are typed.
+*/
+package typedhamt
+
+import (
+	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-key"
+)
+
+// GetAs retrieves the value for k from h as a T. It returns the zero T and
+// false if k is not in h, or if its value is not a T.
+func GetAs[T any](h hamt32.Hamt, k key.Key) (T, bool) {
+	var zero T
+	var v, found = h.Get(k)
+	if !found {
+		return zero, false
+	}
+	var t, isT = v.(T)
+	if !isT {
+		return zero, false
+	}
+	return t, true
+}
@@ -0,0 +1,42 @@
+package typedhamt
+
+import (
+	"testing"
+
+	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+func TestGetAs(t *testing.T) {
+	var keys = buildStringKeys(2048)
+
+	var h hamt32.Hamt
+	for i, k := range keys[:1024] {
+		h, _ = h.Put(k, i)
+	}
+	var sk = stringkey.New("typedhamt string")
+	h, _ = h.Put(sk, "a string")
+
+	for i, k := range keys[:1024] {
+		var v, ok = GetAs[int](h, k)
+		if !ok || v != i {
+			t.Fatalf("GetAs[int](h, %s) => %d, %t; want %d, true", k, v, ok, i)
+		}
+		if s, ok := GetAs[string](h, k); ok || s != "" {
+			t.Fatalf("GetAs[string](h, %s) => %q, %t; want \"\", false", k, s, ok)
+		}
+	}
+
+	if s, ok := GetAs[string](h, sk); !ok || s != "a string" {
+		t.Fatalf("GetAs[string](h, %s) => %q, %t; want \"a string\", true", sk, s, ok)
+	}
+	if v, ok := GetAs[int](h, sk); ok || v != 0 {
+		t.Fatalf("GetAs[int](h, %s) => %d, %t; want 0, false", sk, v, ok)
+	}
+
+	for _, k := range keys[1024:] {
+		if v, ok := GetAs[int](h, k); ok || v != 0 {
+			t.Fatalf("GetAs[int](h, %s) => %d, %t; want 0, false", k, v, ok)
+		}
+	}
+}